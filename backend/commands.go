@@ -0,0 +1,449 @@
+package main
+
+import (
+	"backend/models"
+	"backend/preview"
+	"backend/similarity"
+	"backend/storage"
+	"backend/storagegc"
+	"backend/webpush"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// runCommand runs a maintenance command instead of starting the server
+func runCommand(args []string) error {
+	switch args[0] {
+	case "storage-migrate":
+		return storageMigrateCommand(args[1:])
+	case "preview-backfill":
+		return previewBackfillCommand(args[1:])
+	case "similarity-index":
+		return similarityIndexCommand(args[1:])
+	case "storage-gc":
+		return storageGCCommand(args[1:])
+	case "integrity-check":
+		return integrityCheckCommand(args[1:])
+	case "term-backfill":
+		return termBackfillCommand(args[1:])
+	case "vapid-keys":
+		return vapidKeysCommand()
+	case "fake-push":
+		return fakePushCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// storageMigrateCommand copies every stored object from one backend to another: project files with
+// their previews and thumbnails, and the chunks of unfinished upload sessions.
+// Objects already present in the destination are skipped, so the command can be re-run after a failure.
+func storageMigrateCommand(args []string) error {
+	fs := flag.NewFlagSet("storage-migrate", flag.ExitOnError)
+	from := fs.String("from", "local", "source backend (local or s3)")
+	to := fs.String("to", "s3", "destination backend (local or s3)")
+	dryRun := fs.Bool("dry-run", false, "only list the files that would be copied")
+	fs.Parse(args)
+
+	if *from == *to {
+		return errors.New("storage-migrate: --from and --to must differ")
+	}
+
+	src, err := storage.New(*from)
+	if err != nil {
+		return err
+	}
+	dst, err := storage.New(*to)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var copied, skipped, failed int
+
+	migrate := func(key string) {
+		if _, err := dst.Stat(ctx, key); err == nil {
+			skipped++
+			return
+		}
+
+		if *dryRun {
+			log.Printf("would copy %s", key)
+			copied++
+			return
+		}
+
+		if err := storage.Copy(ctx, src, dst, key); err != nil {
+			log.Printf("Failed to copy %s: %v", key, err)
+			failed++
+			return
+		}
+		copied++
+	}
+
+	// FindInBatches pages by primary key, so no other ordering is applied
+	var files []models.ProjectFile
+	err = db.Model(&models.ProjectFile{}).FindInBatches(&files, 200, func(tx *gorm.DB, batch int) error {
+		for i := range files {
+			for _, key := range files[i].StoredKeys() {
+				migrate(key)
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	// Received chunks of uploads that can still be resumed or assembled
+	var sessions []models.UploadSession
+	err = db.Model(&models.UploadSession{}).Preload("Chunks").
+		Where("status IN ?", []string{"active", "assembling"}).
+		FindInBatches(&sessions, 200, func(tx *gorm.DB, batch int) error {
+			for i := range sessions {
+				for _, chunk := range sessions[i].Chunks {
+					migrate(sessions[i].ChunkKey(chunk.ChunkIndex))
+				}
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return err
+	}
+
+	log.Printf("storage-migrate %s -> %s: copied=%d skipped=%d failed=%d", *from, *to, copied, skipped, failed)
+	if failed > 0 {
+		return fmt.Errorf("storage-migrate: %d files failed", failed)
+	}
+	return nil
+}
+
+// previewBackfillCommand queues previews for files uploaded before preview generation was enabled.
+// The running server's preview worker picks them up.
+func previewBackfillCommand(args []string) error {
+	fs := flag.NewFlagSet("preview-backfill", flag.ExitOnError)
+	retryFailed := fs.Bool("retry-failed", false, "also queue files whose preview failed")
+	dryRun := fs.Bool("dry-run", false, "only count the files that would be queued")
+	fs.Parse(args)
+
+	statuses := []string{models.PreviewNone}
+	if *retryFailed {
+		statuses = append(statuses, models.PreviewFailed)
+	}
+
+	var queued int
+	var files []models.ProjectFile
+
+	err := db.Model(&models.ProjectFile{}).
+		Where("preview_status IN ? AND scan_status <> ?", statuses, "infected").
+		FindInBatches(&files, 200, func(tx *gorm.DB, batch int) error {
+			var ids []string
+			for _, f := range files {
+				if preview.Supported(filepath.Ext(f.FileName)) {
+					ids = append(ids, f.ID)
+				}
+			}
+			if len(ids) == 0 || *dryRun {
+				queued += len(ids)
+				return nil
+			}
+
+			result := db.Model(&models.ProjectFile{}).
+				Where("id IN ?", ids).
+				Updates(map[string]interface{}{
+					"preview_status":   models.PreviewPending,
+					"preview_attempts": 0,
+					"preview_error":    "",
+				})
+			queued += int(result.RowsAffected)
+			return result.Error
+		}).Error
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		log.Printf("preview-backfill: %d files would be queued", queued)
+	} else {
+		log.Printf("preview-backfill: queued %d files", queued)
+	}
+	return nil
+}
+
+// similarityIndexCommand queues every historical report for the similarity index.
+// The running server's checker processes them oldest first.
+func similarityIndexCommand(args []string) error {
+	fs := flag.NewFlagSet("similarity-index", flag.ExitOnError)
+	rebuild := fs.Bool("rebuild", false, "also re-check files that were already indexed")
+	dryRun := fs.Bool("dry-run", false, "only count the files that would be queued")
+	fs.Parse(args)
+
+	statuses := []string{models.SimilarityNone, models.SimilarityFailed}
+	if *rebuild {
+		statuses = append(statuses, models.SimilarityReady)
+	}
+
+	var queued int
+	var files []models.ProjectFile
+
+	err := db.Model(&models.ProjectFile{}).
+		Where("similarity_status IN ? AND scan_status <> ?", statuses, "infected").
+		FindInBatches(&files, 200, func(tx *gorm.DB, batch int) error {
+			var ids []string
+			for _, f := range files {
+				if similarity.Applies(f.FileCategory, filepath.Ext(f.FileName)) {
+					ids = append(ids, f.ID)
+				}
+			}
+			if len(ids) == 0 || *dryRun {
+				queued += len(ids)
+				return nil
+			}
+
+			result := db.Model(&models.ProjectFile{}).
+				Where("id IN ?", ids).
+				Updates(map[string]interface{}{
+					"similarity_status":   models.SimilarityPending,
+					"similarity_attempts": 0,
+					"similarity_error":    "",
+				})
+			queued += int(result.RowsAffected)
+			return result.Error
+		}).Error
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		log.Printf("similarity-index: %d files would be queued", queued)
+	} else {
+		log.Printf("similarity-index: queued %d files", queued)
+	}
+	return nil
+}
+
+// storageGCCommand reconciles the storage backend with the database. Without --apply it only
+// prints what would be cleaned up.
+func storageGCCommand(args []string) error {
+	fs := flag.NewFlagSet("storage-gc", flag.ExitOnError)
+	apply := fs.Bool("apply", false, "delete orphaned objects")
+	dangling := fs.Bool("delete-dangling", false, "also delete file rows whose stored object is missing")
+	grace := fs.Duration("grace", 24*time.Hour, "leave objects and rows younger than this alone")
+	fs.Parse(args)
+
+	store, err := storage.NewFromEnv()
+	if err != nil {
+		return err
+	}
+	reconciler := storagegc.NewReconciler(db, store)
+	reconciler.GracePeriod = *grace
+
+	report, err := reconciler.Run(context.Background(), storagegc.Options{
+		DeleteOrphans:  *apply,
+		DeleteDangling: *apply && *dangling,
+	})
+	if report != nil {
+		for _, o := range report.Orphans {
+			log.Printf("orphan %s (%d bytes, %s)", o.Key, o.Size, o.LastModified.Format(time.RFC3339))
+		}
+		for _, d := range report.Dangling {
+			log.Printf("dangling file %s (%s) in project %s: %s is missing", d.ID, d.FileName, d.ProjectID, d.Key)
+		}
+		log.Printf("storage-gc: objects=%d files=%d orphans=%d (%d bytes) dangling=%d missing_previews=%d recent=%d",
+			report.ScannedObjects, report.ScannedFiles, report.OrphanCount, report.OrphanBytes,
+			report.DanglingCount, report.MissingPreviews, report.RecentSkipped)
+		if !report.DryRun {
+			log.Printf("storage-gc: deleted %d objects (%d bytes) and %d rows, re-queued %d previews, %d failed",
+				report.DeletedObjects, report.DeletedBytes, report.DeletedRows, report.RequeuedPreviews, report.Failed)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("storage-gc: %d deletions failed", report.Failed)
+	}
+	return nil
+}
+
+// integrityCheckCommand re-hashes every stored project file and records whether it still matches its
+// SHA-256. Files uploaded before checksums existed are hashed with --backfill. Blob reference counts
+// are recounted as well.
+func integrityCheckCommand(args []string) error {
+	fs := flag.NewFlagSet("integrity-check", flag.ExitOnError)
+	backfill := fs.Bool("backfill", false, "store the SHA-256 of files that have none")
+	dryRun := fs.Bool("dry-run", false, "only report, do not update the database")
+	fs.Parse(args)
+
+	store, err := storage.NewFromEnv()
+	if err != nil {
+		return err
+	}
+
+	type result struct {
+		sum    string
+		status string
+	}
+	// Files sharing a blob are hashed once
+	checked := map[string]result{}
+
+	ctx := context.Background()
+	var ok, corrupt, missing, unhashed, failed int
+	var files []models.ProjectFile
+
+	// FindInBatches pages by primary key, so no other ordering is applied
+	err = db.Model(&models.ProjectFile{}).
+		Where("scan_status <> ?", "infected").
+		FindInBatches(&files, 200, func(tx *gorm.DB, batch int) error {
+			for _, f := range files {
+				key := f.StorageKey()
+				res, seen := checked[key]
+				if !seen {
+					res = result{status: models.IntegrityOK}
+					r, err := store.Get(ctx, key)
+					if err == storage.ErrNotFound {
+						res.status = models.IntegrityMissing
+					} else if err != nil {
+						log.Printf("Failed to open %s: %v", key, err)
+						failed++
+						continue
+					} else {
+						res.sum, _, err = storage.HashReader(r)
+						r.Close()
+						if err != nil {
+							log.Printf("Failed to read %s: %v", key, err)
+							failed++
+							continue
+						}
+					}
+					checked[key] = res
+				}
+
+				updates := map[string]interface{}{"verified_at": time.Now()}
+				switch {
+				case res.status == models.IntegrityMissing:
+					log.Printf("missing %s (file %s, %s)", key, f.ID, f.FileName)
+					updates["integrity_status"] = models.IntegrityMissing
+					missing++
+				case f.SHA256 == "":
+					if !*backfill {
+						unhashed++
+						continue
+					}
+					updates["sha256"] = res.sum
+					updates["integrity_status"] = models.IntegrityOK
+					ok++
+				case f.SHA256 != res.sum:
+					log.Printf("corrupt %s (file %s, %s): expected %s, got %s", key, f.ID, f.FileName, f.SHA256, res.sum)
+					updates["integrity_status"] = models.IntegrityCorrupt
+					corrupt++
+				default:
+					updates["integrity_status"] = models.IntegrityOK
+					ok++
+				}
+
+				if *dryRun {
+					continue
+				}
+				if err := db.Model(&models.ProjectFile{}).Where("id = ?", f.ID).Updates(updates).Error; err != nil {
+					log.Printf("Failed to record integrity of file %s: %v", f.ID, err)
+					failed++
+				}
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return err
+	}
+
+	// Reference counts are kept by triggers; recount in case rows were changed by hand
+	refCount := "(SELECT COUNT(*) FROM project_files pf WHERE pf.sha256 = file_blobs.sha256 AND pf.file_path = file_blobs.storage_key)"
+	var wrongRefs int64
+	if *dryRun {
+		err = db.Model(&models.FileBlob{}).Where("ref_count <> " + refCount).Count(&wrongRefs).Error
+	} else {
+		fixed := db.Model(&models.FileBlob{}).Where("ref_count <> "+refCount).Update("ref_count", gorm.Expr(refCount))
+		wrongRefs, err = fixed.RowsAffected, fixed.Error
+		if err == nil {
+			err = db.Where("ref_count <= 0").Delete(&models.FileBlob{}).Error
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("integrity-check: ok=%d corrupt=%d missing=%d unhashed=%d failed=%d wrong_blob_refs=%d",
+		ok, corrupt, missing, unhashed, failed, wrongRefs)
+	if corrupt > 0 || missing > 0 || failed > 0 {
+		return fmt.Errorf("integrity-check: %d corrupt, %d missing, %d failed", corrupt, missing, failed)
+	}
+	return nil
+}
+
+// termBackfillCommand records the term of projects created before projects were registered in one,
+// so term exports and announcements include them. The term is derived from the creation date: semester 1
+// runs June to October, semester 2 November to March and the summer semester April and May.
+func termBackfillCommand(args []string) error {
+	fs := flag.NewFlagSet("term-backfill", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only count the projects that would be updated")
+	fs.Parse(args)
+
+	query := db.Model(&models.Project{}).Where("academic_year IS NULL")
+	if *dryRun {
+		var count int64
+		if err := query.Count(&count).Error; err != nil {
+			return err
+		}
+		log.Printf("term-backfill: %d projects would be updated", count)
+		return nil
+	}
+
+	// Buddhist Era years; January to May belong to the academic year that started the June before
+	result := query.UpdateColumns(map[string]interface{}{
+		"academic_year": gorm.Expr("EXTRACT(YEAR FROM created_at)::int + 543 - CASE WHEN EXTRACT(MONTH FROM created_at) < 6 THEN 1 ELSE 0 END"),
+		"semester":      gorm.Expr("CASE WHEN EXTRACT(MONTH FROM created_at) BETWEEN 6 AND 10 THEN 1 WHEN EXTRACT(MONTH FROM created_at) IN (4, 5) THEN 3 ELSE 2 END"),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	log.Printf("term-backfill: updated %d projects", result.RowsAffected)
+	return nil
+}
+
+// vapidKeysCommand prints a new VAPID key pair for VAPID_PUBLIC_KEY and VAPID_PRIVATE_KEY
+func vapidKeysCommand() error {
+	public, private, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("VAPID_PUBLIC_KEY=%s\nVAPID_PRIVATE_KEY=%s\n", public, private)
+	return nil
+}
+
+// fakePushCommand runs webpush.FakeService, a push service that decrypts and records what it
+// receives, and prints a subscription to register with POST /api/push/subscriptions
+func fakePushCommand(args []string) error {
+	fs := flag.NewFlagSet("fake-push", flag.ExitOnError)
+	addr := fs.String("addr", ":8090", "address to listen on")
+	baseURL := fs.String("url", "http://localhost:8090", "URL the backend reaches the service at")
+	fs.Parse(args)
+
+	fake := webpush.NewFakeService(*baseURL)
+	sub, err := fake.NewSubscription()
+	if err != nil {
+		return err
+	}
+	body, _ := json.Marshal(sub)
+	log.Printf("Fake push service on %s; subscription: %s", *addr, body)
+	log.Printf("Received pushes: %s/messages, more subscriptions: POST %s/subscriptions", *baseURL, *baseURL)
+	return http.ListenAndServe(*addr, fake)
+}
@@ -0,0 +1,67 @@
+package events
+
+import (
+	"log"
+	"sync"
+)
+
+// Handler reacts to a published event
+type Handler func(Event)
+
+// queueSize is how many events may wait for delivery before Publish blocks
+const queueSize = 1024
+
+// Bus delivers published events to every subscriber within the process
+type Bus struct {
+	mu       sync.RWMutex
+	handlers []Handler
+	queue    chan Event
+}
+
+// NewBus starts the goroutine that delivers the bus's events
+func NewBus() *Bus {
+	b := &Bus{queue: make(chan Event, queueSize)}
+	go b.run()
+	return b
+}
+
+// Subscribe registers h for every event published afterwards
+func (b *Bus) Subscribe(h Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, h)
+}
+
+// Publish queues the event and returns without waiting for the subscribers, so a slow
+// subscriber does not hold up e.g. a chat connection. Publish only blocks while the queue is full.
+// A nil bus drops the event.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	b.queue <- e
+}
+
+// run delivers events one at a time in publish order, each to the subscribers in subscription
+// order, so a subscriber sees the effects of its handling of earlier events.
+// A panicking subscriber is logged and does not stop the others.
+func (b *Bus) run() {
+	for e := range b.queue {
+		b.mu.RLock()
+		handlers := b.handlers
+		b.mu.RUnlock()
+
+		for _, h := range handlers {
+			dispatch(h, e)
+		}
+	}
+}
+
+func dispatch(h Handler, e Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Event handler for %s panicked: %v", e.Name(), r)
+		}
+	}()
+	h(e)
+}
@@ -0,0 +1,63 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPublishDoesNotWaitForSubscribers(t *testing.T) {
+	bus := NewBus()
+	release := make(chan struct{})
+	delivered := make(chan string, 3)
+	bus.Subscribe(func(e Event) {
+		<-release
+		delivered <- e.(ProjectApproved).ProjectID
+	})
+
+	done := make(chan struct{})
+	go func() {
+		for _, id := range []string{"a", "b", "c"} {
+			bus.Publish(ProjectApproved{ProjectID: id})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish waited for a blocked subscriber")
+	}
+
+	close(release)
+	for _, want := range []string{"a", "b", "c"} {
+		select {
+		case got := <-delivered:
+			if got != want {
+				t.Fatalf("delivered %s, want %s", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s was not delivered", want)
+		}
+	}
+}
+
+func TestPanickingSubscriber(t *testing.T) {
+	bus := NewBus()
+	delivered := make(chan Event, 2)
+	bus.Subscribe(func(Event) { panic("broken subscriber") })
+	bus.Subscribe(func(e Event) { delivered <- e })
+
+	bus.Publish(ProjectApproved{ProjectID: "a"})
+	bus.Publish(ProjectRejected{ProjectID: "b"})
+	for i := 0; i < 2; i++ {
+		select {
+		case <-delivered:
+		case <-time.After(time.Second):
+			t.Fatal("a panicking subscriber stopped delivery")
+		}
+	}
+}
+
+func TestNilBus(t *testing.T) {
+	var bus *Bus
+	bus.Publish(ProjectApproved{ProjectID: "a"})
+}
@@ -0,0 +1,67 @@
+package events
+
+import "time"
+
+// Event is something that happened in the domain. Publish it only after the change it
+// describes has been committed, so subscribers never see work that is rolled back.
+type Event interface {
+	Name() string
+}
+
+// ProjectApproved is published when an advisor approves a project proposal
+type ProjectApproved struct {
+	ProjectID string
+	ActorID   string // user who approved it
+	Comment   string
+}
+
+// ProjectRejected is published when an advisor rejects a project proposal
+type ProjectRejected struct {
+	ProjectID string
+	ActorID   string
+	Comment   string
+}
+
+// FileUploaded is published for every clean upload, including new versions of a document
+type FileUploaded struct {
+	FileID     string
+	ProjectID  string
+	UploaderID string
+	FileName   string
+	Version    int
+}
+
+// FileReviewed is published when an advisor records a review round of a file
+type FileReviewed struct {
+	FileID     string
+	ProjectID  string
+	ReviewerID string
+	UploaderID string
+	FileName   string
+	Version    int
+	Decision   string // models.ReviewApproved, ReviewRejected or ReviewRevisionRequested
+	Comments   string
+}
+
+// MessageReceived is published for every chat message saved in a project
+type MessageReceived struct {
+	MessageID  string
+	ProjectID  string
+	SenderID   string
+	SenderName string
+	Message    string
+}
+
+// DeadlineApproaching is published once per reminder window before a project's expected end date
+type DeadlineApproaching struct {
+	ProjectID string
+	DueDate   time.Time
+	DaysLeft  int
+}
+
+func (ProjectApproved) Name() string     { return "project.approved" }
+func (ProjectRejected) Name() string     { return "project.rejected" }
+func (FileUploaded) Name() string        { return "file.uploaded" }
+func (FileReviewed) Name() string        { return "file.reviewed" }
+func (MessageReceived) Name() string     { return "chat.message_received" }
+func (DeadlineApproaching) Name() string { return "project.deadline_approaching" }
@@ -0,0 +1,361 @@
+package filescan
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"path"
+	"strings"
+)
+
+// Limits bounds what an uploaded archive may expand to
+type Limits struct {
+	MaxEntries      int   // files inside one archive, nested archives included
+	MaxUncompressed int64 // total bytes after decompression
+	MaxRatio        int64 // uncompressed size / archive size
+	MaxDepth        int   // 1 = no archives inside archives
+	MaxNestedSize   int64 // nested archives larger than this are not unpacked for inspection
+}
+
+// DefaultLimits are used for uploads unless configured otherwise
+var DefaultLimits = Limits{
+	MaxEntries:      10000,
+	MaxUncompressed: 2 << 30, // 2 GB
+	MaxRatio:        100,
+	MaxDepth:        2,
+	MaxNestedSize:   50 << 20, // 50 MB
+}
+
+var archiveExts = map[string]bool{
+	".zip": true, ".rar": true, ".7z": true, ".tar": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".jar": true,
+}
+
+// budget tracks entries and bytes across nested archives
+type budget struct {
+	entries      int
+	uncompressed int64
+}
+
+// inspectZip decompresses every entry (headers can lie about sizes) and recurses into nested zip files
+func inspectZip(r io.ReaderAt, size int64, limits Limits, depth int, requiredPrefix string) error {
+	return inspectZipWithBudget(r, size, limits, depth, requiredPrefix, &budget{})
+}
+
+func inspectZipWithBudget(r io.ReaderAt, size int64, limits Limits, depth int, requiredPrefix string, b *budget) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return reject("The archive is corrupt or not a valid zip file")
+	}
+
+	// The ratio is checked per archive: a nested zip against its own size, not the outer total
+	var expanded int64
+	foundRequired := requiredPrefix == ""
+	for _, f := range zr.File {
+		b.entries++
+		if b.entries > limits.MaxEntries {
+			return reject("The archive contains more than %d files", limits.MaxEntries)
+		}
+		if strings.HasPrefix(f.Name, requiredPrefix) {
+			foundRequired = true
+		}
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if f.Flags&0x1 != 0 {
+			return reject("Password-protected archives are not accepted")
+		}
+
+		nested := archiveExts[strings.ToLower(path.Ext(f.Name))]
+		if nested && depth >= limits.MaxDepth {
+			return reject("Archives may not be nested more than %d levels deep", limits.MaxDepth)
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return reject("The archive entry %s cannot be read", f.Name)
+		}
+
+		// Keep small nested zips in memory so they can be inspected too
+		var buf *bytes.Buffer
+		var w io.Writer = io.Discard
+		if nested && path.Ext(strings.ToLower(f.Name)) == ".zip" && f.UncompressedSize64 <= uint64(limits.MaxNestedSize) {
+			buf = &bytes.Buffer{}
+			w = buf
+		}
+
+		remaining := limits.MaxUncompressed - b.uncompressed
+		n, err := io.Copy(w, io.LimitReader(rc, remaining+1))
+		rc.Close()
+		if err != nil {
+			return reject("The archive entry %s is corrupt", f.Name)
+		}
+		b.uncompressed += n
+		expanded += n
+		if b.uncompressed > limits.MaxUncompressed {
+			return reject("The archive expands to more than %d MB", limits.MaxUncompressed>>20)
+		}
+		if size > 0 && expanded/size > limits.MaxRatio {
+			return reject("The archive compression ratio is suspiciously high")
+		}
+
+		if buf != nil {
+			if err := inspectZipWithBudget(bytes.NewReader(buf.Bytes()), int64(buf.Len()), limits, depth+1, "", b); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !foundRequired {
+		return reject("The file is not a valid Office document")
+	}
+	return nil
+}
+
+var (
+	rar4Signature = []byte("Rar!\x1a\x07\x00")
+	rar5Signature = []byte("Rar!\x1a\x07\x01\x00")
+)
+
+// rarEntry is what the headers tell us about one file; RAR data cannot be decompressed here
+type rarEntry struct {
+	name      string
+	unpacked  int64
+	dir       bool
+	encrypted bool
+}
+
+// inspectRar walks the RAR headers and applies the limits to the declared sizes
+func inspectRar(r io.ReadSeeker, size int64, limits Limits, depth int) error {
+	sig := make([]byte, 8)
+	if _, err := io.ReadFull(r, sig); err != nil {
+		return reject("The archive is corrupt or not a valid rar file")
+	}
+
+	var entries []rarEntry
+	var err error
+	switch {
+	case bytes.Equal(sig, rar5Signature):
+		entries, err = readRar5(r)
+	case bytes.Equal(sig[:7], rar4Signature):
+		if _, err := r.Seek(7, io.SeekStart); err != nil {
+			return err
+		}
+		entries, err = readRar4(r)
+	default:
+		return reject("The archive is corrupt or not a valid rar file")
+	}
+	if err != nil {
+		var rejectErr *RejectError
+		if errors.As(err, &rejectErr) {
+			return err
+		}
+		return reject("The archive is corrupt or not a valid rar file")
+	}
+
+	var total int64
+	for i, e := range entries {
+		if i+1 > limits.MaxEntries {
+			return reject("The archive contains more than %d files", limits.MaxEntries)
+		}
+		if e.dir {
+			continue
+		}
+		if e.encrypted {
+			return reject("Password-protected archives are not accepted")
+		}
+		// Nested archives inside a rar cannot be unpacked for inspection
+		if archiveExts[strings.ToLower(path.Ext(e.name))] && depth >= limits.MaxDepth {
+			return reject("Archives may not be nested more than %d levels deep", limits.MaxDepth)
+		}
+		total += e.unpacked
+		if total > limits.MaxUncompressed {
+			return reject("The archive expands to more than %d MB", limits.MaxUncompressed>>20)
+		}
+	}
+	if size > 0 && total/size > limits.MaxRatio {
+		return reject("The archive compression ratio is suspiciously high")
+	}
+	return nil
+}
+
+// readRar4 parses RAR 1.5-4.x block headers
+func readRar4(r io.ReadSeeker) ([]rarEntry, error) {
+	var entries []rarEntry
+	for {
+		start, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+
+		head := make([]byte, 7)
+		if _, err := io.ReadFull(r, head); err != nil {
+			if err == io.EOF {
+				return entries, nil
+			}
+			return nil, err
+		}
+		blockType := head[2]
+		flags := binary.LittleEndian.Uint16(head[3:5])
+		headSize := int64(binary.LittleEndian.Uint16(head[5:7]))
+		if headSize < 7 {
+			return nil, errors.New("rar: invalid header size")
+		}
+
+		body := make([]byte, headSize-7)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, err
+		}
+
+		var addSize int64
+		if flags&0x8000 != 0 && len(body) >= 4 {
+			addSize = int64(binary.LittleEndian.Uint32(body[0:4]))
+		}
+
+		switch blockType {
+		case 0x73: // archive header
+			if flags&0x0080 != 0 {
+				return nil, reject("Password-protected archives are not accepted")
+			}
+		case 0x74: // file header
+			if len(body) < 25 {
+				return nil, errors.New("rar: short file header")
+			}
+			unpacked := int64(binary.LittleEndian.Uint32(body[4:8]))
+			nameSize := int(binary.LittleEndian.Uint16(body[19:21]))
+			nameStart := 25
+			if flags&0x0100 != 0 {
+				if len(body) < 33 {
+					return nil, errors.New("rar: short file header")
+				}
+				addSize |= int64(binary.LittleEndian.Uint32(body[25:29])) << 32
+				unpacked |= int64(binary.LittleEndian.Uint32(body[29:33])) << 32
+				nameStart = 33
+			}
+			if nameStart+nameSize > len(body) {
+				return nil, errors.New("rar: invalid file name")
+			}
+			entries = append(entries, rarEntry{
+				name:      string(body[nameStart : nameStart+nameSize]),
+				unpacked:  unpacked,
+				dir:       flags&0x00e0 == 0x00e0,
+				encrypted: flags&0x0004 != 0,
+			})
+		case 0x7b: // end of archive
+			return entries, nil
+		}
+
+		if _, err := r.Seek(start+headSize+addSize, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readRar5 parses RAR 5.0 block headers
+func readRar5(r io.ReadSeeker) ([]rarEntry, error) {
+	var entries []rarEntry
+	for {
+		// Skip header CRC32
+		if _, err := r.Seek(4, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+		// Every RAR5 archive ends with an end of archive header, so running out of data means it is truncated
+		headSize, err := readVint(r)
+		if err != nil {
+			return nil, err
+		}
+		if headSize == 0 || headSize > 2<<20 {
+			return nil, errors.New("rar: invalid header size")
+		}
+
+		header := make([]byte, headSize)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, err
+		}
+		hr := bytes.NewReader(header)
+
+		blockType, _ := readVint(hr)
+		flags, _ := readVint(hr)
+		var extraSize, dataSize uint64
+		if flags&0x1 != 0 {
+			extraSize, _ = readVint(hr)
+		}
+		if flags&0x2 != 0 {
+			dataSize, _ = readVint(hr)
+		}
+		if extraSize > uint64(len(header)) {
+			return nil, errors.New("rar: invalid extra area size")
+		}
+
+		switch blockType {
+		case 2: // file header
+			fileFlags, _ := readVint(hr)
+			unpacked, _ := readVint(hr)
+			readVint(hr) // attributes
+			if fileFlags&0x2 != 0 {
+				hr.Seek(4, io.SeekCurrent) // mtime
+			}
+			if fileFlags&0x4 != 0 {
+				hr.Seek(4, io.SeekCurrent) // data CRC32
+			}
+			readVint(hr) // compression info
+			readVint(hr) // host OS
+			nameLen, err := readVint(hr)
+			if err != nil || nameLen > uint64(hr.Len()) {
+				return nil, errors.New("rar: invalid file name")
+			}
+			name := make([]byte, nameLen)
+			io.ReadFull(hr, name)
+
+			entries = append(entries, rarEntry{
+				name:      string(name),
+				unpacked:  int64(unpacked),
+				dir:       fileFlags&0x1 != 0,
+				encrypted: extraSize > 0 && rar5HasEncryptionRecord(header[uint64(len(header))-extraSize:]),
+			})
+		case 4: // archive encryption header
+			return nil, reject("Password-protected archives are not accepted")
+		case 5: // end of archive
+			return entries, nil
+		}
+
+		if _, err := r.Seek(int64(dataSize), io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// rar5HasEncryptionRecord looks for a file encryption record (type 1) in the extra area
+func rar5HasEncryptionRecord(extra []byte) bool {
+	er := bytes.NewReader(extra)
+	for er.Len() > 0 {
+		size, err := readVint(er)
+		if err != nil || size == 0 || size > uint64(er.Len()) {
+			return false
+		}
+		start := er.Len()
+		recordType, _ := readVint(er)
+		if recordType == 1 {
+			return true
+		}
+		er.Seek(int64(size)-int64(start-er.Len()), io.SeekCurrent)
+	}
+	return false
+}
+
+// readVint reads a RAR5 variable length integer (7 bits per byte, high bit = continuation)
+func readVint(r io.Reader) (uint64, error) {
+	var v uint64
+	b := make([]byte, 1)
+	for shift := uint(0); shift < 64; shift += 7 {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, err
+		}
+		v |= uint64(b[0]&0x7f) << shift
+		if b[0]&0x80 == 0 {
+			return v, nil
+		}
+	}
+	return 0, errors.New("rar: vint overflow")
+}
@@ -0,0 +1,169 @@
+package filescan
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"errors"
+	mrand "math/rand"
+	"testing"
+)
+
+func isReject(err error) bool {
+	var rejectErr *RejectError
+	return errors.As(err, &rejectErr)
+}
+
+func inspectRarBytes(data []byte) error {
+	return inspectRar(bytes.NewReader(data), int64(len(data)), DefaultLimits, 1)
+}
+
+// rar5Block frames a RAR5 header: CRC32 (not checked), size vint, header
+func rar5Block(header ...byte) []byte {
+	block := []byte{0, 0, 0, 0, byte(len(header))}
+	return append(block, header...)
+}
+
+func TestInspectRarRejectsHostileHeaders(t *testing.T) {
+	cases := map[string][]byte{
+		"rar5 truncated after signature": append(append([]byte{}, rar5Signature...), 0, 0),
+		"rar5 truncated header":          append(append([]byte{}, rar5Signature...), 0, 0, 0, 0, 20, 2, 1),
+		"rar5 extra area beyond header":  append(append([]byte{}, rar5Signature...), rar5Block(2, 1, 100, 0, 0, 0, 0, 0, 0)...),
+		"rar5 name beyond header":        append(append([]byte{}, rar5Signature...), rar5Block(2, 0, 0, 0, 0, 0, 0, 90, 'a')...),
+		"rar5 oversized header":          append(append([]byte{}, rar5Signature...), 0, 0, 0, 0, 0xff, 0xff, 0xff, 0x7f),
+		"rar4 truncated block":           append(append([]byte{}, rar4Signature...), 0, 0, 0x73),
+		"rar4 header size below minimum": append(append([]byte{}, rar4Signature...), 0, 0, 0x74, 0, 0, 3, 0),
+		"rar4 short file header":         append(append([]byte{}, rar4Signature...), 0, 0, 0x74, 0, 0, 10, 0, 1, 2, 3),
+		"rar4 name beyond header": append(append([]byte{}, rar4Signature...),
+			append([]byte{0, 0, 0x74, 0, 0, 32, 0}, append(make([]byte, 19), 0xff, 0xff, 0, 0, 0, 0)...)...),
+		"not a rar": []byte("Rar!garbage"),
+	}
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Fatalf("panicked: %v", r)
+				}
+			}()
+			if err := inspectRarBytes(data); !isReject(err) {
+				t.Fatalf("got %v, want the archive rejected", err)
+			}
+		})
+	}
+}
+
+func TestInspectRarReadsFileHeaders(t *testing.T) {
+	// One RAR5 file header for "a.txt" (1 KB) followed by the end of archive
+	data := append([]byte{}, rar5Signature...)
+	data = append(data, rar5Block(2, 0, 0, 0x80, 0x08, 0, 0, 0, 5, 'a', '.', 't', 'x', 't')...)
+	data = append(data, rar5Block(5, 0, 0)...)
+
+	entries, err := readRar5(bytes.NewReader(data[len(rar5Signature):]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].name != "a.txt" || entries[0].unpacked != 1024 {
+		t.Fatalf("entries = %+v", entries)
+	}
+	if err := inspectRarBytes(data); err != nil {
+		t.Fatalf("valid archive rejected: %v", err)
+	}
+}
+
+type zipFile struct {
+	name   string
+	data   []byte
+	method uint16
+}
+
+func buildZip(t *testing.T, files ...zipFile) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: f.method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(f.data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func inspectZipBytes(data []byte, limits Limits) error {
+	return inspectZip(bytes.NewReader(data), int64(len(data)), limits, 1, "")
+}
+
+func TestInspectZipRejectsHostileArchives(t *testing.T) {
+	valid := buildZip(t, zipFile{name: "a.txt", data: []byte("hello"), method: zip.Deflate})
+	bomb := buildZip(t, zipFile{name: "zeros", data: make([]byte, 20<<20), method: zip.Deflate})
+
+	// Claims to be stored uncompressed but the central directory offset points past the end
+	lying := append([]byte{}, valid...)
+	lying[len(lying)-6] = 0xff
+
+	cases := map[string][]byte{
+		"truncated":          valid[:len(valid)/2],
+		"bad directory":      lying,
+		"compression bomb":   bomb,
+		"not a zip":          []byte("PK\x03\x04garbage"),
+		"empty end of entry": valid[:len(valid)-22],
+	}
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			if err := inspectZipBytes(data, DefaultLimits); !isReject(err) {
+				t.Fatalf("got %v, want the archive rejected", err)
+			}
+		})
+	}
+}
+
+func TestInspectZipNesting(t *testing.T) {
+	inner := buildZip(t, zipFile{name: "inner.txt", data: []byte("nested"), method: zip.Deflate})
+	outer := buildZip(t, zipFile{name: "inner.zip", data: inner, method: zip.Store})
+	tooDeep := buildZip(t, zipFile{name: "outer.zip", data: outer, method: zip.Store})
+
+	if err := inspectZipBytes(outer, DefaultLimits); err != nil {
+		t.Fatalf("one nested level rejected: %v", err)
+	}
+	if err := inspectZipBytes(tooDeep, DefaultLimits); !isReject(err) {
+		t.Fatalf("got %v, want too deep nesting rejected", err)
+	}
+}
+
+// A nested zip is judged by its own compression ratio, not by everything unpacked before it
+func TestInspectZipNestedRatio(t *testing.T) {
+	incompressible := make([]byte, 2<<20)
+	rand.Read(incompressible)
+
+	// Letters from a small alphabet compress about 4:1
+	text := make([]byte, 20<<10)
+	rng := mrand.New(mrand.NewSource(1))
+	for i := range text {
+		text[i] = "acgt"[rng.Intn(4)]
+	}
+	inner := buildZip(t, zipFile{name: "notes.txt", data: text, method: zip.Deflate})
+	if int64(len(incompressible))/int64(len(inner)) <= DefaultLimits.MaxRatio {
+		t.Fatal("the outer data must exceed the ratio limit relative to the nested zip")
+	}
+
+	outer := buildZip(t,
+		zipFile{name: "data.bin", data: incompressible, method: zip.Store},
+		zipFile{name: "notes.zip", data: inner, method: zip.Store},
+	)
+	if err := inspectZipBytes(outer, DefaultLimits); err != nil {
+		t.Fatalf("legitimate nested zip rejected: %v", err)
+	}
+
+	bomb := buildZip(t, zipFile{name: "zeros", data: make([]byte, 4<<20), method: zip.Deflate})
+	withBomb := buildZip(t,
+		zipFile{name: "data.bin", data: incompressible, method: zip.Store},
+		zipFile{name: "bomb.zip", data: bomb, method: zip.Store},
+	)
+	if err := inspectZipBytes(withBomb, DefaultLimits); !isReject(err) {
+		t.Fatalf("got %v, want the nested bomb rejected", err)
+	}
+}
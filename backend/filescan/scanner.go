@@ -0,0 +1,155 @@
+package filescan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Result of scanning one file
+type Result struct {
+	Infected  bool
+	Signature string // name of the detected malware, empty when clean
+}
+
+// Scanner checks file contents for malware
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (Result, error)
+}
+
+// NewScannerFromEnv returns the scanner selected by SCANNER:
+// "clamav" (address from CLAMAV_ADDRESS), "fake" or "" for no scanning (nil)
+func NewScannerFromEnv() (Scanner, error) {
+	switch os.Getenv("SCANNER") {
+	case "":
+		return nil, nil
+	case "clamav":
+		addr := os.Getenv("CLAMAV_ADDRESS")
+		if addr == "" {
+			addr = "unix:/var/run/clamav/clamd.ctl"
+		}
+		return NewClamAVScanner(addr), nil
+	case "fake":
+		return &FakeScanner{}, nil
+	default:
+		return nil, fmt.Errorf("filescan: unknown scanner %q", os.Getenv("SCANNER"))
+	}
+}
+
+// ClamAVScanner streams files to clamd using the INSTREAM command
+type ClamAVScanner struct {
+	Network string // "unix" or "tcp"
+	Address string
+	Timeout time.Duration
+}
+
+// NewClamAVScanner parses "unix:/path/clamd.ctl" or "tcp:host:3310"
+func NewClamAVScanner(addr string) *ClamAVScanner {
+	network, address := "unix", addr
+	if i := strings.Index(addr, ":"); i > 0 && (addr[:i] == "unix" || addr[:i] == "tcp") {
+		network, address = addr[:i], addr[i+1:]
+	}
+	return &ClamAVScanner{Network: network, Address: address, Timeout: 2 * time.Minute}
+}
+
+const clamChunkSize = 64 << 10
+
+func (s *ClamAVScanner) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, s.Network, s.Address)
+	if err != nil {
+		return Result{}, fmt.Errorf("clamav: connect: %w", err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(s.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	// Null-terminated command followed by length-prefixed chunks and a zero-length terminator
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return Result{}, fmt.Errorf("clamav: write: %w", err)
+	}
+
+	buf := make([]byte, clamChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return Result{}, fmt.Errorf("clamav: write: %w", err)
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return Result{}, fmt.Errorf("clamav: write: %w", err)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return Result{}, readErr
+		}
+	}
+	binary.BigEndian.PutUint32(size, 0)
+	if _, err := conn.Write(size); err != nil {
+		return Result{}, fmt.Errorf("clamav: write: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return Result{}, fmt.Errorf("clamav: read: %w", err)
+	}
+	return parseClamReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseClamReply understands "stream: OK", "stream: <name> FOUND" and "... ERROR"
+func parseClamReply(reply string) (Result, error) {
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+		return Result{}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return Result{Infected: true, Signature: strings.TrimSuffix(reply, " FOUND")}, nil
+	default:
+		return Result{}, fmt.Errorf("clamav: %s", reply)
+	}
+}
+
+// EICAR is the standard anti-virus test string
+const EICAR = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!H+H*`
+
+// FakeScanner flags files containing the EICAR test string or any of Signatures.
+// It stands in for ClamAV in development and tests.
+type FakeScanner struct {
+	Signatures map[string]string // content substring -> signature name
+	Err        error             // returned instead of scanning when set
+}
+
+func (s *FakeScanner) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	if s.Err != nil {
+		return Result{}, s.Err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return Result{}, err
+	}
+	if bytes.Contains(data, []byte(EICAR)) {
+		return Result{Infected: true, Signature: "Eicar-Test-Signature"}, nil
+	}
+	for pattern, name := range s.Signatures {
+		if bytes.Contains(data, []byte(pattern)) {
+			return Result{Infected: true, Signature: name}, nil
+		}
+	}
+	return Result{}, nil
+}
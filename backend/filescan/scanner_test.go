@@ -0,0 +1,208 @@
+package filescan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClamd accepts one INSTREAM per connection, records the chunk sizes and the streamed
+// bytes, and answers with reply(data), or not at all when it is empty
+type fakeClamd struct {
+	addr    string
+	streams chan clamStream
+}
+
+type clamStream struct {
+	command string
+	chunks  []int
+	data    []byte
+}
+
+func newFakeClamd(t *testing.T, reply func(data []byte) string) *fakeClamd {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	d := &fakeClamd{addr: "tcp:" + ln.Addr().String(), streams: make(chan clamStream, 16)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn, reply)
+		}
+	}()
+	return d
+}
+
+func (d *fakeClamd) serve(conn net.Conn, reply func([]byte) string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	command, err := r.ReadString(0)
+	if err != nil {
+		return
+	}
+	stream := clamStream{command: command}
+	size := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, size); err != nil {
+			return
+		}
+		n := binary.BigEndian.Uint32(size)
+		if n == 0 {
+			break
+		}
+		chunk := make([]byte, n)
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return
+		}
+		stream.chunks = append(stream.chunks, int(n))
+		stream.data = append(stream.data, chunk...)
+	}
+	d.streams <- stream
+	text := reply(stream.data)
+	if text == "" {
+		// Hold the connection open without answering until the client gives up
+		io.Copy(io.Discard, conn)
+		return
+	}
+	conn.Write([]byte(text + "\x00"))
+}
+
+func TestClamAVStreamsInChunks(t *testing.T) {
+	clamd := newFakeClamd(t, func([]byte) string { return "stream: OK" })
+
+	data := make([]byte, 2*clamChunkSize+100)
+	rand.Read(data)
+	result, err := NewClamAVScanner(clamd.addr).Scan(context.Background(), bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Infected {
+		t.Fatalf("clean file reported infected: %+v", result)
+	}
+
+	stream := <-clamd.streams
+	if stream.command != "zINSTREAM\x00" {
+		t.Fatalf("command %q", stream.command)
+	}
+	if !bytes.Equal(stream.data, data) {
+		t.Fatal("clamd received different bytes than were scanned")
+	}
+	for _, n := range stream.chunks {
+		if n > clamChunkSize {
+			t.Fatalf("chunk of %d bytes exceeds %d", n, clamChunkSize)
+		}
+	}
+}
+
+func TestClamAVEmptyFile(t *testing.T) {
+	clamd := newFakeClamd(t, func([]byte) string { return "stream: OK" })
+	if _, err := NewClamAVScanner(clamd.addr).Scan(context.Background(), strings.NewReader("")); err != nil {
+		t.Fatal(err)
+	}
+	if stream := <-clamd.streams; len(stream.chunks) != 0 {
+		t.Fatalf("empty file sent %d chunks", len(stream.chunks))
+	}
+}
+
+func TestClamAVReplies(t *testing.T) {
+	clamd := newFakeClamd(t, func(data []byte) string {
+		switch {
+		case bytes.Contains(data, []byte(EICAR)):
+			return "stream: Win.Test.EICAR_HDB-1 FOUND"
+		case bytes.Equal(data, []byte("too big")):
+			return "INSTREAM size limit exceeded. ERROR"
+		default:
+			return "stream: OK"
+		}
+	})
+	scanner := NewClamAVScanner(clamd.addr)
+
+	result, err := scanner.Scan(context.Background(), strings.NewReader("prefix "+EICAR))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Infected || result.Signature != "Win.Test.EICAR_HDB-1" {
+		t.Fatalf("got %+v", result)
+	}
+
+	if _, err := scanner.Scan(context.Background(), strings.NewReader("too big")); err == nil || !strings.Contains(err.Error(), "size limit exceeded") {
+		t.Fatalf("got %v, want the clamd error", err)
+	}
+}
+
+func TestParseClamReply(t *testing.T) {
+	cases := []struct {
+		reply     string
+		infected  bool
+		signature string
+		wantErr   bool
+	}{
+		{"stream: OK", false, "", false},
+		{"OK", false, "", false},
+		{"stream: Eicar-Signature FOUND", true, "Eicar-Signature", false},
+		{"stream: Some Name With Spaces FOUND", true, "Some Name With Spaces", false},
+		{"INSTREAM size limit exceeded. ERROR", false, "", true},
+		{"stream: lstat() failed: No such file ERROR", false, "", true},
+		{"", false, "", true},
+		{"UNKNOWN COMMAND", false, "", true},
+	}
+	for _, tc := range cases {
+		result, err := parseClamReply(tc.reply)
+		if (err != nil) != tc.wantErr || result.Infected != tc.infected || result.Signature != tc.signature {
+			t.Errorf("parseClamReply(%q) = %+v, %v", tc.reply, result, err)
+		}
+	}
+}
+
+func TestClamAVUnavailable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	if _, err := NewClamAVScanner("tcp:"+addr).Scan(context.Background(), strings.NewReader("x")); err == nil {
+		t.Fatal("scan succeeded without clamd")
+	}
+
+	// clamd that never answers: the scan gives up at the deadline
+	silent := newFakeClamd(t, func([]byte) string { return "" })
+	scanner := NewClamAVScanner(silent.addr)
+	scanner.Timeout = 200 * time.Millisecond
+	start := time.Now()
+	if _, err := scanner.Scan(context.Background(), strings.NewReader("x")); err == nil {
+		t.Fatal("scan succeeded without a reply")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("scan did not time out")
+	}
+}
+
+func TestNewClamAVScannerAddress(t *testing.T) {
+	cases := map[string][2]string{
+		"unix:/var/run/clamav/clamd.ctl": {"unix", "/var/run/clamav/clamd.ctl"},
+		"tcp:clamav:3310":                {"tcp", "clamav:3310"},
+		"/tmp/clamd.sock":                {"unix", "/tmp/clamd.sock"},
+	}
+	for addr, want := range cases {
+		s := NewClamAVScanner(addr)
+		if s.Network != want[0] || s.Address != want[1] {
+			t.Errorf("%s: got %s %s", addr, s.Network, s.Address)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package filescan
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// RejectError explains why an upload was refused; its message is safe to show to the user
+type RejectError struct {
+	Reason string
+}
+
+func (e *RejectError) Error() string {
+	return e.Reason
+}
+
+func reject(format string, args ...interface{}) error {
+	return &RejectError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// contentTypes lists the MIME types (or an ancestor of them) the bytes of a file with the extension must match
+var contentTypes = map[string][]string{
+	".pdf":  {"application/pdf"},
+	".doc":  {"application/msword", "application/x-ole-storage"},
+	".docx": {"application/vnd.openxmlformats-officedocument.wordprocessingml.document", "application/zip"},
+	".ppt":  {"application/vnd.ms-powerpoint", "application/x-ole-storage"},
+	".pptx": {"application/vnd.openxmlformats-officedocument.presentationml.presentation", "application/zip"},
+	".zip":  {"application/zip"},
+	".rar":  {"application/x-rar-compressed"},
+	".txt":  {"text/plain"},
+	".jpg":  {"image/jpeg"},
+	".jpeg": {"image/jpeg"},
+	".png":  {"image/png"},
+	".mp4":  {"video/mp4"},
+}
+
+// ooxmlParts is the folder every Office Open XML document of the extension must contain
+var ooxmlParts = map[string]string{
+	".docx": "word/",
+	".pptx": "ppt/",
+}
+
+// Validate sniffs the actual bytes of an upload, checks them against the extension and inspects archives.
+// It returns the detected MIME type, or a *RejectError when the file must be refused.
+func Validate(r io.ReaderAt, size int64, ext string, limits Limits) (string, error) {
+	ext = strings.ToLower(ext)
+	expected, ok := contentTypes[ext]
+	if !ok {
+		return "", reject("File type %s is not supported", ext)
+	}
+
+	mtype, err := mimetype.DetectReader(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return "", err
+	}
+
+	if !matches(mtype, expected) {
+		return "", reject("File content (%s) does not match the %s extension", mtype.String(), ext)
+	}
+
+	switch {
+	case mtype.Is("application/x-rar-compressed"):
+		if err := inspectRar(io.NewSectionReader(r, 0, size), size, limits, 1); err != nil {
+			return "", err
+		}
+	case isZip(mtype):
+		if err := inspectZip(r, size, limits, 1, ooxmlParts[ext]); err != nil {
+			return "", err
+		}
+	}
+
+	return mtype.String(), nil
+}
+
+// matches reports whether mtype or one of its parents is in expected
+func matches(mtype *mimetype.MIME, expected []string) bool {
+	for m := mtype; m != nil; m = m.Parent() {
+		for _, e := range expected {
+			if m.Is(e) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isZip(mtype *mimetype.MIME) bool {
+	return matches(mtype, []string{"application/zip"})
+}
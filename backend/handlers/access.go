@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"backend/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// isUUID accepts only the canonical form; uuid.Parse also takes urn:uuid: IDs, which Postgres rejects
+func isUUID(v string) bool {
+	if len(v) != 36 {
+		return false
+	}
+	_, err := uuid.Parse(v)
+	return err == nil
+}
+
+// findAccessibleProject loads a project the current user is allowed to see.
+// Students may access their own projects, advisors the projects they advise and admins every project.
+// It returns gorm.ErrRecordNotFound when the project does not exist or access is denied.
+func findAccessibleProject(db *gorm.DB, c *fiber.Ctx, projectID string) (*models.Project, error) {
+	// A malformed ID would make Postgres fail the query instead of finding nothing
+	if !isUUID(projectID) {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	userID := c.Locals("user_id")
+	userRole := c.Locals("user_role")
+
+	query := db.Where("id = ?", projectID)
+
+	switch userRole {
+	case "student":
+		var student models.Student
+		if err := db.Where("user_id = ?", userID).First(&student).Error; err != nil {
+			return nil, err
+		}
+		query = query.Where("student_id = ?", student.ID)
+	case "advisor":
+		var advisor models.Advisor
+		if err := db.Where("user_id = ?", userID).First(&advisor).Error; err != nil {
+			return nil, err
+		}
+		query = query.Where("advisor_id = ?", advisor.ID)
+	case "admin":
+		// Admins can access every project
+	default:
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	var project models.Project
+	if err := query.First(&project).Error; err != nil {
+		return nil, err
+	}
+	return &project, nil
+}
+
+// findAccessibleFile loads a project file whose project the current user is allowed to see
+func findAccessibleFile(db *gorm.DB, c *fiber.Ctx, fileID string) (*models.ProjectFile, error) {
+	if !isUUID(fileID) {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	var projectFile models.ProjectFile
+	if err := db.First(&projectFile, "id = ?", fileID).Error; err != nil {
+		return nil, err
+	}
+
+	if _, err := findAccessibleProject(db, c, projectFile.ProjectID); err != nil {
+		return nil, err
+	}
+	return &projectFile, nil
+}
@@ -2,6 +2,9 @@ package handlers
 
 import (
 	"backend/models"
+	"backend/storage"
+	"log"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"golang.org/x/crypto/bcrypt"
@@ -9,11 +12,12 @@ import (
 )
 
 type AdminHandler struct {
-	DB *gorm.DB
+	DB      *gorm.DB
+	Storage storage.Storage
 }
 
-func NewAdminHandler(db *gorm.DB) *AdminHandler {
-	return &AdminHandler{DB: db}
+func NewAdminHandler(db *gorm.DB, store storage.Storage) *AdminHandler {
+	return &AdminHandler{DB: db, Storage: store}
 }
 
 // GetUser - Get single user by ID (admin only)
@@ -462,21 +466,69 @@ func (h *AdminHandler) DeleteProject(c *fiber.Ctx) error {
 		})
 	}
 
-	// Delete related records first (files, notifications, etc.)
-	// Delete project files
-	h.DB.Where("project_id = ?", projectID).Delete(&models.ProjectFile{})
-	
-	// Delete notifications related to this project
-	h.DB.Where("project_id = ?", projectID).Delete(&models.Notification{})
+	// Remember what the project stores; the rows are gone once the project is deleted
+	var files []models.ProjectFile
+	if err := h.DB.Where("project_id = ?", projectID).Find(&files).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch project files",
+		})
+	}
+	var sessions []models.UploadSession
+	if err := h.DB.Where("project_id = ? AND status IN ?", projectID, []string{"active", "assembling"}).Find(&sessions).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch upload sessions",
+		})
+	}
 
-	// Delete the project
-	if err := h.DB.Delete(&project).Error; err != nil {
+	// Files, reviews and upload sessions cascade; notifications only reference the project
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("related_project_id = ?", projectID).Delete(&models.Notification{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&project).Error
+	})
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to delete project",
 		})
 	}
 
+	// Remove the bytes after the rows; anything left behind is found by the storage reconciliation
+	var keys []string
+	for i := range files {
+		keys = append(keys, files[i].StoredKeys()...)
+	}
+	for i := range sessions {
+		for chunk := 0; chunk < sessions[i].TotalChunks; chunk++ {
+			keys = append(keys, sessions[i].ChunkKey(chunk))
+		}
+	}
+	// Content shared with files of other projects stays
+	var shared []string
+	var sharedErr error
+	if len(keys) > 0 {
+		sharedErr = h.DB.Model(&models.FileBlob{}).Where("storage_key IN ?", keys).Pluck("storage_key", &shared).Error
+	}
+	keep := make(map[string]bool, len(shared))
+	for _, key := range shared {
+		keep[key] = true
+	}
+
+	failed := 0
+	for _, key := range keys {
+		// Without the blob list no blob is safe to delete; storage-gc removes them later
+		if keep[key] || (sharedErr != nil && strings.HasPrefix(key, models.BlobPrefix)) {
+			continue
+		}
+		if err := h.Storage.Delete(c.Context(), key); err != nil {
+			log.Printf("Failed to delete %s of deleted project %s: %v", key, projectID, err)
+			failed++
+		}
+	}
+
 	return c.JSON(fiber.Map{
-		"message": "Project deleted successfully",
+		"message":          "Project deleted successfully",
+		"deleted_files":    len(files),
+		"storage_failures": failed,
 	})
 }
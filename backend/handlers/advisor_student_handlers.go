@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"backend/events"
 	"backend/models"
 	"fmt"
 	"time"
@@ -10,11 +11,12 @@ import (
 )
 
 type AdvisorStudentHandler struct {
-	DB *gorm.DB
+	DB     *gorm.DB
+	Events *events.Bus
 }
 
-func NewAdvisorStudentHandler(db *gorm.DB) *AdvisorStudentHandler {
-	return &AdvisorStudentHandler{DB: db}
+func NewAdvisorStudentHandler(db *gorm.DB, bus *events.Bus) *AdvisorStudentHandler {
+	return &AdvisorStudentHandler{DB: db, Events: bus}
 }
 
 // GetAdvisorStudents - Get all students under advisor supervision
@@ -243,6 +245,7 @@ func (h *AdvisorStudentHandler) UpdateProjectStatus(c *fiber.Ctx) error {
 	}
 
 	// Update project status
+	previousStatus := project.Status
 	if err := h.DB.Model(&project).Updates(map[string]interface{}{
 		"status":     statusData.Status,
 		"updated_at": time.Now(),
@@ -252,6 +255,16 @@ func (h *AdvisorStudentHandler) UpdateProjectStatus(c *fiber.Ctx) error {
 		})
 	}
 
+	if previousStatus != statusData.Status {
+		actorID, _ := c.Locals("user_id").(string)
+		switch statusData.Status {
+		case "approved":
+			h.Events.Publish(events.ProjectApproved{ProjectID: project.ID, ActorID: actorID})
+		case "rejected":
+			h.Events.Publish(events.ProjectRejected{ProjectID: project.ID, ActorID: actorID})
+		}
+	}
+
 	return c.JSON(fiber.Map{
 		"message": "Project status updated successfully",
 		"status":  statusData.Status,
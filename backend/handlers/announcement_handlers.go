@@ -0,0 +1,406 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/notify"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+type AnnouncementHandler struct {
+	DB     *gorm.DB
+	Worker *notify.AnnouncementWorker
+}
+
+func NewAnnouncementHandler(db *gorm.DB, worker *notify.AnnouncementWorker) *AnnouncementHandler {
+	return &AnnouncementHandler{
+		DB:     db,
+		Worker: worker,
+	}
+}
+
+// announcementInput is the body of create and update; fields left out keep their value on update
+type announcementInput struct {
+	Title              *string    `json:"title"`
+	Message            *string    `json:"message"`
+	Type               *string    `json:"type"`
+	Priority           *string    `json:"priority"`
+	TargetType         *string    `json:"target_type"`
+	TargetRole         *string    `json:"target_role"`
+	TargetAcademicYear *int       `json:"target_academic_year"`
+	TargetSemester     *int       `json:"target_semester"`
+	TargetAdvisorID    *string    `json:"target_advisor_id"`
+	TargetProjectIDs   *[]string  `json:"target_project_ids"`
+	Pinned             *bool      `json:"pinned"`
+	ExpiresAt          *time.Time `json:"expires_at"`
+	ScheduledAt        *time.Time `json:"scheduled_at"` // empty sends right away
+}
+
+// content reports whether the input changes what is sent or to whom
+func (in *announcementInput) content() bool {
+	return in.Title != nil || in.Message != nil || in.Type != nil || in.Priority != nil ||
+		in.TargetType != nil || in.TargetRole != nil || in.TargetAcademicYear != nil ||
+		in.TargetSemester != nil || in.TargetAdvisorID != nil || in.TargetProjectIDs != nil ||
+		in.ScheduledAt != nil
+}
+
+// apply copies the input onto a and validates the result, returning a message for the client
+func (in *announcementInput) apply(a *models.Announcement) string {
+	if in.Title != nil {
+		a.Title = strings.TrimSpace(*in.Title)
+	}
+	if in.Message != nil {
+		a.Message = strings.TrimSpace(*in.Message)
+	}
+	if in.Type != nil {
+		a.Type = *in.Type
+	}
+	if in.Priority != nil {
+		a.Priority = *in.Priority
+	}
+	if in.TargetType != nil {
+		// A new target replaces the old one entirely
+		a.TargetType = *in.TargetType
+		a.TargetRole, a.TargetAcademicYear, a.TargetSemester, a.TargetAdvisorID, a.TargetProjectIDs = nil, nil, nil, nil, nil
+	}
+	if in.TargetRole != nil {
+		a.TargetRole = in.TargetRole
+		if *in.TargetRole == "" {
+			a.TargetRole = nil
+		}
+	}
+	if in.TargetAcademicYear != nil {
+		a.TargetAcademicYear = in.TargetAcademicYear
+	}
+	if in.TargetSemester != nil {
+		a.TargetSemester = in.TargetSemester
+		if *in.TargetSemester == 0 {
+			a.TargetSemester = nil
+		}
+	}
+	if in.TargetAdvisorID != nil {
+		a.TargetAdvisorID = in.TargetAdvisorID
+	}
+	if in.TargetProjectIDs != nil {
+		a.TargetProjectIDs = pq.StringArray(*in.TargetProjectIDs)
+	}
+	if in.Pinned != nil {
+		a.Pinned = *in.Pinned
+	}
+	if in.ExpiresAt != nil {
+		a.ExpiresAt = in.ExpiresAt
+		if in.ExpiresAt.IsZero() {
+			a.ExpiresAt = nil
+		}
+	}
+	if in.ScheduledAt != nil {
+		a.ScheduledAt = *in.ScheduledAt
+	}
+
+	switch {
+	case a.Title == "" || a.Message == "":
+		return "title and message are required"
+	case a.Type != "info" && a.Type != "warning" && a.Type != "success" && a.Type != "error":
+		return "type must be info, warning, success or error"
+	case a.Priority != "low" && a.Priority != "medium" && a.Priority != "high":
+		return "priority must be low, medium or high"
+	case a.TargetRole != nil && *a.TargetRole != "student" && *a.TargetRole != "advisor" && *a.TargetRole != "admin":
+		return "target_role must be student, advisor or admin"
+	case a.TargetSemester != nil && (*a.TargetSemester < 1 || *a.TargetSemester > 3):
+		return "target_semester must be 1, 2 or 3"
+	case a.TargetAdvisorID != nil && !isUUID(*a.TargetAdvisorID):
+		return "Invalid target_advisor_id"
+	case a.ExpiresAt != nil && !a.ExpiresAt.After(a.ScheduledAt):
+		return "expires_at must be after scheduled_at"
+	}
+	for _, id := range a.TargetProjectIDs {
+		if !isUUID(id) {
+			return "Invalid project id: " + id
+		}
+	}
+	return ""
+}
+
+// roleStats are the read receipts of the recipients with one role
+type roleStats struct {
+	Role       string `json:"role"`
+	Recipients int64  `json:"recipients"`
+	Read       int64  `json:"read"`
+}
+
+// announcementStats are the read receipts of an announcement's notifications
+type announcementStats struct {
+	Recipients int64       `json:"recipients"`
+	InApp      int64       `json:"in_app"` // recipients who see it in the app; the others only get email or push
+	Read       int64       `json:"read"`
+	Unread     int64       `json:"unread"`
+	ReadRate   float64     `json:"read_rate"` // read / in_app
+	ByRole     []roleStats `json:"by_role"`
+}
+
+// stats returns the read receipts of the given announcements, keyed by announcement ID
+func (h *AnnouncementHandler) stats(ids []string) (map[string]*announcementStats, error) {
+	var rows []struct {
+		AnnouncementID string
+		Role           string
+		Recipients     int64
+		InApp          int64
+		ReadCount      int64
+	}
+	if err := h.DB.Table("notifications").
+		Select("notifications.announcement_id, users.role, COUNT(*) AS recipients, "+
+			"COUNT(*) FILTER (WHERE NOT notifications.hidden) AS in_app, "+
+			"COUNT(*) FILTER (WHERE notifications.is_read) AS read_count").
+		Joins("JOIN users ON users.id = notifications.user_id").
+		Where("notifications.announcement_id IN ?", ids).
+		Group("notifications.announcement_id, users.role").
+		Order("users.role").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*announcementStats, len(ids))
+	for _, id := range ids {
+		result[id] = &announcementStats{ByRole: []roleStats{}}
+	}
+	for _, row := range rows {
+		s := result[row.AnnouncementID]
+		s.Recipients += row.Recipients
+		s.InApp += row.InApp
+		s.Read += row.ReadCount
+		s.ByRole = append(s.ByRole, roleStats{Role: row.Role, Recipients: row.Recipients, Read: row.ReadCount})
+	}
+	for _, s := range result {
+		s.Unread = s.InApp - s.Read
+		if s.InApp > 0 {
+			s.ReadRate = float64(s.Read) / float64(s.InApp)
+		}
+	}
+	return result, nil
+}
+
+// CreateAnnouncement - POST /api/admin/announcements
+// Sends to the target right away, or at scheduled_at; the response estimates the number of recipients
+func (h *AnnouncementHandler) CreateAnnouncement(c *fiber.Ctx) error {
+	var input announcementInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if input.TargetType == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "target_type is required (all, role, term, advisor or projects)"})
+	}
+
+	userID, _ := c.Locals("user_id").(string)
+	announcement := models.Announcement{
+		Type:        "info",
+		Priority:    "medium",
+		Status:      models.AnnouncementScheduled,
+		ScheduledAt: time.Now(),
+		CreatedBy:   userID,
+	}
+	if msg := input.apply(&announcement); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	recipients, err := notify.Recipients(h.DB, &announcement)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	var estimate int64
+	if err := recipients.Count(&estimate).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to resolve recipients"})
+	}
+
+	if err := h.DB.Create(&announcement).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create announcement"})
+	}
+	if !announcement.ScheduledAt.After(time.Now()) {
+		h.Worker.Wake()
+	}
+
+	return c.Status(201).JSON(fiber.Map{
+		"announcement":         announcement,
+		"estimated_recipients": estimate,
+	})
+}
+
+// GetAnnouncements - GET /api/admin/announcements?status=sent&limit=50
+func (h *AnnouncementHandler) GetAnnouncements(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 50)
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	query := h.DB.Preload("Creator").Order("scheduled_at DESC").Limit(limit)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	announcements := []models.Announcement{}
+	if err := query.Find(&announcements).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch announcements"})
+	}
+
+	ids := make([]string, len(announcements))
+	for i, a := range announcements {
+		ids[i] = a.ID
+	}
+	stats := map[string]*announcementStats{}
+	if len(ids) > 0 {
+		var err error
+		if stats, err = h.stats(ids); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch announcement statistics"})
+		}
+	}
+
+	type item struct {
+		models.Announcement
+		Stats *announcementStats `json:"stats"`
+	}
+	items := make([]item, len(announcements))
+	for i, a := range announcements {
+		items[i] = item{Announcement: a, Stats: stats[a.ID]}
+	}
+	return c.JSON(items)
+}
+
+// findAnnouncement loads the announcement of the :id parameter
+func (h *AnnouncementHandler) findAnnouncement(c *fiber.Ctx) (*models.Announcement, error) {
+	id := c.Params("id")
+	if !isUUID(id) {
+		return nil, gorm.ErrRecordNotFound
+	}
+	var announcement models.Announcement
+	if err := h.DB.Preload("Creator").First(&announcement, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &announcement, nil
+}
+
+// GetAnnouncement - GET /api/admin/announcements/:id
+// Includes the read receipts: recipients, read and unread in total and per role
+func (h *AnnouncementHandler) GetAnnouncement(c *fiber.Ctx) error {
+	announcement, err := h.findAnnouncement(c)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Announcement not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch announcement"})
+	}
+
+	stats, err := h.stats([]string{announcement.ID})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch announcement statistics"})
+	}
+	return c.JSON(fiber.Map{
+		"announcement": announcement,
+		"stats":        stats[announcement.ID],
+	})
+}
+
+// UpdateAnnouncement - PUT /api/admin/announcements/:id
+// Content, target and schedule can change until sending starts; pinned and expires_at at any time
+func (h *AnnouncementHandler) UpdateAnnouncement(c *fiber.Ctx) error {
+	announcement, err := h.findAnnouncement(c)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Announcement not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch announcement"})
+	}
+
+	var input announcementInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if input.content() && announcement.Status != models.AnnouncementScheduled {
+		return c.Status(409).JSON(fiber.Map{"error": "Only pinned and expires_at can change once an announcement is " + announcement.Status})
+	}
+	if msg := input.apply(announcement); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	if _, err := notify.Recipients(h.DB, announcement); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	// Only a scheduled announcement is updated in full, so a concurrent claim is not overwritten
+	query := h.DB.Model(announcement).Select("pinned", "expires_at")
+	if input.content() {
+		query = h.DB.Model(announcement).Where("status = ?", models.AnnouncementScheduled).
+			Select("title", "message", "type", "priority", "target_type", "target_role", "target_academic_year",
+				"target_semester", "target_advisor_id", "target_project_ids", "pinned", "expires_at", "scheduled_at")
+	}
+	result := query.Updates(announcement)
+	if result.Error != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update announcement"})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "The announcement is already being sent"})
+	}
+	if input.ScheduledAt != nil && !announcement.ScheduledAt.After(time.Now()) {
+		h.Worker.Wake()
+	}
+
+	return c.JSON(announcement)
+}
+
+// CancelAnnouncement - POST /api/admin/announcements/:id/cancel
+// Only announcements that have not started sending can be cancelled
+func (h *AnnouncementHandler) CancelAnnouncement(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if !isUUID(id) {
+		return c.Status(404).JSON(fiber.Map{"error": "Announcement not found"})
+	}
+
+	result := h.DB.Model(&models.Announcement{}).
+		Where("id = ? AND status = ?", id, models.AnnouncementScheduled).
+		Update("status", models.AnnouncementCancelled)
+	if result.Error != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to cancel announcement"})
+	}
+	if result.RowsAffected == 0 {
+		var count int64
+		h.DB.Model(&models.Announcement{}).Where("id = ?", id).Count(&count)
+		if count == 0 {
+			return c.Status(404).JSON(fiber.Map{"error": "Announcement not found"})
+		}
+		return c.Status(409).JSON(fiber.Map{"error": "Only scheduled announcements can be cancelled"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Announcement cancelled"})
+}
+
+// GetPinnedAnnouncements - GET /api/announcements/pinned
+// Pinned announcements the current user received that have not expired or been archived, newest first
+func (h *AnnouncementHandler) GetPinnedAnnouncements(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	type pinned struct {
+		ID             string     `json:"id"`
+		Title          string     `json:"title"`
+		Message        string     `json:"message"`
+		Type           string     `json:"type"`
+		Priority       string     `json:"priority"`
+		SentAt         time.Time  `json:"sent_at"`
+		ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+		NotificationID string     `json:"notification_id"` // mark read or archive it through /api/notifications
+		IsRead         bool       `json:"is_read"`
+	}
+	announcements := []pinned{}
+	if err := h.DB.Table("announcements").
+		Select("announcements.id, announcements.title, announcements.message, announcements.type, announcements.priority, "+
+			"announcements.sent_at, announcements.expires_at, notifications.id AS notification_id, notifications.is_read").
+		Joins("JOIN notifications ON notifications.announcement_id = announcements.id").
+		Where("notifications.user_id = ? AND notifications.hidden = ? AND notifications.archived_at IS NULL", userID, false).
+		Where("announcements.pinned AND announcements.status = ?", models.AnnouncementSent).
+		Where("announcements.expires_at IS NULL OR announcements.expires_at > ?", time.Now()).
+		Order("announcements.sent_at DESC").
+		Scan(&announcements).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch announcements"})
+	}
+
+	return c.JSON(announcements)
+}
@@ -1,156 +1,148 @@
 package handlers
 
 import (
+	"backend/events"
 	"backend/models"
+	"backend/realtime"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
-	"sync"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // ChatHandler handles chat-related operations
 type ChatHandler struct {
-	DB  *gorm.DB
-	Hub *ChatHub
+	DB     *gorm.DB
+	Hub    *realtime.Hub
+	Events *events.Bus
 }
 
-// NewChatHandler creates a new chat handler
-func NewChatHandler(db *gorm.DB) *ChatHandler {
-	hub := NewChatHub()
-	go hub.Run()
+// NewChatHandler creates a new chat handler; messages are broadcast through the project rooms of hub
+// and published on bus for the other members' notifications
+func NewChatHandler(db *gorm.DB, hub *realtime.Hub, bus *events.Bus) *ChatHandler {
 	return &ChatHandler{
-		DB:  db,
-		Hub: hub,
+		DB:     db,
+		Hub:    hub,
+		Events: bus,
 	}
 }
 
-// ChatHub manages WebSocket connections
-type ChatHub struct {
-	// Registered clients by project ID
-	clients map[string]map[*websocket.Conn]bool
-
-	// Broadcast channel
-	broadcast chan BroadcastMessage
-
-	// Register channel
-	register chan ClientRegistration
+// Limits per chat connection
+const (
+	maxChatFrameSize     = 16 << 10 // bytes of one frame; larger frames close the connection
+	maxChatMessageLength = 4000     // characters of one message
+	chatRateBurst        = 10       // frames a client may send at once
+	chatRatePerSecond    = 2        // frames a client may send on average
+)
 
-	// Unregister channel
-	unregister chan ClientRegistration
+// AuthorizeWebSocket checks before the upgrade that the project ID is valid and the user may
+// access the project, with the same rules as GetChatHistory
+func (h *ChatHandler) AuthorizeWebSocket(c *fiber.Ctx) error {
+	projectID := c.Params("project_id")
+	if !isUUID(projectID) {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid project ID"})
+	}
+	if userID, _ := c.Locals("user_id").(string); !isUUID(userID) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
 
-	// Mutex for thread-safe operations
-	mu sync.RWMutex
+	if _, err := findAccessibleProject(h.DB, c, projectID); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Project not found or access denied"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch project"})
+	}
+	return c.Next()
 }
 
-// ClientRegistration represents a client registration
-type ClientRegistration struct {
-	ProjectID string
-	Conn      *websocket.Conn
+// rateLimiter is a token bucket of one connection; only its read loop uses it
+type rateLimiter struct {
+	tokens float64
+	last   time.Time
 }
 
-// BroadcastMessage represents a message to broadcast
-type BroadcastMessage struct {
-	ProjectID string
-	Message   interface{} // Can be WebSocketMessage or WebSocketBroadcast
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{tokens: chatRateBurst, last: time.Now()}
 }
 
-// NewChatHub creates a new chat hub
-func NewChatHub() *ChatHub {
-	return &ChatHub{
-		clients:    make(map[string]map[*websocket.Conn]bool),
-		broadcast:  make(chan BroadcastMessage),
-		register:   make(chan ClientRegistration),
-		unregister: make(chan ClientRegistration),
+// allow takes a token if one is left
+func (l *rateLimiter) allow() bool {
+	now := time.Now()
+	l.tokens = math.Min(chatRateBurst, l.tokens+now.Sub(l.last).Seconds()*chatRatePerSecond)
+	l.last = now
+	if l.tokens < 1 {
+		return false
 	}
+	l.tokens--
+	return true
 }
 
-// Run starts the hub
-func (h *ChatHub) Run() {
-	for {
-		select {
-		case registration := <-h.register:
-			h.mu.Lock()
-			if _, ok := h.clients[registration.ProjectID]; !ok {
-				h.clients[registration.ProjectID] = make(map[*websocket.Conn]bool)
-			}
-			h.clients[registration.ProjectID][registration.Conn] = true
-			h.mu.Unlock()
-			log.Printf("Client registered for project %s. Total clients: %d",
-				registration.ProjectID, len(h.clients[registration.ProjectID]))
-
-		case registration := <-h.unregister:
-			h.mu.Lock()
-			if clients, ok := h.clients[registration.ProjectID]; ok {
-				if _, ok := clients[registration.Conn]; ok {
-					delete(clients, registration.Conn)
-					registration.Conn.Close()
-					if len(clients) == 0 {
-						delete(h.clients, registration.ProjectID)
-					}
-				}
-			}
-			h.mu.Unlock()
-			log.Printf("Client unregistered from project %s", registration.ProjectID)
-
-		case message := <-h.broadcast:
-			h.mu.RLock()
-			if clients, ok := h.clients[message.ProjectID]; ok {
-				for client := range clients {
-					err := client.WriteJSON(message.Message)
-					if err != nil {
-						log.Printf("Error broadcasting to client: %v", err)
-						h.unregister <- ClientRegistration{
-							ProjectID: message.ProjectID,
-							Conn:      client,
-						}
-					}
-				}
-			}
-			h.mu.RUnlock()
-		}
-	}
-}
-
-// HandleWebSocket handles WebSocket connections
+// HandleWebSocket - GET /ws/chat/:project_id?token=<jwt>
+// AuthorizeWebSocket has checked the project and the user before the upgrade
 func (h *ChatHandler) HandleWebSocket(c *websocket.Conn) {
 	projectID := c.Params("project_id")
-	userID := c.Locals("user_id")
-	userName := c.Locals("full_name")
+	userID, _ := c.Locals("user_id").(string)
+	userName, _ := c.Locals("full_name").(string)
+	userRole, _ := c.Locals("user_role").(string)
+	projectUUID, err := uuid.Parse(projectID)
+	if err != nil {
+		c.Close()
+		return
+	}
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		c.Close()
+		return
+	}
 
 	log.Printf("WebSocket connection established for project %s by user %v", projectID, userID)
 
-	// Register client
-	h.Hub.register <- ClientRegistration{
-		ProjectID: projectID,
-		Conn:      c,
-	}
-
 	// Send connection confirmation
 	connectedMsg := models.WebSocketMessage{
 		Type:      "connected",
 		ProjectID: projectID,
-		UserID:    userID.(string),
-		UserName:  userName.(string),
+		UserID:    userID,
+		UserName:  userName,
 		Timestamp: time.Now(),
 	}
 	c.WriteJSON(connectedMsg)
 
-	defer func() {
-		h.Hub.unregister <- ClientRegistration{
+	// Register after the confirmation so only the hub writes to the connection from now on
+	room := realtime.ProjectRoom(projectID)
+	client := h.Hub.Register(room, c)
+	defer h.Hub.Unregister(client)
+
+	c.SetReadLimit(maxChatFrameSize)
+	limiter := newRateLimiter()
+	reject := func(reason string) {
+		h.Hub.Send(client, models.WebSocketMessage{
+			Type:      "error",
 			ProjectID: projectID,
-			Conn:      c,
-		}
-	}()
+			Error:     reason,
+			Timestamp: time.Now(),
+		})
+	}
 
 	// Listen for messages
 	for {
 		var wsMsg models.WebSocketMessage
 		err := c.ReadJSON(&wsMsg)
-		if err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		invalid := errors.As(err, &syntaxErr) || errors.As(err, &typeErr)
+		if err != nil && !invalid {
 			log.Printf("ReadJSON error: %v (project=%s, user=%s)", err, projectID, userID)
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket unexpected close error: %v", err)
@@ -158,26 +150,45 @@ func (h *ChatHandler) HandleWebSocket(c *websocket.Conn) {
 			break
 		}
 
+		// Malformed frames are charged too, so they cannot be used to flood the connection with replies
+		if !limiter.allow() {
+			reject("Too many messages, slow down")
+			continue
+		}
+		if invalid {
+			reject("Invalid message")
+			continue
+		}
+
 		log.Printf("Received WebSocket message: type=%s, project=%s", wsMsg.Type, projectID)
 
 		// Handle different message types
 		switch wsMsg.Type {
 		case "message":
-			log.Printf("Processing message: %s", wsMsg.Message.Message)
+			text := strings.TrimSpace(wsMsg.Message.Message)
+			if text == "" {
+				reject("Message is empty")
+				continue
+			}
+			if utf8.RuneCountInString(text) > maxChatMessageLength {
+				reject(fmt.Sprintf("Message is longer than %d characters", maxChatMessageLength))
+				continue
+			}
 
 			// Save message to database
 			chatMsg := models.ChatMessage{
 				ID:         uuid.New(),
-				ProjectID:  uuid.MustParse(projectID),
-				SenderID:   uuid.MustParse(userID.(string)),
-				SenderRole: c.Locals("user_role").(string),
-				Message:    wsMsg.Message.Message,
+				ProjectID:  projectUUID,
+				SenderID:   userUUID,
+				SenderRole: userRole,
+				Message:    text,
 				IsRead:     false,
 				CreatedAt:  time.Now(),
 			}
 
 			if err := h.DB.Create(&chatMsg).Error; err != nil {
 				log.Printf("Error saving message: %v", err)
+				reject("Failed to save message")
 				continue
 			}
 
@@ -191,140 +202,501 @@ func (h *ChatHandler) HandleWebSocket(c *websocket.Conn) {
 				Type:      "message",
 				ProjectID: projectID,
 				Message:   &chatMsg,
-				UserID:    userID.(string),
-				UserName:  userName.(string),
+				UserID:    userID,
+				UserName:  userName,
 				Timestamp: time.Now(),
 			}
 
-			h.Hub.broadcast <- BroadcastMessage{
-				ProjectID: projectID,
-				Message:   broadcastMsg,
-			}
+			h.Hub.Publish(room, broadcastMsg)
+			h.Events.Publish(events.MessageReceived{
+				MessageID:  chatMsg.ID.String(),
+				ProjectID:  projectID,
+				SenderID:   userID,
+				SenderName: userName,
+				Message:    chatMsg.Message,
+			})
 
 		case "typing":
-			// Broadcast typing indicator
-			h.Hub.broadcast <- BroadcastMessage{
+			// Broadcast typing indicator as the authenticated user
+			h.Hub.Publish(room, models.WebSocketMessage{
+				Type:      "typing",
 				ProjectID: projectID,
-				Message:   wsMsg,
-			}
+				UserID:    userID,
+				UserName:  userName,
+				Timestamp: time.Now(),
+			})
 
 		case "ping":
 			// Ping message for keepalive - no action needed
 			log.Printf("Ping received from project %s", projectID)
 
 		case "read":
-			// Mark messages as read
-			projectUUID, _ := uuid.Parse(projectID)
-			userUUID, _ := uuid.Parse(userID.(string))
-
-			h.DB.Model(&models.ChatMessage{}).
-				Where("project_id = ? AND sender_id != ?", projectUUID, userUUID).
-				Update("is_read", true)
+			// Mark messages as read up to message.id, or all of them
+			if _, _, err := h.markRead(projectUUID, userID, userName, wsMsg.Message.ID); err != nil {
+				if err == gorm.ErrRecordNotFound {
+					reject("Message not found")
+				} else {
+					log.Printf("Error marking messages as read: %v", err)
+					reject("Failed to mark messages as read")
+				}
+			}
 		default:
-			log.Printf("⚠️ Unknown message type received: type=%s, project=%s, user=%s, payload=%+v", wsMsg.Type, projectID, userID.(string), wsMsg)
+			log.Printf("⚠️ Unknown message type received: type=%s, project=%s, user=%s, payload=%+v", wsMsg.Type, projectID, userID, wsMsg)
 		}
 	}
 }
 
-// GetChatHistory - GET /api/chats/:project_id/messages
+// GetChatHistory - GET /api/chats/:project_id/messages?before=<message id>&after=<message id>&limit=50
+// Returns the latest messages, those before or those after a message, in chronological order;
+// X-Has-More is "true" when more messages lie beyond the page in the direction requested
 func (h *ChatHandler) GetChatHistory(c *fiber.Ctx) error {
 	projectID := c.Params("project_id")
+	if !isUUID(projectID) {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid project ID"})
+	}
 
 	// Verify user has access to this project
-	userID := c.Locals("user_id")
-	userRole := c.Locals("user_role")
+	if _, err := findAccessibleProject(h.DB, c, projectID); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Project not found or access denied"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch project"})
+	}
 
-	var project models.Project
-	query := h.DB.Where("id = ?", projectID)
+	limit := c.QueryInt("limit", 50)
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+	before, after := c.Query("before"), c.Query("after")
+	if before != "" && after != "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Use either before or after"})
+	}
 
-	// Authorization check
-	if userRole == "student" {
-		var student models.Student
-		if err := h.DB.Where("user_id = ?", userID).First(&student).Error; err != nil {
-			return c.Status(404).JSON(fiber.Map{"error": "Student not found"})
+	// Get messages
+	query := h.DB.Where("project_id = ?", projectID).Preload("Sender").Limit(limit + 1)
+	if after != "" {
+		anchor, err := h.findMessage(projectID, after)
+		if err != nil {
+			return messageLookupError(c, err)
 		}
-		query = query.Where("student_id = ?", student.ID)
-	} else if userRole == "advisor" {
-		var advisor models.Advisor
-		if err := h.DB.Where("user_id = ?", userID).First(&advisor).Error; err != nil {
-			return c.Status(404).JSON(fiber.Map{"error": "Advisor not found"})
+		query = query.Where("(created_at, id) > (?, ?)", anchor.CreatedAt, anchor.ID).Order("created_at ASC, id ASC")
+	} else {
+		if before != "" {
+			anchor, err := h.findMessage(projectID, before)
+			if err != nil {
+				return messageLookupError(c, err)
+			}
+			query = query.Where("(created_at, id) < (?, ?)", anchor.CreatedAt, anchor.ID)
 		}
-		query = query.Where("advisor_id = ?", advisor.ID)
+		query = query.Order("created_at DESC, id DESC")
 	}
 
-	if err := query.First(&project).Error; err != nil {
-		return c.Status(404).JSON(fiber.Map{"error": "Project not found or access denied"})
+	messages := []models.ChatMessage{}
+	if err := query.Find(&messages).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch messages"})
 	}
 
-	// Get messages
-	var messages []models.ChatMessage
-	err := h.DB.Where("project_id = ?", projectID).
-		Preload("Sender").
-		Order("created_at ASC").
-		Find(&messages).Error
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+	if after == "" {
+		// Fetched newest first
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+
+	c.Set("X-Has-More", strconv.FormatBool(hasMore))
+	return c.JSON(messages)
+}
 
+// SearchMessages - GET /api/chats/search?q=<words>&project_id=&before=<message id>&limit=20
+// Finds the messages of the user's conversations containing every word of q, newest first
+func (h *ChatHandler) SearchMessages(c *fiber.Ctx) error {
+	words := strings.Fields(c.Query("q"))
+	if len(words) == 0 || utf8.RuneCountInString(strings.Join(words, "")) < 2 {
+		return c.Status(400).JSON(fiber.Map{"error": "q must be at least 2 characters"})
+	}
+	if len(words) > 10 {
+		words = words[:10]
+	}
+	limit := c.QueryInt("limit", 20)
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	userID, _ := c.Locals("user_id").(string)
+	userRole, _ := c.Locals("user_role").(string)
+	projectIDs, err := h.chatProjectIDs(userID, userRole)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch messages"})
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch projects"})
+	}
+	if projectID := c.Query("project_id"); projectID != "" {
+		if !slices.Contains(projectIDs, projectID) {
+			return c.Status(404).JSON(fiber.Map{"error": "Project not found or access denied"})
+		}
+		projectIDs = []string{projectID}
+	}
+
+	messages := []models.ChatMessage{}
+	if len(projectIDs) == 0 {
+		c.Set("X-Has-More", "false")
+		return c.JSON(messages)
+	}
+
+	query := h.DB.Where("project_id IN ?", projectIDs).
+		Preload("Sender").
+		Preload("Project", func(db *gorm.DB) *gorm.DB { return db.Select("id", "title") }).
+		Order("created_at DESC, id DESC").
+		Limit(limit + 1)
+	for _, word := range words {
+		query = query.Where(`message ILIKE ? ESCAPE '\'`, "%"+escapeLike(word)+"%")
+	}
+	if before := c.Query("before"); before != "" {
+		if !isUUID(before) {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid message ID"})
+		}
+		var anchor models.ChatMessage
+		if err := h.DB.Where("project_id IN ?", projectIDs).First(&anchor, "id = ?", before).Error; err != nil {
+			return messageLookupError(c, err)
+		}
+		query = query.Where("(created_at, id) < (?, ?)", anchor.CreatedAt, anchor.ID)
+	}
+
+	if err := query.Find(&messages).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to search messages"})
+	}
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
 	}
 
+	c.Set("X-Has-More", strconv.FormatBool(hasMore))
 	return c.JSON(messages)
 }
 
-// MarkAsRead - PATCH /api/chats/:project_id/read
+// syncOverlap is how far before the current time next_since is set, so messages saved during
+// a sync but stamped earlier are still picked up by the next one
+const syncOverlap = 5 * time.Second
+
+// SyncMessages - GET /api/chats/sync?since=<RFC 3339 time>&after=<message id>&limit=200
+// Catches a client up after it reconnects: the messages of all the user's conversations from
+// since on, the read cursors that moved since then and the unread counts. While has_more is
+// set, pass next_since and next_after to fetch the messages after the last one returned; once
+// caught up, next_since overlaps the present, so skip messages already known by ID.
+func (h *ChatHandler) SyncMessages(c *fiber.Ctx) error {
+	since, err := time.Parse(time.RFC3339Nano, c.Query("since"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "since must be an RFC 3339 time"})
+	}
+	limit := c.QueryInt("limit", 200)
+	if limit < 1 || limit > 500 {
+		limit = 200
+	}
+	nextSince := time.Now().Add(-syncOverlap)
+
+	userID, _ := c.Locals("user_id").(string)
+	userRole, _ := c.Locals("user_role").(string)
+	projectIDs, err := h.chatProjectIDs(userID, userRole)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch projects"})
+	}
+
+	messages := []models.ChatMessage{}
+	cursors := []models.ChatReadCursor{}
+	if len(projectIDs) > 0 {
+		query := h.DB.Where("project_id IN ? AND created_at >= ?", projectIDs, since)
+		// Messages can share a timestamp, so pages continue after the last message, not its time
+		if after := c.Query("after"); after != "" {
+			var anchor models.ChatMessage
+			if !isUUID(after) {
+				return messageLookupError(c, gorm.ErrRecordNotFound)
+			}
+			if err := h.DB.Where("project_id IN ?", projectIDs).First(&anchor, "id = ?", after).Error; err != nil {
+				return messageLookupError(c, err)
+			}
+			query = query.Where("(created_at, id) > (?, ?)", anchor.CreatedAt, anchor.ID)
+		}
+		if err := query.
+			Preload("Sender").
+			Order("created_at ASC, id ASC").
+			Limit(limit + 1).
+			Find(&messages).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch messages"})
+		}
+		if err := h.DB.Where("project_id IN ? AND read_at >= ?", projectIDs, since).Find(&cursors).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch read state"})
+		}
+	}
+	unread, err := h.unreadCounts(userID, projectIDs)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to count unread messages"})
+	}
+
+	hasMore := len(messages) > limit
+	nextAfter := ""
+	if hasMore {
+		messages = messages[:limit]
+		nextSince = since
+		nextAfter = messages[limit-1].ID.String()
+	}
+
+	return c.JSON(fiber.Map{
+		"messages":     messages,
+		"read_cursors": cursors,
+		"unread":       unread,
+		"has_more":     hasMore,
+		"next_since":   nextSince,
+		"next_after":   nextAfter,
+	})
+}
+
+// findMessage loads a message of a project; gorm.ErrRecordNotFound also covers a malformed ID
+func (h *ChatHandler) findMessage(projectID, messageID string) (*models.ChatMessage, error) {
+	if !isUUID(messageID) {
+		return nil, gorm.ErrRecordNotFound
+	}
+	var message models.ChatMessage
+	if err := h.DB.Where("project_id = ?", projectID).First(&message, "id = ?", messageID).Error; err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+func messageLookupError(c *fiber.Ctx, err error) error {
+	if err == gorm.ErrRecordNotFound {
+		return c.Status(404).JSON(fiber.Map{"error": "Message not found"})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch message"})
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// MarkAsRead - PATCH /api/chats/:project_id/read {"message_id": "..."}
+// Marks the messages up to message_id, or all of them when it is omitted, as read by the current user
 func (h *ChatHandler) MarkAsRead(c *fiber.Ctx) error {
 	projectID := c.Params("project_id")
-	userID := c.Locals("user_id")
+	if !isUUID(projectID) {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid project ID"})
+	}
+	if _, err := findAccessibleProject(h.DB, c, projectID); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Project not found or access denied"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch project"})
+	}
 
-	// Mark all messages in this project as read (except own messages)
-	result := h.DB.Model(&models.ChatMessage{}).
-		Where("project_id = ? AND sender_id != ?", projectID, userID).
-		Update("is_read", true)
+	var input struct {
+		MessageID string `json:"message_id"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
 
-	if result.Error != nil {
+	userID, _ := c.Locals("user_id").(string)
+	userName := ""
+	if claims, ok := c.Locals("user").(*models.JWTClaims); ok {
+		userName = claims.FullName
+	}
+
+	cursor, count, err := h.markRead(uuid.MustParse(projectID), userID, userName, input.MessageID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Message not found"})
+		}
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to mark messages as read"})
 	}
 
 	return c.JSON(fiber.Map{
 		"message": "Messages marked as read",
-		"count":   result.RowsAffected,
+		"count":   count,
+		"cursor":  cursor,
+	})
+}
+
+// GetReadState - GET /api/chats/:project_id/read
+// The last-read cursor of every member of the chat and the current user's unread count
+func (h *ChatHandler) GetReadState(c *fiber.Ctx) error {
+	projectID := c.Params("project_id")
+	if !isUUID(projectID) {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid project ID"})
+	}
+	if _, err := findAccessibleProject(h.DB, c, projectID); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Project not found or access denied"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch project"})
+	}
+
+	userID, _ := c.Locals("user_id").(string)
+	cursors := []models.ChatReadCursor{}
+	if err := h.DB.Preload("User").Where("project_id = ?", projectID).Order("read_at DESC").Find(&cursors).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch read state"})
+	}
+	unread, err := h.unreadCounts(userID, []string{projectID})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to count unread messages"})
+	}
+
+	var own *models.ChatReadCursor
+	for i := range cursors {
+		if cursors[i].UserID.String() == userID {
+			own = &cursors[i]
+		}
+	}
+	return c.JSON(fiber.Map{
+		"cursor":  own,
+		"cursors": cursors,
+		"unread":  unread[projectID],
 	})
 }
 
 // GetUnreadCount - GET /api/chats/unread
+// Messages of the user's projects sent by someone else that the user has not read, in total and per project
 func (h *ChatHandler) GetUnreadCount(c *fiber.Ctx) error {
-	userID := c.Locals("user_id")
-	userRole := c.Locals("user_role")
+	userID, _ := c.Locals("user_id").(string)
+	userRole, _ := c.Locals("user_role").(string)
+
+	projectIDs, err := h.chatProjectIDs(userID, userRole)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch projects"})
+	}
+	byProject, err := h.unreadCounts(userID, projectIDs)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to count unread messages"})
+	}
 
 	var count int64
+	for _, n := range byProject {
+		count += n
+	}
+	return c.JSON(fiber.Map{"count": count, "by_project": byProject})
+}
 
-	// Get projects for this user
-	var projectIDs []uuid.UUID
+// chatProjectIDs returns the projects whose chat the user can read: a student's own projects,
+// the projects an advisor advises, or every project for an admin, as in findAccessibleProject
+func (h *ChatHandler) chatProjectIDs(userID, userRole string) ([]string, error) {
+	projectIDs := []string{}
+	var query *gorm.DB
+	switch userRole {
+	case "student":
+		query = h.DB.Model(&models.Project{}).Where("student_id IN (?)", h.DB.Model(&models.Student{}).Select("id").Where("user_id = ?", userID))
+	case "advisor":
+		query = h.DB.Model(&models.Project{}).Where("advisor_id IN (?)", h.DB.Model(&models.Advisor{}).Select("id").Where("user_id = ?", userID))
+	case "admin":
+		query = h.DB.Model(&models.Project{})
+	default:
+		return projectIDs, nil
+	}
+	err := query.Pluck("id", &projectIDs).Error
+	return projectIDs, err
+}
 
-	if userRole == "student" {
-		var student models.Student
-		if err := h.DB.Where("user_id = ?", userID).First(&student).Error; err != nil {
-			return c.JSON(fiber.Map{"count": 0})
-		}
+// unreadCounts counts by project the messages of others the user has no message_reads row for
+func (h *ChatHandler) unreadCounts(userID string, projectIDs []string) (map[string]int64, error) {
+	counts := map[string]int64{}
+	if len(projectIDs) == 0 {
+		return counts, nil
+	}
 
-		h.DB.Model(&models.Project{}).
-			Where("student_id = ?", student.ID).
-			Pluck("id", &projectIDs)
+	var rows []struct {
+		ProjectID string
+		Count     int64
+	}
+	err := h.DB.Model(&models.ChatMessage{}).
+		Select("project_id, COUNT(*) AS count").
+		Where("project_id IN ? AND sender_id <> ?", projectIDs, userID).
+		Where("NOT EXISTS (SELECT 1 FROM message_reads r WHERE r.message_id = chat_messages.id AND r.user_id = ?)", userID).
+		Group("project_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		counts[r.ProjectID] = r.Count
+	}
+	return counts, nil
+}
+
+// markRead records that the user has read the project's messages from others up to messageID,
+// or up to the latest when it is empty, moves the user's read cursor forward and tells the chat
+// with a read receipt. It returns the cursor (nil in an empty chat) and how many messages were
+// newly read; gorm.ErrRecordNotFound means messageID is not a message of the project.
+func (h *ChatHandler) markRead(projectID uuid.UUID, userID, userName, messageID string) (*models.ChatReadCursor, int64, error) {
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, 0, err
+	}
 
-	} else if userRole == "advisor" {
-		var advisor models.Advisor
-		if err := h.DB.Where("user_id = ?", userID).First(&advisor).Error; err != nil {
-			return c.JSON(fiber.Map{"count": 0})
+	query := h.DB.Where("project_id = ?", projectID)
+	if messageID != "" {
+		if !isUUID(messageID) {
+			return nil, 0, gorm.ErrRecordNotFound
 		}
+		query = query.Where("id = ?", messageID)
+	}
+	var last models.ChatMessage
+	if err := query.Order("created_at DESC, id DESC").First(&last).Error; err != nil {
+		if err == gorm.ErrRecordNotFound && messageID == "" {
+			return nil, 0, nil
+		}
+		return nil, 0, err
+	}
 
-		h.DB.Model(&models.Project{}).
-			Where("advisor_id = ?", advisor.ID).
-			Pluck("id", &projectIDs)
+	cursor := models.ChatReadCursor{
+		ProjectID:        projectID,
+		UserID:           userUUID,
+		MessageID:        last.ID,
+		MessageCreatedAt: last.CreatedAt,
+		ReadAt:           time.Now(),
 	}
+	var count int64
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		upTo := tx.Where("project_id = ? AND sender_id <> ? AND (created_at, id) <= (?, ?)", projectID, userUUID, last.CreatedAt, last.ID)
+
+		result := tx.Exec("INSERT INTO message_reads (message_id, user_id, read_at) (?) ON CONFLICT DO NOTHING",
+			upTo.Session(&gorm.Session{}).Model(&models.ChatMessage{}).Select("id, ?::uuid, ?::timestamp", userUUID, cursor.ReadAt))
+		if result.Error != nil {
+			return result.Error
+		}
+		count = result.RowsAffected
+
+		// is_read stays true once anyone but the sender has read a message
+		if err := upTo.Session(&gorm.Session{}).Model(&models.ChatMessage{}).Where("is_read = ?", false).Update("is_read", true).Error; err != nil {
+			return err
+		}
 
-	// Count unread messages
-	h.DB.Model(&models.ChatMessage{}).
-		Where("project_id IN ? AND sender_id != ? AND is_read = ?", projectIDs, userID, false).
-		Count(&count)
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "project_id"}, {Name: "user_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"message_id", "message_created_at", "read_at"}),
+			Where: clause.Where{Exprs: []clause.Expression{
+				clause.Expr{SQL: "(chat_read_cursors.message_created_at, chat_read_cursors.message_id) < (excluded.message_created_at, excluded.message_id)"},
+			}},
+		}).Create(&cursor).Error; err != nil {
+			return err
+		}
+		// The stored cursor may be further ahead than the messages just read
+		return tx.Where("project_id = ? AND user_id = ?", projectID, userUUID).First(&cursor).Error
+	})
+	if err != nil {
+		return nil, 0, err
+	}
 
-	return c.JSON(fiber.Map{"count": count})
+	if count > 0 {
+		h.Hub.Publish(realtime.ProjectRoom(projectID.String()), models.WebSocketReadReceipt{
+			Type:      "read_receipt",
+			ProjectID: projectID.String(),
+			UserID:    userID,
+			UserName:  userName,
+			MessageID: last.ID.String(),
+			ReadAt:    cursor.ReadAt,
+		})
+	}
+	return &cursor, count, nil
 }
@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"archive/zip"
+	"backend/models"
+	"backend/storage"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// ExportHandler streams submissions as ZIP archives without buffering them in memory
+type ExportHandler struct {
+	DB      *gorm.DB
+	Storage storage.Storage
+}
+
+func NewExportHandler(db *gorm.DB, store storage.Storage) *ExportHandler {
+	return &ExportHandler{
+		DB:      db,
+		Storage: store,
+	}
+}
+
+// storedExts are already compressed, so they are stored in the archive as they are
+var storedExts = map[string]bool{
+	".zip": true, ".rar": true, ".7z": true, ".gz": true,
+	".docx": true, ".pptx": true, ".pdf": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".mp4": true,
+}
+
+// ExportProjectFiles - GET /api/projects/:id/export
+// All files of a project; ?latest=true limits it to the newest version of each document
+func (h *ExportHandler) ExportProjectFiles(c *fiber.Ctx) error {
+	project, err := findAccessibleProject(h.DB, c, c.Params("id"))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Project not found or access denied"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch project"})
+	}
+
+	query := h.DB.Where("project_files.project_id = ?", project.ID)
+	if c.QueryBool("latest") {
+		query = query.Where(models.LatestVersionCondition)
+	}
+
+	files, err := h.exportFiles(query)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch project files"})
+	}
+
+	return h.streamZip(c, "project-"+project.ID[:8]+".zip", files, false)
+}
+
+// ExportAdvisorCategory - GET /api/advisors/export?category=final_report
+// The latest file of a category from every project the advisor supervises (every project for admins)
+func (h *ExportHandler) ExportAdvisorCategory(c *fiber.Ctx) error {
+	userID := c.Locals("user_id")
+	userRole := c.Locals("user_role")
+
+	category := c.Query("category")
+	if !validCategories[category] {
+		return c.Status(400).JSON(fiber.Map{"error": "A valid category is required"})
+	}
+
+	query := h.DB.Joins("JOIN projects ON projects.id = project_files.project_id").
+		Where("project_files.file_category = ?", category).
+		Where(models.LatestVersionCondition)
+
+	if userRole == "advisor" {
+		var advisor models.Advisor
+		if err := h.DB.Where("user_id = ?", userID).First(&advisor).Error; err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "Advisor record not found"})
+		}
+		query = query.Where("projects.advisor_id = ?", advisor.ID)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("projects.status = ?", status)
+	}
+
+	files, err := h.exportFiles(query)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch files"})
+	}
+
+	return h.streamZip(c, category+"-"+time.Now().Format("20060102")+".zip", files, true)
+}
+
+// ExportTerm - GET /api/admin/export?academic_year=2568&semester=1
+// Every project registered in the term; latest versions only unless ?latest=false
+func (h *ExportHandler) ExportTerm(c *fiber.Ctx) error {
+	year, err := strconv.Atoi(c.Query("academic_year"))
+	if err != nil || year <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "academic_year is required"})
+	}
+
+	query := h.DB.Joins("JOIN projects ON projects.id = project_files.project_id").
+		Where("projects.academic_year = ?", year)
+
+	name := fmt.Sprintf("term-%d", year)
+	if semester := c.QueryInt("semester", 0); semester > 0 {
+		query = query.Where("projects.semester = ?", semester)
+		name += fmt.Sprintf("-%d", semester)
+	}
+	if category := c.Query("category"); category != "" {
+		if !validCategories[category] {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid category"})
+		}
+		query = query.Where("project_files.file_category = ?", category)
+	}
+	if c.QueryBool("latest", true) {
+		query = query.Where(models.LatestVersionCondition)
+	}
+
+	files, err := h.exportFiles(query)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch files"})
+	}
+
+	return h.streamZip(c, name+".zip", files, true)
+}
+
+// validCategories mirrors the file_category check constraint
+var validCategories = map[string]bool{
+	"proposal": true, "progress_report": true, "final_report": true,
+	"presentation": true, "source_code": true, "other": true,
+}
+
+// exportFiles loads the files selected by query together with their project and student
+func (h *ExportHandler) exportFiles(query *gorm.DB) ([]models.ProjectFile, error) {
+	var files []models.ProjectFile
+	err := query.Select("project_files.*").
+		Preload("Project.Student.User").
+		Order("project_files.project_id, project_files.file_category, project_files.document_id, project_files.version").
+		Find(&files).Error
+	return files, err
+}
+
+// streamZip writes the files and a manifest.csv as a ZIP archive straight to the response.
+// Files that cannot be read are listed in the manifest instead of aborting the download.
+func (h *ExportHandler) streamZip(c *fiber.Ctx, name string, files []models.ProjectFile, perStudent bool) error {
+	if len(files) == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "No files to export"})
+	}
+
+	c.Attachment(name)
+	c.Set(fiber.HeaderCacheControl, "no-store")
+
+	// The writer runs after the handler returns, so it must not touch c
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ctx := context.Background()
+		zw := zip.NewWriter(w)
+
+		manifest := [][]string{{
+			"path", "file_id", "document_id", "version", "category", "file_status",
+			"project_id", "project_title", "student_id", "student_name",
+			"file_size", "sha256", "uploaded_at", "status",
+		}}
+
+		used := map[string]bool{}
+		for i := range files {
+			f := &files[i]
+			entry := exportPath(f, perStudent)
+			if used[entry] {
+				entry = path.Join(path.Dir(entry), f.ID[:8]+"_"+path.Base(entry))
+			}
+			used[entry] = true
+
+			size, sum, status := h.writeEntry(ctx, zw, entry, f)
+
+			var studentID, studentName, projectTitle string
+			if f.Project != nil {
+				projectTitle = f.Project.Title
+				if f.Project.Student != nil && f.Project.Student.User != nil {
+					studentID = f.Project.Student.User.StudentID
+					studentName = f.Project.Student.User.FullName
+				}
+			}
+			if status != "ok" {
+				entry = ""
+			}
+			manifest = append(manifest, []string{
+				entry, f.ID, f.DocumentID, strconv.Itoa(f.Version), f.FileCategory, f.FileStatus,
+				f.ProjectID, projectTitle, studentID, studentName,
+				strconv.FormatInt(size, 10), sum, f.CreatedAt.Format(time.RFC3339), status,
+			})
+
+			// Push what we have to the client instead of holding it in the buffer
+			if err := w.Flush(); err != nil {
+				log.Printf("Export %s aborted by client: %v", name, err)
+				return
+			}
+		}
+
+		mw, err := zw.CreateHeader(&zip.FileHeader{Name: "manifest.csv", Method: zip.Deflate, Modified: time.Now()})
+		if err == nil {
+			// Byte order mark so Excel opens the Thai text as UTF-8
+			mw.Write([]byte("\ufeff"))
+			cw := csv.NewWriter(mw)
+			cw.WriteAll(manifest)
+		}
+		if err := zw.Close(); err != nil {
+			log.Printf("Failed to finish export %s: %v", name, err)
+			return
+		}
+		w.Flush()
+	})
+	return nil
+}
+
+// writeEntry copies one stored file into the archive and returns its size, SHA-256 and manifest status
+func (h *ExportHandler) writeEntry(ctx context.Context, zw *zip.Writer, entry string, f *models.ProjectFile) (int64, string, string) {
+	if f.ScanStatus == "infected" {
+		return 0, "", "quarantined"
+	}
+
+	r, err := h.Storage.Get(ctx, f.StorageKey())
+	if err == storage.ErrNotFound {
+		return 0, "", "missing"
+	}
+	if err != nil {
+		log.Printf("Export failed to open %s: %v", f.StorageKey(), err)
+		return 0, "", "error"
+	}
+	defer r.Close()
+
+	method := zip.Deflate
+	if storedExts[strings.ToLower(filepath.Ext(f.FileName))] {
+		method = zip.Store
+	}
+	zf, err := zw.CreateHeader(&zip.FileHeader{Name: entry, Method: method, Modified: f.CreatedAt})
+	if err != nil {
+		return 0, "", "error"
+	}
+
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(zf, hash), r)
+	if err != nil {
+		// The entry is truncated; the manifest tells the reader not to trust it
+		log.Printf("Export failed to copy %s: %v", f.StorageKey(), err)
+		return n, "", "error"
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if f.SHA256 != "" && sum != f.SHA256 {
+		log.Printf("Export found checksum mismatch for %s: expected %s, got %s", f.StorageKey(), f.SHA256, sum)
+		return n, sum, "corrupt"
+	}
+	return n, sum, "ok"
+}
+
+// exportPath is where a file goes inside the archive:
+// [<student id>_<name>/]<category>/v<version>_<file name>
+func exportPath(f *models.ProjectFile, perStudent bool) string {
+	entry := path.Join(safeName(f.FileCategory), fmt.Sprintf("v%d_%s", f.Version, safeName(f.FileName)))
+	if !perStudent {
+		return entry
+	}
+
+	folder := f.ProjectID[:8]
+	if f.Project != nil && f.Project.Student != nil && f.Project.Student.User != nil {
+		user := f.Project.Student.User
+		folder = safeName(strings.TrimSpace(user.StudentID + "_" + user.FullName))
+	}
+	return path.Join(folder, entry)
+}
+
+// safeName keeps a user supplied name from creating folders or escaping the archive
+func safeName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		if r < 0x20 {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, ". ")
+	if name == "" {
+		return "_"
+	}
+	return name
+}
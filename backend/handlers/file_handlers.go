@@ -1,83 +1,176 @@
 package handlers
 
 import (
+	"backend/events"
+	"backend/filescan"
 	"backend/models"
-	"os"
+	"backend/notify"
+	"backend/preview"
+	"backend/settings"
+	"backend/similarity"
+	"backend/storage"
+	"context"
+	"fmt"
+	"io"
+	"log"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type FileHandler struct {
-	DB *gorm.DB
+	DB         *gorm.DB
+	Storage    storage.Storage
+	Scanner    filescan.Scanner // nil disables malware scanning
+	Settings   *settings.Service
+	Previews   *preview.Worker     // nil disables preview generation
+	Similarity *similarity.Checker // nil disables similarity checks
+	Notifier   *notify.Service
+	Events     *events.Bus
 }
 
-func NewFileHandler(db *gorm.DB) *FileHandler {
-	// Create uploads directory if not exists
-	if err := os.MkdirAll("./uploads", 0755); err != nil {
-		panic("Failed to create uploads directory: " + err.Error())
-	}
-
+func NewFileHandler(db *gorm.DB, store storage.Storage, scanner filescan.Scanner, settingsService *settings.Service, previews *preview.Worker, checker *similarity.Checker, notifier *notify.Service, bus *events.Bus) *FileHandler {
 	return &FileHandler{
-		DB: db,
+		DB:         db,
+		Storage:    store,
+		Scanner:    scanner,
+		Settings:   settingsService,
+		Previews:   previews,
+		Similarity: checker,
+		Notifier:   notifier,
+		Events:     bus,
 	}
 }
 
 // UploadFile - POST /api/projects/:id/files
+// Passing document_id in the form uploads a new version of that document
 func (h *FileHandler) UploadFile(c *fiber.Ctx) error {
-	projectId := c.Params("id")
+	return h.uploadFile(c, c.Params("id"), c.FormValue("document_id"))
+}
 
-	// Verify project exists
-	var project models.Project
-	if err := h.DB.First(&project, "id = ?", projectId).Error; err != nil {
+// UploadFileVersion - POST /api/files/:id/versions
+func (h *FileHandler) UploadFileVersion(c *fiber.Ctx) error {
+	existing, err := findAccessibleFile(h.DB, c, c.Params("id"))
+	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return c.Status(404).JSON(fiber.Map{"error": "Project not found"})
+			return c.Status(404).JSON(fiber.Map{"error": "File not found or access denied"})
 		}
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to verify project"})
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch file"})
 	}
 
+	return h.uploadFile(c, existing.ProjectID, existing.DocumentID)
+}
+
+func (h *FileHandler) uploadFile(c *fiber.Ctx, projectId string, documentId string) error {
 	// Get file from form
 	file, err := c.FormFile("file")
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "No file uploaded"})
 	}
 
-	// Validate file size (10MB limit)
-	maxSize := int64(10 * 1024 * 1024) // 10MB
-	if file.Size > maxSize {
-		return c.Status(400).JSON(fiber.Map{"error": "File too large (max 10MB)"})
+	target, err := h.resolveUploadTarget(c, projectId, documentId, c.FormValue("file_category"), file.Filename, file.Size)
+	if err != nil {
+		return uploadErrorResponse(c, err)
 	}
 
-	// Validate file extension
-	allowedExts := []string{".pdf", ".doc", ".docx", ".ppt", ".pptx", ".zip", ".rar", ".txt", ".jpg", ".jpeg", ".png"}
-	ext := strings.ToLower(filepath.Ext(file.Filename))
-	isAllowed := false
-	for _, allowedExt := range allowedExts {
-		if ext == allowedExt {
-			isAllowed = true
-			break
+	// Get user ID from JWT token
+	userID, ok := c.Locals("user_id").(string)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User not authenticated",
+		})
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to read uploaded file"})
+	}
+	defer src.Close()
+
+	projectFile, err := h.saveUpload(c.Context(), target, src, file.Size, file.Filename, c.FormValue("description"), userID)
+	if err != nil {
+		return uploadErrorResponse(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "File uploaded successfully",
+		"file":    projectFile,
+	})
+}
+
+// uploadError is an upload failure with the HTTP status and message to return
+type uploadError struct {
+	Status  int
+	Message string
+	Extra   fiber.Map
+
+	Quarantined *models.ProjectFile // the upload was stored in quarantine, so retrying it is pointless
+}
+
+func (e *uploadError) Error() string {
+	return e.Message
+}
+
+func uploadErrorResponse(c *fiber.Ctx, err error) error {
+	if uploadErr, ok := err.(*uploadError); ok {
+		body := fiber.Map{"error": uploadErr.Message}
+		for k, v := range uploadErr.Extra {
+			body[k] = v
 		}
+		return c.Status(uploadErr.Status).JSON(body)
 	}
-	if !isAllowed {
-		return c.Status(400).JSON(fiber.Map{"error": "File type not allowed"})
+	return c.Status(500).JSON(fiber.Map{
+		"error":   "Failed to upload file",
+		"details": err.Error(),
+	})
+}
+
+// uploadTarget is where an upload goes, resolved before its bytes are read
+type uploadTarget struct {
+	ProjectID string
+	Category  string
+	Ext       string
+	Previous  *models.ProjectFile // latest version when uploading a new version of a document
+	Policy    settings.UploadPolicy
+}
+
+// resolveUploadTarget checks project access, the document and category of an upload and the size/extension limits
+func (h *FileHandler) resolveUploadTarget(c *fiber.Ctx, projectId, documentId, category, fileName string, size int64) (*uploadTarget, error) {
+	// Verify project exists and the uploader may access it
+	if _, err := findAccessibleProject(h.DB, c, projectId); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, &uploadError{Status: 404, Message: "Project not found"}
+		}
+		return nil, &uploadError{Status: 500, Message: "Failed to verify project"}
 	}
 
-	// Generate unique filename
-	fileId := uuid.New().String()
-	newFilename := fileId + ext
-	savePath := filepath.Join("uploads", newFilename)
+	// A new version belongs to an existing document of this project
+	var previous *models.ProjectFile
+	if documentId != "" {
+		var latest models.ProjectFile
+		if err := h.DB.Where("document_id = ? AND project_id = ?", documentId, projectId).
+			Order("version DESC").
+			First(&latest).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return nil, &uploadError{Status: 404, Message: "Document not found"}
+			}
+			return nil, &uploadError{Status: 500, Message: "Failed to fetch document"}
+		}
+		previous = &latest
 
-	// Save file to disk
-	if err := c.SaveFile(file, savePath); err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to save file"})
+		// Versions keep the category of the document
+		if category == "" {
+			category = latest.FileCategory
+		}
 	}
 
-	// Get category from form (default to 'other')
-	category := c.FormValue("file_category")
+	// Default to 'other'
 	if category == "" {
 		category = "other"
 	}
@@ -95,61 +188,303 @@ func (h *FileHandler) UploadFile(c *fiber.Ctx) error {
 		category = "other"
 	}
 
-	// Get user ID from JWT token
-	userID, ok := c.Locals("user_id").(string)
-	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "User not authenticated",
-		})
+	// Size and type limits come from system_settings (per category)
+	policy := h.Settings.UploadPolicy(category)
+
+	// Validate file size
+	if size > policy.MaxBytes {
+		return nil, &uploadError{Status: 400, Message: fmt.Sprintf("File too large (max %dMB)", policy.MaxSizeMB)}
+	}
+
+	// Validate file extension
+	ext := strings.ToLower(filepath.Ext(fileName))
+	if !policy.Allows(ext) {
+		return nil, &uploadError{
+			Status:  400,
+			Message: "File type not allowed",
+			Extra:   fiber.Map{"allowed_extensions": policy.AllowedExts},
+		}
+	}
+
+	// Reject uploads over quota before any bytes are received
+	userID, _ := c.Locals("user_id").(string)
+	if err := checkQuota(h.DB, h.Settings, projectId, userID, size, true); err != nil {
+		return nil, err
+	}
+
+	return &uploadTarget{
+		ProjectID: projectId,
+		Category:  category,
+		Ext:       ext,
+		Previous:  previous,
+		Policy:    policy,
+	}, nil
+}
+
+// saveUpload validates and scans the bytes of an upload, stores them and records the ProjectFile
+func (h *FileHandler) saveUpload(ctx context.Context, target *uploadTarget, src io.ReaderAt, size int64, fileName, description, userID string) (*models.ProjectFile, error) {
+	// Check the actual bytes instead of trusting the extension and the client's Content-Type
+	fileType, err := filescan.Validate(src, size, target.Ext, filescan.DefaultLimits)
+	if err != nil {
+		if rejectErr, ok := err.(*filescan.RejectError); ok {
+			return nil, &uploadError{Status: 400, Message: rejectErr.Reason}
+		}
+		return nil, &uploadError{Status: 500, Message: "Failed to inspect uploaded file"}
+	}
+
+	// Identical clean uploads share one content-addressed blob
+	sum, _, err := storage.HashReader(io.NewSectionReader(src, 0, size))
+	if err != nil {
+		return nil, &uploadError{Status: 500, Message: "Failed to read uploaded file"}
+	}
+
+	fileId := uuid.New().String()
+	storageKey := models.BlobKey(sum)
+
+	// Scan for malware; infected files are kept in quarantine for the admins
+	scanStatus := "not_scanned"
+	var scanResult string
+	var scannedAt *time.Time
+	if h.Scanner != nil {
+		result, err := h.Scanner.Scan(ctx, io.NewSectionReader(src, 0, size))
+		if err != nil {
+			log.Printf("Malware scan failed for %s: %v", fileName, err)
+			return nil, &uploadError{Status: fiber.StatusServiceUnavailable, Message: "Virus scanner unavailable, please try again later"}
+		}
+		now := time.Now()
+		scannedAt = &now
+		scanStatus = "clean"
+		if result.Infected {
+			scanStatus = "infected"
+			scanResult = result.Signature
+			// Quarantined copies are never shared
+			storageKey = models.QuarantinePrefix + fileId + target.Ext
+		}
+	}
+
+	// Save file to the storage backend unless the same content is already stored
+	stored := false
+	if scanStatus != "infected" {
+		stored, err = h.blobStored(ctx, sum, storageKey)
+		if err != nil {
+			log.Printf("Failed to look up blob %s: %v", sum, err)
+			return nil, &uploadError{Status: 500, Message: "Failed to save file"}
+		}
+	}
+	if !stored {
+		if err := h.Storage.Put(ctx, storageKey, io.NewSectionReader(src, 0, size), size, fileType); err != nil {
+			log.Printf("Failed to store file %s: %v", storageKey, err)
+			return nil, &uploadError{Status: 500, Message: "Failed to save file"}
+		}
+		// A rewritten blob replaces content that may have been reported corrupt
+		h.DB.Model(&models.ProjectFile{}).
+			Where("file_path IN ? AND integrity_status = ?", storedPaths(storageKey), models.IntegrityCorrupt).
+			Update("integrity_status", models.IntegrityUnverified)
 	}
 
-	// Get description from form
-	description := c.FormValue("description")
 	if description == "" {
-		description = file.Filename // Default to filename if no description
+		description = fileName // Default to filename if no description
 	}
 
-	// Get file type from content type
-	fileType := file.Header.Get("Content-Type")
-	if fileType == "" {
-		fileType = "application/octet-stream"
+	previewStatus := models.PreviewNone
+	if h.Previews != nil && scanStatus != "infected" && preview.Supported(target.Ext) {
+		previewStatus = models.PreviewPending
+	}
+	similarityStatus := models.SimilarityNone
+	if h.Similarity != nil && scanStatus != "infected" && similarity.Applies(target.Category, target.Ext) {
+		similarityStatus = models.SimilarityPending
 	}
 
-	// Create file size pointer
-	fileSize := file.Size
+	// Freshly written content matches the hash; a shared blob keeps its own verification history
+	integrityStatus := models.IntegrityUnverified
+	var verifiedAt *time.Time
+	if !stored {
+		now := time.Now()
+		integrityStatus = models.IntegrityOK
+		verifiedAt = &now
+	}
 
 	// Save file record to database
 	projectFile := models.ProjectFile{
-		ID:           fileId,
-		ProjectID:    projectId,
-		UploadedBy:   userID,
-		FileName:     file.Filename,
-		FilePath:     savePath,
-		FileSize:     &fileSize,
-		FileType:     fileType,
-		FileCategory: category,
-		FileStatus:   "pending",
-		Version:      1,
-		Description:  description,
-		IsPublic:     false,
+		ID:               fileId,
+		ProjectID:        target.ProjectID,
+		DocumentID:       fileId, // The first version starts a new document
+		UploadedBy:       userID,
+		FileName:         fileName,
+		FilePath:         storageKey,
+		FileSize:         &size,
+		FileType:         fileType,
+		FileCategory:     target.Category,
+		FileStatus:       "pending",
+		Version:          1,
+		Description:      description,
+		IsPublic:         false, // also for new versions, which the showcase shows only after approval
+		ScanStatus:       scanStatus,
+		ScanResult:       scanResult,
+		ScannedAt:        scannedAt,
+		PreviewStatus:    previewStatus,
+		SimilarityStatus: similarityStatus,
+		SHA256:           sum,
+		IntegrityStatus:  integrityStatus,
+		VerifiedAt:       verifiedAt,
 		// CreatedAt และ UpdatedAt จะถูกตั้งค่าอัตโนมัติ
 	}
 
-	if err := h.DB.Create(&projectFile).Error; err != nil {
-		// Clean up file if database save fails
-		os.Remove(savePath)
-		return c.Status(500).JSON(fiber.Map{
-			"error":   "Failed to save file record",
-			"details": err.Error(),
-		})
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if scanStatus != "infected" {
+			// Serialize uploads to the project and by the user so concurrent ones cannot both pass the quota
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&models.Project{}, "id = ?", target.ProjectID).Error; err != nil {
+				return err
+			}
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&models.User{}, "id = ?", userID).Error; err != nil {
+				return err
+			}
+			if err := checkQuota(tx, h.Settings, target.ProjectID, userID, size, false); err != nil {
+				return err
+			}
+		}
+		if target.Previous != nil {
+			// Lock the current latest version so concurrent uploads get distinct numbers
+			var latest models.ProjectFile
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("document_id = ?", target.Previous.DocumentID).
+				Order("version DESC").
+				First(&latest).Error; err != nil {
+				return err
+			}
+			projectFile.DocumentID = latest.DocumentID
+			projectFile.Version = latest.Version + 1
+		}
+		return tx.Create(&projectFile).Error
+	})
+	if err != nil {
+		// Clean up file if database save fails, unless another upload now shares the blob
+		if !stored && !h.blobReferenced(sum, storageKey) {
+			h.Storage.Delete(ctx, storageKey)
+		}
+		if quotaErr, ok := err.(*uploadError); ok {
+			return nil, quotaErr
+		}
+		return nil, &uploadError{Status: 500, Message: "Failed to save file record", Extra: fiber.Map{"details": err.Error()}}
+	}
+
+	if scanStatus == "infected" {
+		log.Printf("Quarantined infected upload %s (%s) for project %s", projectFile.ID, scanResult, target.ProjectID)
+		return nil, &uploadError{
+			Status:      fiber.StatusUnprocessableEntity,
+			Message:     "The file is infected and has been quarantined",
+			Extra:       fiber.Map{"file": projectFile},
+			Quarantined: &projectFile,
+		}
+	}
+
+	if previewStatus == models.PreviewPending {
+		h.Previews.Enqueue()
+	}
+	if similarityStatus == models.SimilarityPending {
+		h.Similarity.Enqueue()
+	}
+	h.Events.Publish(events.FileUploaded{
+		FileID:     projectFile.ID,
+		ProjectID:  projectFile.ProjectID,
+		UploaderID: userID,
+		FileName:   projectFile.FileName,
+		Version:    projectFile.Version,
+	})
+
+	return &projectFile, nil
+}
+
+// blobStored reports whether the content with this SHA-256 is already stored and referenced.
+// A referenced blob that is missing from storage is treated as not stored, so the upload restores it.
+func (h *FileHandler) blobStored(ctx context.Context, sum, key string) (bool, error) {
+	var count int64
+	if err := h.DB.Model(&models.FileBlob{}).Where("sha256 = ? AND ref_count > 0", sum).Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count == 0 {
+		return false, nil
+	}
+
+	var corrupt int64
+	if err := h.DB.Model(&models.ProjectFile{}).
+		Where("file_path IN ? AND integrity_status = ?", storedPaths(key), models.IntegrityCorrupt).
+		Count(&corrupt).Error; err != nil {
+		return false, err
+	}
+	if corrupt > 0 {
+		return false, nil
+	}
+
+	if _, err := h.Storage.Stat(ctx, key); err != nil {
+		if err == storage.ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// blobReferenced reports whether any file row uses the blob; errors count as referenced to stay safe
+func (h *FileHandler) blobReferenced(sum, key string) bool {
+	var count int64
+	if err := h.DB.Model(&models.ProjectFile{}).Where("sha256 = ? AND file_path IN ?", sum, storedPaths(key)).Count(&count).Error; err != nil {
+		return true
+	}
+	return count > 0
+}
+
+// GetFileVersions - GET /api/files/:id/versions
+func (h *FileHandler) GetFileVersions(c *fiber.Ctx) error {
+	projectFile, err := findAccessibleFile(h.DB, c, c.Params("id"))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "File not found or access denied"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch file"})
+	}
+
+	var versions []models.ProjectFile
+	if err := h.DB.Where("document_id = ?", projectFile.DocumentID).
+		Order("version DESC").
+		Find(&versions).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch file versions"})
 	}
 
 	return c.JSON(fiber.Map{
-		"message": "File uploaded successfully",
-		"file":    projectFile,
+		"document_id":    projectFile.DocumentID,
+		"latest_version": versions[0].Version,
+		"versions":       versions,
 	})
 }
 
+// DownloadFileVersion - GET /api/files/:id/versions/:version/download
+func (h *FileHandler) DownloadFileVersion(c *fiber.Ctx) error {
+	version, err := strconv.Atoi(c.Params("version"))
+	if err != nil || version < 1 {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid version"})
+	}
+
+	projectFile, err := findAccessibleFile(h.DB, c, c.Params("id"))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "File not found or access denied"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch file"})
+	}
+
+	var versionFile models.ProjectFile
+	if err := h.DB.Where("document_id = ? AND version = ?", projectFile.DocumentID, version).
+		First(&versionFile).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Version not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch file"})
+	}
+
+	return h.sendFile(c, &versionFile)
+}
+
 // GetFileById - GET /api/files/:id
 func (h *FileHandler) GetFileById(c *fiber.Ctx) error {
 	fileId := c.Params("id")
@@ -166,69 +501,140 @@ func (h *FileHandler) GetFileById(c *fiber.Ctx) error {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch file"})
 	}
 
+	if _, err := findAccessibleProject(h.DB, c, projectFile.ProjectID); err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "File not found or access denied"})
+	}
+
 	return c.JSON(projectFile)
 }
 
 // DownloadFile - GET /api/files/:id/download
 func (h *FileHandler) DownloadFile(c *fiber.Ctx) error {
-	fileId := c.Params("id")
-	var projectFile models.ProjectFile
+	projectFile, err := findAccessibleFile(h.DB, c, c.Params("id"))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "File not found or access denied"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch file"})
+	}
 
-	if err := h.DB.First(&projectFile, "id = ?", fileId).Error; err != nil {
+	return h.sendFile(c, projectFile)
+}
+
+// GetDownloadLink - GET /api/files/:id/download-link
+// Returns a short-lived signed URL that works without the Authorization header (for <a href>, <img> and emails)
+func (h *FileHandler) GetDownloadLink(c *fiber.Ctx) error {
+	projectFile, err := findAccessibleFile(h.DB, c, c.Params("id"))
+	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return c.Status(404).JSON(fiber.Map{"error": "File not found"})
+			return c.Status(404).JSON(fiber.Map{"error": "File not found or access denied"})
 		}
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch file"})
 	}
 
-	// Check if file exists on disk
-	if _, err := os.Stat(projectFile.FilePath); os.IsNotExist(err) {
-		return c.Status(404).JSON(fiber.Map{"error": "File not found on disk"})
+	// Default 5 minutes, at most models.MaxDownloadLinkTTL
+	ttl := time.Duration(c.QueryInt("ttl", 300)) * time.Second
+	if ttl <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid ttl"})
 	}
 
-	return c.Download(projectFile.FilePath, projectFile.FileName)
+	url, expiresAt := models.SignedFileDownloadURL(projectFile.ID, ttl)
+
+	return c.JSON(fiber.Map{
+		"url":        url,
+		"expires_at": expiresAt,
+	})
 }
 
-// ReviewFile - PATCH /api/files/:id/review
-func (h *FileHandler) ReviewFile(c *fiber.Ctx) error {
+// SignedDownload - GET /api/files/:id/signed-download?expires=...&signature=...
+// Public route: the HMAC signature issued by GetDownloadLink is the authorization
+func (h *FileHandler) SignedDownload(c *fiber.Ctx) error {
 	fileId := c.Params("id")
 
-	var input struct {
-		Status   string `json:"status"` // "approved" or "rejected"
-		Comments string `json:"comments"`
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil || !models.VerifyFileDownload(fileId, expires, c.Query("signature")) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Invalid or expired download link"})
 	}
 
-	if err := c.BodyParser(&input); err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "Invalid input"})
+	var projectFile models.ProjectFile
+	if err := h.DB.First(&projectFile, "id = ?", fileId).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "File not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch file"})
 	}
 
-	// Validate status
-	if input.Status != "approved" && input.Status != "rejected" {
-		return c.Status(400).JSON(fiber.Map{"error": "Invalid status. Use 'approved' or 'rejected'"})
+	return h.sendFile(c, &projectFile)
+}
+
+// sendFile streams a stored file to the client as an attachment
+func (h *FileHandler) sendFile(c *fiber.Ctx, projectFile *models.ProjectFile) error {
+	if projectFile.ScanStatus == "infected" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "The file is quarantined because it is infected"})
 	}
 
-	// Update file status
-	now := time.Now()
-	result := h.DB.Model(&models.ProjectFile{}).
-		Where("id = ?", fileId).
-		Updates(map[string]interface{}{
-			"file_status": input.Status,   // แก้ไข: ใช้ file_status
-			"description": input.Comments, // แก้ไข: ใช้ description แทน comments
-			"updated_at":  &now,
-		})
+	if projectFile.IntegrityStatus == models.IntegrityCorrupt {
+		return c.Status(500).JSON(fiber.Map{"error": "The stored file failed its integrity check; please ask an administrator"})
+	}
 
-	if result.Error != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"error":   "Failed to update file status",
-			"details": result.Error.Error(),
+	key := projectFile.StorageKey()
+	r, size, openErr := h.openStored(c.Context(), key)
+	if openErr != nil {
+		return uploadErrorResponse(c, openErr)
+	}
+
+	// The response is cut short instead of completing with corrupted content
+	if expected := projectFile.SHA256; expected != "" {
+		r = storage.NewVerifyingReader(r, size, expected, func(actual string) {
+			log.Printf("Checksum mismatch for %s: expected %s, got %s", key, expected, actual)
+			h.markCorrupt(key)
 		})
 	}
 
-	if result.RowsAffected == 0 {
-		return c.Status(404).JSON(fiber.Map{"error": "File not found"})
+	contentType := projectFile.FileType
+	if contentType == "" {
+		contentType = fiber.MIMEOctetStream
+	}
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Attachment(projectFile.FileName)
+	return c.SendStream(r, int(size))
+}
+
+// markCorrupt flags every file stored under key, so it is not served until repaired or re-uploaded
+func (h *FileHandler) markCorrupt(key string) {
+	now := time.Now()
+	if err := h.DB.Model(&models.ProjectFile{}).
+		Where("file_path IN ?", storedPaths(key)).
+		Updates(map[string]interface{}{
+			"integrity_status": models.IntegrityCorrupt,
+			"verified_at":      &now,
+		}).Error; err != nil {
+		log.Printf("Failed to flag %s as corrupt: %v", key, err)
+	}
+}
+
+// storedPaths returns the file_path values of rows stored under key; legacy rows keep the "uploads/" prefix
+func storedPaths(key string) []string {
+	return []string{key, "uploads/" + key}
+}
+
+// openStored opens an object in the storage backend together with its size
+func (h *FileHandler) openStored(ctx context.Context, key string) (io.ReadCloser, int64, *uploadError) {
+	info, err := h.Storage.Stat(ctx, key)
+	if err == storage.ErrNotFound {
+		return nil, 0, &uploadError{Status: 404, Message: "File not found in storage"}
+	}
+	if err != nil {
+		log.Printf("Failed to stat file %s: %v", key, err)
+		return nil, 0, &uploadError{Status: 500, Message: "Failed to read file"}
 	}
 
-	return c.JSON(fiber.Map{"message": "File status updated successfully"})
+	r, err := h.Storage.Get(ctx, key)
+	if err != nil {
+		log.Printf("Failed to open file %s: %v", key, err)
+		return nil, 0, &uploadError{Status: 500, Message: "Failed to read file"}
+	}
+	return r, info.Size, nil
 }
 
 // GetRecentFiles - GET /api/files/recent
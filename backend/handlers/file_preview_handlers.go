@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/preview"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// GetFilePreview - GET /api/files/:id/preview
+// Streams the PDF preview inline, or reports that it is still being generated
+func (h *FileHandler) GetFilePreview(c *fiber.Ctx) error {
+	projectFile, err := findAccessibleFile(h.DB, c, c.Params("id"))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "File not found or access denied"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch file"})
+	}
+
+	if projectFile.ScanStatus == "infected" || projectFile.PreviewStatus != models.PreviewReady {
+		return previewUnavailable(c, projectFile)
+	}
+
+	r, size, openErr := h.openStored(c.Context(), projectFile.PreviewKey)
+	if openErr != nil {
+		return uploadErrorResponse(c, openErr)
+	}
+
+	name := strings.TrimSuffix(projectFile.FileName, filepath.Ext(projectFile.FileName)) + ".pdf"
+	c.Set(fiber.HeaderContentType, "application/pdf")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("inline; filename=%q", name))
+	c.Set(fiber.HeaderCacheControl, "private, max-age=300")
+	return c.SendStream(r, int(size))
+}
+
+// GetFileThumbnail - GET /api/files/:id/thumbnail
+// Returns a PNG of the first page
+func (h *FileHandler) GetFileThumbnail(c *fiber.Ctx) error {
+	projectFile, err := findAccessibleFile(h.DB, c, c.Params("id"))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "File not found or access denied"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch file"})
+	}
+
+	if projectFile.ScanStatus == "infected" || projectFile.PreviewStatus != models.PreviewReady {
+		return previewUnavailable(c, projectFile)
+	}
+
+	r, size, openErr := h.openStored(c.Context(), projectFile.ThumbnailKey)
+	if openErr != nil {
+		return uploadErrorResponse(c, openErr)
+	}
+
+	c.Set(fiber.HeaderContentType, "image/png")
+	c.Set(fiber.HeaderCacheControl, "private, max-age=3600")
+	return c.SendStream(r, int(size))
+}
+
+// RegeneratePreview - POST /api/files/:id/preview
+// Queues the file for preview generation again, e.g. after a failure
+func (h *FileHandler) RegeneratePreview(c *fiber.Ctx) error {
+	if h.Previews == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "Preview generation is disabled"})
+	}
+
+	projectFile, err := findAccessibleFile(h.DB, c, c.Params("id"))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "File not found or access denied"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch file"})
+	}
+
+	if !preview.Supported(filepath.Ext(projectFile.FileName)) {
+		return c.Status(400).JSON(fiber.Map{"error": "Previews are not available for this file type"})
+	}
+	if projectFile.ScanStatus == "infected" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "The file is quarantined because it is infected"})
+	}
+	if projectFile.PreviewStatus == models.PreviewPending || projectFile.PreviewStatus == models.PreviewProcessing {
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"preview_status": projectFile.PreviewStatus})
+	}
+
+	if err := h.DB.Model(&models.ProjectFile{}).
+		Where("id = ?", projectFile.ID).
+		Updates(map[string]interface{}{
+			"preview_status":   models.PreviewPending,
+			"preview_attempts": 0,
+			"preview_error":    "",
+		}).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to queue preview"})
+	}
+	h.Previews.Enqueue()
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"preview_status": models.PreviewPending})
+}
+
+// previewUnavailable explains why the preview of the file cannot be served
+func previewUnavailable(c *fiber.Ctx, projectFile *models.ProjectFile) error {
+	if projectFile.ScanStatus == "infected" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "The file is quarantined because it is infected"})
+	}
+
+	switch projectFile.PreviewStatus {
+	case models.PreviewPending, models.PreviewProcessing:
+		c.Set(fiber.HeaderRetryAfter, "5")
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"message":        "Preview is being generated",
+			"preview_status": projectFile.PreviewStatus,
+		})
+	case models.PreviewFailed:
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":          "Preview could not be generated",
+			"preview_status": projectFile.PreviewStatus,
+			"details":        projectFile.PreviewError,
+		})
+	default:
+		return c.Status(404).JSON(fiber.Map{
+			"error":          "No preview is available for this file",
+			"preview_status": projectFile.PreviewStatus,
+		})
+	}
+}
@@ -0,0 +1,344 @@
+package handlers
+
+import (
+	"backend/events"
+	"backend/models"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ReviewFile - PATCH /api/files/:id/review
+// Records a new review round; the student's description is left untouched
+func (h *FileHandler) ReviewFile(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	userRole := c.Locals("user_role")
+	if userRole != "advisor" && userRole != "admin" {
+		return c.Status(403).JSON(fiber.Map{"error": "Only advisors can review files"})
+	}
+
+	var input struct {
+		Decision string `json:"decision"` // "approved", "rejected" or "revision_requested"
+		Status   string `json:"status"`   // older clients send the decision as status
+		Comments string `json:"comments"`
+	}
+
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid input"})
+	}
+	if input.Decision == "" {
+		input.Decision = input.Status
+	}
+
+	if !models.IsReviewDecision(input.Decision) {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid decision. Use 'approved', 'rejected' or 'revision_requested'"})
+	}
+
+	projectFile, err := findAccessibleFile(h.DB, c, c.Params("id"))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "File not found or access denied"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch file"})
+	}
+
+	review := models.FileReview{
+		FileID:     projectFile.ID,
+		DocumentID: projectFile.DocumentID,
+		ReviewerID: userID,
+		Decision:   input.Decision,
+		Comments:   strings.TrimSpace(input.Comments),
+	}
+
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		// Lock the document so concurrent reviews get consecutive rounds
+		var versions []models.ProjectFile
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("document_id = ?", projectFile.DocumentID).
+			Find(&versions).Error; err != nil {
+			return err
+		}
+
+		var lastRound int
+		if err := tx.Model(&models.FileReview{}).
+			Where("document_id = ?", projectFile.DocumentID).
+			Select("COALESCE(MAX(round), 0)").
+			Scan(&lastRound).Error; err != nil {
+			return err
+		}
+		review.Round = lastRound + 1
+
+		if err := tx.Create(&review).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&models.ProjectFile{}).
+			Where("id = ?", projectFile.ID).
+			Update("file_status", input.Decision).Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to save review",
+			"details": err.Error(),
+		})
+	}
+	h.Events.Publish(events.FileReviewed{
+		FileID:     projectFile.ID,
+		ProjectID:  projectFile.ProjectID,
+		ReviewerID: userID,
+		UploaderID: projectFile.UploadedBy,
+		FileName:   projectFile.FileName,
+		Version:    projectFile.Version,
+		Decision:   review.Decision,
+		Comments:   review.Comments,
+	})
+
+	return c.JSON(fiber.Map{
+		"message": "File status updated successfully",
+		"review":  review,
+	})
+}
+
+// GetFileReviews - GET /api/files/:id/reviews
+// Returns every review round of the document, across all of its versions
+func (h *FileHandler) GetFileReviews(c *fiber.Ctx) error {
+	projectFile, err := findAccessibleFile(h.DB, c, c.Params("id"))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "File not found or access denied"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch file"})
+	}
+
+	var reviews []models.FileReview
+	if err := h.DB.Preload("Reviewer").
+		Preload("File", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id", "document_id", "version", "file_name")
+		}).
+		Where("document_id = ?", projectFile.DocumentID).
+		Order("round DESC").
+		Find(&reviews).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch reviews"})
+	}
+
+	return c.JSON(fiber.Map{
+		"document_id": projectFile.DocumentID,
+		"file_status": projectFile.FileStatus,
+		"reviews":     reviews,
+	})
+}
+
+// GetFileComments - GET /api/files/:id/comments
+// Returns the comment threads of one file version; ?page=3 limits them to annotations on that page
+func (h *FileHandler) GetFileComments(c *fiber.Ctx) error {
+	projectFile, err := findAccessibleFile(h.DB, c, c.Params("id"))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "File not found or access denied"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch file"})
+	}
+
+	query := h.DB.Preload("Author").
+		Preload("Replies", func(db *gorm.DB) *gorm.DB {
+			return db.Order("created_at ASC")
+		}).
+		Preload("Replies.Author").
+		Where("file_id = ? AND parent_id IS NULL", projectFile.ID)
+
+	if page := c.QueryInt("page", 0); page > 0 {
+		query = query.Where("page = ?", page)
+	}
+	if c.Query("resolved") != "" {
+		query = query.Where("resolved = ?", c.QueryBool("resolved"))
+	}
+
+	var threads []models.FileReviewComment
+	if err := query.Order("page ASC NULLS FIRST, created_at ASC").Find(&threads).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch comments"})
+	}
+
+	return c.JSON(fiber.Map{
+		"file_id":  projectFile.ID,
+		"comments": threads,
+	})
+}
+
+// AddFileComment - POST /api/files/:id/comments
+// Starts a thread (optionally a PDF annotation) or replies to one via parent_id
+func (h *FileHandler) AddFileComment(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	var input struct {
+		Body     string  `json:"body"`
+		ParentID *string `json:"parent_id"`
+		ReviewID *string `json:"review_id"`
+		Page     *int    `json:"page"`
+		Anchor   *struct {
+			X      float64 `json:"x"`
+			Y      float64 `json:"y"`
+			Width  float64 `json:"width"`
+			Height float64 `json:"height"`
+		} `json:"anchor"`
+	}
+
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid input"})
+	}
+	input.Body = strings.TrimSpace(input.Body)
+	if input.Body == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Comment body is required"})
+	}
+
+	projectFile, err := findAccessibleFile(h.DB, c, c.Params("id"))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "File not found or access denied"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch file"})
+	}
+
+	comment := models.FileReviewComment{
+		FileID:   projectFile.ID,
+		AuthorID: userID,
+		Body:     input.Body,
+	}
+
+	if input.ParentID != nil && *input.ParentID != "" {
+		if input.Page != nil || input.Anchor != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Replies cannot have their own page or anchor"})
+		}
+
+		var parent models.FileReviewComment
+		if !isUUID(*input.ParentID) {
+			return c.Status(404).JSON(fiber.Map{"error": "Parent comment not found"})
+		}
+		if err := h.DB.Where("id = ? AND file_id = ?", *input.ParentID, projectFile.ID).First(&parent).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return c.Status(404).JSON(fiber.Map{"error": "Parent comment not found"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch comment"})
+		}
+
+		// Threads are one level deep: replying to a reply attaches to its thread
+		threadID := parent.ID
+		if parent.ParentID != nil {
+			threadID = *parent.ParentID
+		}
+		comment.ParentID = &threadID
+	} else {
+		if input.ReviewID != nil && *input.ReviewID != "" {
+			var count int64
+			h.DB.Model(&models.FileReview{}).
+				Where("id = ? AND document_id = ?", *input.ReviewID, projectFile.DocumentID).
+				Count(&count)
+			if count == 0 {
+				return c.Status(404).JSON(fiber.Map{"error": "Review not found"})
+			}
+			comment.ReviewID = input.ReviewID
+		}
+
+		if input.Page != nil || input.Anchor != nil {
+			if strings.ToLower(filepath.Ext(projectFile.FileName)) != ".pdf" {
+				return c.Status(400).JSON(fiber.Map{"error": "Page annotations are only supported on PDF files"})
+			}
+			if input.Page == nil || *input.Page < 1 {
+				return c.Status(400).JSON(fiber.Map{"error": "page must be 1 or greater"})
+			}
+			comment.Page = input.Page
+		}
+
+		if a := input.Anchor; a != nil {
+			if a.X < 0 || a.Y < 0 || a.Width < 0 || a.Height < 0 || a.X+a.Width > 1 || a.Y+a.Height > 1 {
+				return c.Status(400).JSON(fiber.Map{"error": "anchor must be given as fractions of the page (0-1) and stay within it"})
+			}
+			comment.AnchorX = &a.X
+			comment.AnchorY = &a.Y
+			comment.AnchorWidth = &a.Width
+			comment.AnchorHeight = &a.Height
+		}
+	}
+
+	if err := h.DB.Create(&comment).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to save comment",
+			"details": err.Error(),
+		})
+	}
+
+	h.DB.Preload("Author").First(&comment, "id = ?", comment.ID)
+	return c.Status(201).JSON(comment)
+}
+
+// UpdateFileComment - PATCH /api/files/:id/comments/:commentId
+// Authors may edit their comment; the author of the thread, advisors and admins may resolve it
+func (h *FileHandler) UpdateFileComment(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	userRole := c.Locals("user_role")
+
+	var input struct {
+		Body     *string `json:"body"`
+		Resolved *bool   `json:"resolved"`
+	}
+
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid input"})
+	}
+
+	projectFile, err := findAccessibleFile(h.DB, c, c.Params("id"))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "File not found or access denied"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch file"})
+	}
+
+	commentID := c.Params("commentId")
+	if !isUUID(commentID) {
+		return c.Status(404).JSON(fiber.Map{"error": "Comment not found"})
+	}
+	var comment models.FileReviewComment
+	if err := h.DB.Where("id = ? AND file_id = ?", commentID, projectFile.ID).First(&comment).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Comment not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch comment"})
+	}
+
+	updates := map[string]interface{}{}
+
+	if input.Body != nil {
+		if comment.AuthorID != userID {
+			return c.Status(403).JSON(fiber.Map{"error": "Only the author can edit this comment"})
+		}
+		body := strings.TrimSpace(*input.Body)
+		if body == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "Comment body is required"})
+		}
+		updates["body"] = body
+	}
+
+	if input.Resolved != nil {
+		if comment.ParentID != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Only a thread's first comment can be resolved"})
+		}
+		if comment.AuthorID != userID && userRole != "advisor" && userRole != "admin" {
+			return c.Status(403).JSON(fiber.Map{"error": "Not allowed to resolve this comment"})
+		}
+		updates["resolved"] = *input.Resolved
+	}
+
+	if len(updates) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Nothing to update"})
+	}
+
+	if err := h.DB.Model(&comment).Updates(updates).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update comment"})
+	}
+
+	h.DB.Preload("Author").First(&comment, "id = ?", comment.ID)
+	return c.JSON(comment)
+}
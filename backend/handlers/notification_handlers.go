@@ -2,39 +2,114 @@ package handlers
 
 import (
 	"backend/models"
-	"strconv"
+	"backend/notify"
+	"backend/realtime"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type NotificationHandler struct {
-	DB *gorm.DB
+	DB       *gorm.DB
+	Hub      *realtime.Hub
+	Notifier *notify.Service
 }
 
-func NewNotificationHandler(db *gorm.DB) *NotificationHandler {
+func NewNotificationHandler(db *gorm.DB, hub *realtime.Hub, notifier *notify.Service) *NotificationHandler {
 	return &NotificationHandler{
-		DB: db,
+		DB:       db,
+		Hub:      hub,
+		Notifier: notifier,
 	}
 }
 
-// GetNotifications - GET /api/notifications
+// HandleWebSocket - GET /ws/notifications?token=<jwt>
+// Pushes new notifications and the unread count to the signed-in user
+func (h *NotificationHandler) HandleWebSocket(c *websocket.Conn) {
+	userID, _ := c.Locals("user_id").(string)
+	if userID == "" {
+		c.Close()
+		return
+	}
+
+	// Written before registering so only the hub writes to the connection afterwards
+	if err := c.WriteJSON(notify.Event{Type: "connected", UnreadCount: h.Notifier.UnreadCount(userID)}); err != nil {
+		c.Close()
+		return
+	}
+
+	room := realtime.UserRoom(userID)
+	client := h.Hub.Register(room, c)
+	defer h.Hub.Unregister(client)
+
+	// Nothing is expected from the client; reading processes pongs and detects when it goes away
+	for {
+		if _, _, err := c.ReadMessage(); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("Notification socket of %s closed: %v", userID, err)
+			}
+			return
+		}
+	}
+}
+
+// notificationCursor points after the last notification of a page; lists are ordered by created_at, then id
+type notificationCursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        string    `json:"id"`
+}
+
+func encodeNotificationCursor(n models.Notification) string {
+	b, _ := json.Marshal(notificationCursor{CreatedAt: n.CreatedAt, ID: n.ID})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeNotificationCursor(v string) (*notificationCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return nil, err
+	}
+	var cur notificationCursor
+	if err := json.Unmarshal(b, &cur); err != nil {
+		return nil, err
+	}
+	if _, err := uuid.Parse(cur.ID); err != nil {
+		return nil, err
+	}
+	return &cur, nil
+}
+
+// ownNotifications scopes a query to the visible notifications of the current user
+func (h *NotificationHandler) ownNotifications(c *fiber.Ctx) *gorm.DB {
+	userID, _ := c.Locals("user_id").(string)
+	return h.DB.Model(&models.Notification{}).Where("user_id = ? AND hidden = ?", userID, false)
+}
+
+// GetNotifications - GET /api/notifications?limit=20&cursor=&is_read=false&type=&event_type=&archived=false
+// Returns the current user's notifications, newest first; the X-Next-Cursor header holds the cursor of the next page
 func (h *NotificationHandler) GetNotifications(c *fiber.Ctx) error {
-	// TODO: Get user ID from JWT token
-	userId := c.Query("user_id") // Temporary: get from query param
-	if userId == "" {
-		// Use the actual admin user UUID from database
-		userId = "41b805d8-f8c0-4af6-974b-84f03aeafdb7"
+	limit := c.QueryInt("limit", 50)
+	if limit < 1 || limit > 100 {
+		limit = 50
 	}
 
-	var notifications []models.Notification
-	query := h.DB.Where("user_id = ?", userId).Order("created_at DESC")
+	query := h.ownNotifications(c).Order("created_at DESC, id DESC").Limit(limit + 1)
 
-	// Limit results
-	if limit := c.Query("limit"); limit != "" {
-		if l, err := strconv.Atoi(limit); err == nil && l > 0 {
-			query = query.Limit(l)
+	if cursor := c.Query("cursor"); cursor != "" {
+		cur, err := decodeNotificationCursor(cursor)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid cursor"})
 		}
+		query = query.Where("(created_at, id) < (?, ?)", cur.CreatedAt, cur.ID)
 	}
 
 	// Filter by read status
@@ -46,6 +121,19 @@ func (h *NotificationHandler) GetNotifications(c *fiber.Ctx) error {
 		}
 	}
 
+	if c.Query("archived") == "true" {
+		query = query.Where("archived_at IS NOT NULL")
+	} else {
+		query = query.Where("archived_at IS NULL")
+	}
+	if kind := c.Query("type"); kind != "" {
+		query = query.Where("type = ?", kind)
+	}
+	if eventType := c.Query("event_type"); eventType != "" {
+		query = query.Where("event_type = ?", eventType)
+	}
+
+	notifications := []models.Notification{}
 	if err := query.Find(&notifications).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"error":   "Failed to fetch notifications",
@@ -53,35 +141,352 @@ func (h *NotificationHandler) GetNotifications(c *fiber.Ctx) error {
 		})
 	}
 
-	// If no notifications found, return empty array instead of null
-	if notifications == nil {
-		notifications = []models.Notification{}
+	if len(notifications) > limit {
+		notifications = notifications[:limit]
+		c.Set("X-Next-Cursor", encodeNotificationCursor(notifications[limit-1]))
 	}
 
 	return c.JSON(notifications)
 }
 
+// GetCounts - GET /api/notifications/counts
+// Unread notifications of the current user in total, per type and per event type; archived ones are left out
+func (h *NotificationHandler) GetCounts(c *fiber.Ctx) error {
+	var rows []struct {
+		Type      string
+		EventType string
+		Count     int64
+	}
+	if err := h.ownNotifications(c).
+		Select("type, event_type, COUNT(*) AS count").
+		Where("is_read = ? AND archived_at IS NULL", false).
+		Group("type, event_type").
+		Scan(&rows).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to count notifications"})
+	}
+
+	var total int64
+	byType := map[string]int64{}
+	byEventType := map[string]int64{}
+	for _, row := range rows {
+		total += row.Count
+		byType[row.Type] += row.Count
+		byEventType[row.EventType] += row.Count
+	}
+
+	return c.JSON(fiber.Map{
+		"unread":        total,
+		"by_type":       byType,
+		"by_event_type": byEventType,
+	})
+}
+
+// notificationActions are the changes of the bulk and single-notification endpoints
+var notificationActions = map[string]func(q *gorm.DB) *gorm.DB{
+	"read": func(q *gorm.DB) *gorm.DB {
+		return q.Where("is_read = ?", false).Updates(map[string]interface{}{"is_read": true, "read_at": time.Now()})
+	},
+	"unread": func(q *gorm.DB) *gorm.DB {
+		return q.Where("is_read = ?", true).Updates(map[string]interface{}{"is_read": false, "read_at": nil})
+	},
+	"archive": func(q *gorm.DB) *gorm.DB {
+		return q.Where("archived_at IS NULL").Update("archived_at", time.Now())
+	},
+	"unarchive": func(q *gorm.DB) *gorm.DB {
+		return q.Where("archived_at IS NOT NULL").Update("archived_at", nil)
+	},
+	"delete": func(q *gorm.DB) *gorm.DB {
+		return q.Delete(&models.Notification{})
+	},
+}
+
+// applyToOne runs an action on one notification of the current user and pushes the new unread count
+func (h *NotificationHandler) applyToOne(c *fiber.Ctx, action, message string) error {
+	id := c.Params("id")
+	if _, err := uuid.Parse(id); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid notification id"})
+	}
+
+	var found int64
+	if err := h.ownNotifications(c).Where("id = ?", id).Count(&found).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch notification"})
+	}
+	if found == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Notification not found"})
+	}
+
+	if err := notificationActions[action](h.ownNotifications(c).Where("id = ?", id)).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to update notification",
+			"details": err.Error(),
+		})
+	}
+
+	userID, _ := c.Locals("user_id").(string)
+	h.Notifier.PushUnreadCount(userID)
+	return c.JSON(fiber.Map{"message": message})
+}
+
 // MarkAsRead - PATCH /api/notifications/:id/read
 func (h *NotificationHandler) MarkAsRead(c *fiber.Ctx) error {
-	notificationId := c.Params("id")
+	return h.applyToOne(c, "read", "Notification marked as read")
+}
 
-	result := h.DB.Model(&models.Notification{}).
-		Where("id = ?", notificationId).
-		Updates(map[string]interface{}{
-			"is_read": true,
-			"read_at": "NOW()",
-		})
+// MarkAsUnread - PATCH /api/notifications/:id/unread
+func (h *NotificationHandler) MarkAsUnread(c *fiber.Ctx) error {
+	return h.applyToOne(c, "unread", "Notification marked as unread")
+}
+
+// Archive - PATCH /api/notifications/:id/archive
+func (h *NotificationHandler) Archive(c *fiber.Ctx) error {
+	return h.applyToOne(c, "archive", "Notification archived")
+}
+
+// Unarchive - PATCH /api/notifications/:id/unarchive
+func (h *NotificationHandler) Unarchive(c *fiber.Ctx) error {
+	return h.applyToOne(c, "unarchive", "Notification unarchived")
+}
+
+// DeleteNotification - DELETE /api/notifications/:id
+func (h *NotificationHandler) DeleteNotification(c *fiber.Ctx) error {
+	return h.applyToOne(c, "delete", "Notification deleted")
+}
 
+// MarkAllAsRead - PATCH /api/notifications/read-all
+// Marks every unread, unarchived notification of the current user read
+func (h *NotificationHandler) MarkAllAsRead(c *fiber.Ctx) error {
+	result := notificationActions["read"](h.ownNotifications(c).Where("archived_at IS NULL"))
 	if result.Error != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"error":   "Failed to mark notification as read",
-			"details": result.Error.Error(),
-		})
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to mark notifications as read"})
 	}
 
-	if result.RowsAffected == 0 {
-		return c.Status(404).JSON(fiber.Map{"error": "Notification not found"})
+	userID, _ := c.Locals("user_id").(string)
+	h.Notifier.PushUnreadCount(userID)
+	return c.JSON(fiber.Map{"message": "All notifications marked as read", "updated": result.RowsAffected})
+}
+
+// BulkUpdate - POST /api/notifications/bulk {"ids": [...], "action": "read|unread|archive|unarchive|delete"}
+// IDs of other users' notifications are ignored; "updated" counts the notifications that changed
+func (h *NotificationHandler) BulkUpdate(c *fiber.Ctx) error {
+	var input struct {
+		IDs    []string `json:"ids"`
+		Action string   `json:"action"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
 	}
 
-	return c.JSON(fiber.Map{"message": "Notification marked as read"})
+	apply, ok := notificationActions[input.Action]
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "action must be read, unread, archive, unarchive or delete"})
+	}
+	if len(input.IDs) == 0 || len(input.IDs) > 500 {
+		return c.Status(400).JSON(fiber.Map{"error": "ids must list between 1 and 500 notifications"})
+	}
+	for _, id := range input.IDs {
+		if _, err := uuid.Parse(id); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid notification id: " + id})
+		}
+	}
+
+	result := apply(h.ownNotifications(c).Where("id IN ?", input.IDs))
+	if result.Error != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update notifications"})
+	}
+
+	userID, _ := c.Locals("user_id").(string)
+	h.Notifier.PushUnreadCount(userID)
+	return c.JSON(fiber.Map{"action": input.Action, "updated": result.RowsAffected})
+}
+
+// GetDeliveries - GET /api/admin/notifications/deliveries?status=failed&channel=email&limit=50
+// Lists email deliveries of notifications, newest first, to find what failed and why
+func (h *NotificationHandler) GetDeliveries(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 50)
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	query := h.DB.Preload("Notification").Order("created_at DESC").Limit(limit)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if channel := c.Query("channel"); channel != "" {
+		query = query.Where("channel = ?", channel)
+	}
+
+	deliveries := []models.NotificationDelivery{}
+	if err := query.Find(&deliveries).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch deliveries"})
+	}
+
+	var counts []struct {
+		Status string `json:"status"`
+		Count  int64  `json:"count"`
+	}
+	if err := h.DB.Model(&models.NotificationDelivery{}).
+		Select("status, COUNT(*) AS count").
+		Group("status").
+		Scan(&counts).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch deliveries"})
+	}
+
+	return c.JSON(fiber.Map{
+		"deliveries": deliveries,
+		"counts":     counts,
+	})
+}
+
+// preferencesResponse lists the channels of every event type, including the defaulted ones
+func preferencesResponse(prefs *models.NotificationPreference) fiber.Map {
+	channels := make(map[string]models.ChannelSet, len(notify.EventTypes))
+	for _, t := range notify.EventTypes {
+		channels[t] = prefs.ChannelsFor(t)
+	}
+	muted := prefs.MutedProjectIDs
+	if muted == nil {
+		muted = pq.StringArray{}
+	}
+	return fiber.Map{
+		"channels":          channels,
+		"quiet_hours_start": prefs.QuietHoursStart,
+		"quiet_hours_end":   prefs.QuietHoursEnd,
+		"digest_frequency":  prefs.DigestFrequency,
+		"muted_project_ids": muted,
+		"event_types":       notify.EventTypes,
+	}
+}
+
+// GetPreferences - GET /api/notifications/preferences
+func (h *NotificationHandler) GetPreferences(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	prefs, err := h.Notifier.Preferences(userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch notification preferences"})
+	}
+	return c.JSON(preferencesResponse(prefs))
+}
+
+// UpdatePreferences - PUT /api/notifications/preferences
+// Fields left out keep their value; channels of an event type are merged, e.g. {"channels": {"chat.message_received": {"email": false}}}
+func (h *NotificationHandler) UpdatePreferences(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	var input struct {
+		Channels map[string]struct {
+			InApp *bool `json:"in_app"`
+			Email *bool `json:"email"`
+			Push  *bool `json:"push"`
+		} `json:"channels"`
+		QuietHoursStart *string   `json:"quiet_hours_start"`
+		QuietHoursEnd   *string   `json:"quiet_hours_end"`
+		DigestFrequency *string   `json:"digest_frequency"`
+		MutedProjectIDs *[]string `json:"muted_project_ids"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	prefs, err := h.Notifier.Preferences(userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch notification preferences"})
+	}
+
+	for eventType, change := range input.Channels {
+		if !notify.IsEventType(eventType) {
+			return c.Status(400).JSON(fiber.Map{"error": "Unknown event type: " + eventType, "event_types": notify.EventTypes})
+		}
+		set := prefs.ChannelsFor(eventType)
+		if change.InApp != nil {
+			set.InApp = *change.InApp
+		}
+		if change.Email != nil {
+			set.Email = *change.Email
+		}
+		if change.Push != nil {
+			set.Push = *change.Push
+		}
+		prefs.Channels[eventType] = set
+	}
+
+	if input.QuietHoursStart != nil {
+		prefs.QuietHoursStart = strings.TrimSpace(*input.QuietHoursStart)
+	}
+	if input.QuietHoursEnd != nil {
+		prefs.QuietHoursEnd = strings.TrimSpace(*input.QuietHoursEnd)
+	}
+	if (prefs.QuietHoursStart == "") != (prefs.QuietHoursEnd == "") {
+		return c.Status(400).JSON(fiber.Map{"error": "Set both quiet_hours_start and quiet_hours_end, or clear both"})
+	}
+	for _, v := range []string{prefs.QuietHoursStart, prefs.QuietHoursEnd} {
+		if _, err := notify.ParseClock(v); v != "" && err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Quiet hours must be HH:MM, e.g. 22:00"})
+		}
+	}
+
+	if input.DigestFrequency != nil {
+		switch *input.DigestFrequency {
+		case models.DigestImmediate, models.DigestDaily, models.DigestWeekly:
+			prefs.DigestFrequency = *input.DigestFrequency
+		default:
+			return c.Status(400).JSON(fiber.Map{"error": "digest_frequency must be immediate, daily or weekly"})
+		}
+	}
+
+	if input.MutedProjectIDs != nil {
+		muted := pq.StringArray{}
+		for _, id := range *input.MutedProjectIDs {
+			if _, err := uuid.Parse(id); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "Invalid project id: " + id})
+			}
+			muted = append(muted, id)
+		}
+		prefs.MutedProjectIDs = muted
+	}
+
+	if err := h.DB.Save(prefs).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save notification preferences"})
+	}
+	return c.JSON(preferencesResponse(prefs))
+}
+
+// MuteProject - POST /api/projects/:id/mute
+// Stops every notification about the project for the current user
+func (h *NotificationHandler) MuteProject(c *fiber.Ctx) error {
+	project, err := findAccessibleProject(h.DB, c, c.Params("id"))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Project not found or access denied"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch project"})
+	}
+	userID, _ := c.Locals("user_id").(string)
+
+	prefs := models.DefaultNotificationPreference(userID)
+	prefs.MutedProjectIDs = pq.StringArray{project.ID}
+	if err := h.DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"muted_project_ids": gorm.Expr("array_append(array_remove(notification_preferences.muted_project_ids, ?::uuid), ?::uuid)", project.ID, project.ID),
+		}),
+	}).Create(prefs).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to mute project"})
+	}
+	return c.JSON(fiber.Map{"message": "Project muted", "project_id": project.ID})
+}
+
+// UnmuteProject - DELETE /api/projects/:id/mute
+func (h *NotificationHandler) UnmuteProject(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	projectID := c.Params("id")
+	if _, err := uuid.Parse(projectID); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid project id"})
+	}
+
+	if err := h.DB.Model(&models.NotificationPreference{}).
+		Where("user_id = ?", userID).
+		Update("muted_project_ids", gorm.Expr("array_remove(muted_project_ids, ?::uuid)", projectID)).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to unmute project"})
+	}
+	return c.JSON(fiber.Map{"message": "Project unmuted", "project_id": projectID})
 }
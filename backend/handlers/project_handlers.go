@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"backend/models"
+	"backend/settings"
 	"log"
 	"strconv"
 
@@ -10,12 +11,14 @@ import (
 )
 
 type ProjectHandler struct {
-	DB *gorm.DB
+	DB       *gorm.DB
+	Settings *settings.Service
 }
 
-func NewProjectHandler(db *gorm.DB) *ProjectHandler {
+func NewProjectHandler(db *gorm.DB, settingsService *settings.Service) *ProjectHandler {
 	return &ProjectHandler{
-		DB: db,
+		DB:       db,
+		Settings: settingsService,
 	}
 }
 
@@ -129,6 +132,11 @@ func (h *ProjectHandler) GetProjectFiles(c *fiber.Ctx) error {
 		query = query.Where("is_public = ?", true)
 	}
 
+	// Only the newest version of each document
+	if c.Query("latest") == "true" {
+		query = query.Where(models.LatestVersionCondition)
+	}
+
 	if err := query.Order("created_at DESC").Find(&files).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"error":   "Failed to fetch files",
@@ -206,6 +214,12 @@ func (h *ProjectHandler) CreateProject(c *fiber.Ctx) error {
 		project.AdvisorID = &input.AdvisorID
 	}
 
+	// Register the project in the current term
+	if year, semester := h.Settings.CurrentTerm(); year > 0 {
+		project.AcademicYear = &year
+		project.Semester = &semester
+	}
+
 	if err := h.DB.Create(&project).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error":   "Failed to create project",
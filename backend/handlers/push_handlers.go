@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/webpush"
+	"encoding/json"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type PushHandler struct {
+	DB        *gorm.DB
+	Sender    *webpush.Sender // nil when VAPID keys are not configured
+	AllowHTTP bool            // accept http endpoints, e.g. of the fake push service
+}
+
+func NewPushHandler(db *gorm.DB, sender *webpush.Sender, allowHTTP bool) *PushHandler {
+	return &PushHandler{
+		DB:        db,
+		Sender:    sender,
+		AllowHTTP: allowHTTP,
+	}
+}
+
+// GetPublicKey - GET /api/push/public-key
+// The applicationServerKey to pass to PushManager.subscribe()
+func (h *PushHandler) GetPublicKey(c *fiber.Ctx) error {
+	if h.Sender == nil {
+		return c.JSON(fiber.Map{"enabled": false})
+	}
+	return c.JSON(fiber.Map{
+		"enabled":    true,
+		"public_key": h.Sender.VAPID.PublicKey,
+	})
+}
+
+// Subscribe - POST /api/push/subscriptions
+// Body is PushSubscription.toJSON(); a browser already subscribed, e.g. by another user, moves to the current user
+func (h *PushHandler) Subscribe(c *fiber.Ctx) error {
+	if h.Sender == nil {
+		return c.Status(503).JSON(fiber.Map{"error": "Push notifications are not configured"})
+	}
+
+	var input struct {
+		webpush.Subscription
+		ExpirationTime *int64 `json:"expirationTime"` // milliseconds since the epoch
+	}
+	if err := json.Unmarshal(c.Body(), &input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if err := input.Validate(h.AllowHTTP); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	userID, _ := c.Locals("user_id").(string)
+	sub := models.PushSubscription{
+		UserID:    userID,
+		Endpoint:  input.Endpoint,
+		P256dh:    input.Keys.P256dh,
+		Auth:      input.Keys.Auth,
+		UserAgent: c.Get("User-Agent"),
+	}
+	if input.ExpirationTime != nil {
+		expires := time.UnixMilli(*input.ExpirationTime)
+		sub.ExpiresAt = &expires
+	}
+
+	if err := h.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "endpoint"}},
+		DoUpdates: clause.AssignmentColumns([]string{"user_id", "p256dh", "auth", "user_agent", "expires_at"}),
+	}).Create(&sub).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save push subscription"})
+	}
+
+	return c.Status(201).JSON(sub)
+}
+
+// GetSubscriptions - GET /api/push/subscriptions
+func (h *PushHandler) GetSubscriptions(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	subs := []models.PushSubscription{}
+	if err := h.DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&subs).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch push subscriptions"})
+	}
+	return c.JSON(subs)
+}
+
+// Unsubscribe - DELETE /api/push/subscriptions {"endpoint": "..."}
+// Call it after PushSubscription.unsubscribe(), e.g. when the user turns push off or signs out
+func (h *PushHandler) Unsubscribe(c *fiber.Ctx) error {
+	var input struct {
+		Endpoint string `json:"endpoint"`
+	}
+	if err := c.BodyParser(&input); err != nil || input.Endpoint == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "endpoint is required"})
+	}
+
+	userID, _ := c.Locals("user_id").(string)
+	result := h.DB.Where("user_id = ? AND endpoint = ?", userID, input.Endpoint).Delete(&models.PushSubscription{})
+	if result.Error != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete push subscription"})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Push subscription not found"})
+	}
+	return c.JSON(fiber.Map{"message": "Push subscription deleted"})
+}
+
+// SendTest - POST /api/push/test
+// Pushes a test message to every subscription of the current user right away, ignoring quiet hours
+func (h *PushHandler) SendTest(c *fiber.Ctx) error {
+	if h.Sender == nil {
+		return c.Status(503).JSON(fiber.Map{"error": "Push notifications are not configured"})
+	}
+
+	userID, _ := c.Locals("user_id").(string)
+	var subs []models.PushSubscription
+	if err := h.DB.Where("user_id = ?", userID).Find(&subs).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch push subscriptions"})
+	}
+	if len(subs) == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "No push subscriptions"})
+	}
+
+	payload, _ := json.Marshal(fiber.Map{
+		"title": "Project 4101",
+		"body":  "Push notifications are working",
+		"tag":   "test",
+	})
+	results := make([]fiber.Map, 0, len(subs))
+	for _, sub := range subs {
+		err := h.Sender.Send(c.Context(), webpush.Subscription{
+			Endpoint: sub.Endpoint,
+			Keys:     webpush.Keys{P256dh: sub.P256dh, Auth: sub.Auth},
+		}, webpush.Message{Payload: payload, TTL: time.Minute, Urgency: webpush.UrgencyHigh})
+
+		result := fiber.Map{"id": sub.ID, "sent": err == nil}
+		if err != nil {
+			result["error"] = err.Error()
+			if webpush.IsGone(err) {
+				h.DB.Delete(&sub)
+				result["deleted"] = true
+			}
+		}
+		results = append(results, result)
+	}
+	return c.JSON(fiber.Map{"results": results})
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"backend/settings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type SettingsHandler struct {
+	Settings *settings.Service
+}
+
+func NewSettingsHandler(settingsService *settings.Service) *SettingsHandler {
+	return &SettingsHandler{Settings: settingsService}
+}
+
+// GetSettings - GET /api/admin/settings
+func (h *SettingsHandler) GetSettings(c *fiber.Ctx) error {
+	list, err := h.Settings.List()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch settings",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"settings":       list,
+		"body_limit_mb":  h.Settings.BodyLimit >> 20,
+		"cache_ttl_secs": int(h.Settings.TTL.Seconds()),
+	})
+}
+
+// UpdateSetting - PUT /api/admin/settings/:key
+// Changes take effect without a restart (within the cache TTL on other replicas)
+func (h *SettingsHandler) UpdateSetting(c *fiber.Ctx) error {
+	var input struct {
+		Value       string `json:"value"`
+		Description string `json:"description"`
+	}
+
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	setting, err := h.Settings.Set(c.Params("key"), input.Value, input.Description)
+	if err != nil {
+		if validationErr, ok := err.(*settings.ValidationError); ok {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": validationErr.Message,
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update setting",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Setting updated successfully",
+		"setting": setting,
+	})
+}
+
+// GetUploadPolicy - GET /api/upload-policy?category=source_code
+func (h *SettingsHandler) GetUploadPolicy(c *fiber.Ctx) error {
+	return c.JSON(h.Settings.UploadPolicy(c.Query("category", "other")))
+}
@@ -0,0 +1,424 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/notify"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// ShowcaseHandler publishes completed projects to the public archive.
+// A project appears there only after the student consents and the advisor approves.
+type ShowcaseHandler struct {
+	DB    *gorm.DB
+	Files *FileHandler
+}
+
+func NewShowcaseHandler(db *gorm.DB, files *FileHandler) *ShowcaseHandler {
+	return &ShowcaseHandler{
+		DB:    db,
+		Files: files,
+	}
+}
+
+// UpdatePublication - PUT /api/projects/:id/publication
+// The student consents to publishing (abstract and the files to show) or withdraws consent
+func (h *ShowcaseHandler) UpdatePublication(c *fiber.Ctx) error {
+	if c.Locals("user_role") != "student" {
+		return c.Status(403).JSON(fiber.Map{"error": "Only the project's student can give publication consent"})
+	}
+
+	var input struct {
+		Consent  bool     `json:"consent"`
+		Abstract string   `json:"abstract"`
+		FileIDs  []string `json:"file_ids"`
+	}
+
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid input"})
+	}
+
+	project, err := findAccessibleProject(h.DB, c, c.Params("id"))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Project not found or access denied"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch project"})
+	}
+
+	if !input.Consent {
+		err := h.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&models.ProjectFile{}).Where("project_id = ?", project.ID).Update("is_public", false).Error; err != nil {
+				return err
+			}
+			return tx.Model(project).Updates(map[string]interface{}{
+				"publish_status":     models.PublishPrivate,
+				"publish_consent_at": nil,
+				"published_at":       nil,
+				"published_by":       nil,
+			}).Error
+		})
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to withdraw consent"})
+		}
+		return c.JSON(fiber.Map{"message": "Consent withdrawn; the project is no longer public"})
+	}
+
+	if project.Status != "completed" {
+		return c.Status(400).JSON(fiber.Map{"error": "Only completed projects can be published"})
+	}
+
+	abstract := strings.TrimSpace(input.Abstract)
+	if abstract == "" {
+		abstract = project.Abstract
+	}
+	if abstract == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "An abstract is required"})
+	}
+
+	if len(input.FileIDs) > 0 {
+		var count int64
+		h.DB.Model(&models.ProjectFile{}).
+			Where("id IN ? AND project_id = ? AND scan_status <> ?", input.FileIDs, project.ID, "infected").
+			Count(&count)
+		if int(count) != len(input.FileIDs) {
+			return c.Status(400).JSON(fiber.Map{"error": "file_ids must be files of this project"})
+		}
+	}
+
+	now := time.Now()
+	var notification *models.Notification
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.ProjectFile{}).Where("project_id = ?", project.ID).Update("is_public", false).Error; err != nil {
+			return err
+		}
+		if len(input.FileIDs) > 0 {
+			if err := tx.Model(&models.ProjectFile{}).Where("id IN ?", input.FileIDs).Update("is_public", true).Error; err != nil {
+				return err
+			}
+		}
+
+		// Changing what is shown needs the advisor's approval again
+		if err := tx.Model(project).Updates(map[string]interface{}{
+			"abstract":           abstract,
+			"publish_status":     models.PublishPending,
+			"publish_consent_at": &now,
+			"published_at":       nil,
+			"published_by":       nil,
+		}).Error; err != nil {
+			return err
+		}
+
+		if project.AdvisorID == nil {
+			return nil
+		}
+		var advisor models.Advisor
+		if err := tx.First(&advisor, "id = ?", *project.AdvisorID).Error; err != nil {
+			return nil
+		}
+		notification = &models.Notification{
+			UserID:           advisor.UserID,
+			Title:            "Publication request",
+			Message:          fmt.Sprintf("The student of %s asked to publish it in the project showcase", project.Title),
+			Type:             "info",
+			RelatedProjectID: &project.ID,
+			EventType:        notify.TypePublication,
+		}
+		_, err := h.Files.Notifier.Create(tx, notification)
+		return err
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save consent"})
+	}
+	h.Files.Notifier.Push(notification)
+
+	return c.JSON(fiber.Map{
+		"message":        "Consent recorded; waiting for the advisor's approval",
+		"publish_status": models.PublishPending,
+	})
+}
+
+// PublishProject - POST /api/advisors/projects/:id/publish
+func (h *ShowcaseHandler) PublishProject(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	project, err := findAccessibleProject(h.DB, c, c.Params("id"))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Project not found or access denied"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch project"})
+	}
+
+	if project.Status != "completed" {
+		return c.Status(400).JSON(fiber.Map{"error": "Only completed projects can be published"})
+	}
+	if project.PublishStatus != models.PublishPending {
+		return c.Status(409).JSON(fiber.Map{"error": "The student has not consented to publishing this project"})
+	}
+
+	now := time.Now()
+	var notification *models.Notification
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(project).Updates(map[string]interface{}{
+			"publish_status": models.PublishPublished,
+			"published_at":   &now,
+			"published_by":   userID,
+		}).Error; err != nil {
+			return err
+		}
+		notification, err = h.notifyStudent(tx, project, "Project published", fmt.Sprintf("%s is now in the public project showcase", project.Title), "success")
+		return err
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to publish project"})
+	}
+	h.Files.Notifier.Push(notification)
+
+	return c.JSON(fiber.Map{
+		"message":        "Project published",
+		"publish_status": models.PublishPublished,
+	})
+}
+
+// UnpublishProject - POST /api/advisors/projects/:id/unpublish
+// Takes the project out of the showcase; the student's consent is kept so it can be approved again
+func (h *ShowcaseHandler) UnpublishProject(c *fiber.Ctx) error {
+	var input struct {
+		Reason string `json:"reason"`
+	}
+	c.BodyParser(&input)
+
+	project, err := findAccessibleProject(h.DB, c, c.Params("id"))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Project not found or access denied"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch project"})
+	}
+
+	if project.PublishStatus == models.PublishPrivate {
+		return c.Status(409).JSON(fiber.Map{"error": "Project is not published"})
+	}
+
+	message := fmt.Sprintf("%s was removed from the public project showcase", project.Title)
+	if input.Reason != "" {
+		message += ": " + input.Reason
+	}
+
+	var notification *models.Notification
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(project).Updates(map[string]interface{}{
+			"publish_status": models.PublishPending,
+			"published_at":   nil,
+			"published_by":   nil,
+		}).Error; err != nil {
+			return err
+		}
+		notification, err = h.notifyStudent(tx, project, "Project unpublished", message, "warning")
+		return err
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to unpublish project"})
+	}
+	h.Files.Notifier.Push(notification)
+
+	return c.JSON(fiber.Map{
+		"message":        "Project unpublished",
+		"publish_status": models.PublishPending,
+	})
+}
+
+// notifyStudent stores a notification for the project's student, following their preferences;
+// push it once tx has committed
+func (h *ShowcaseHandler) notifyStudent(tx *gorm.DB, project *models.Project, title, message, kind string) (*models.Notification, error) {
+	var student models.Student
+	if err := tx.First(&student, "id = ?", project.StudentID).Error; err != nil {
+		return nil, nil
+	}
+	notification := &models.Notification{
+		UserID:           student.UserID,
+		Title:            title,
+		Message:          message,
+		Type:             kind,
+		RelatedProjectID: &project.ID,
+		EventType:        notify.TypePublication,
+	}
+	_, err := h.Files.Notifier.Create(tx, notification)
+	return notification, err
+}
+
+// SearchShowcase - GET /api/showcase?q=&keyword=&year=&page=&limit=
+func (h *ShowcaseHandler) SearchShowcase(c *fiber.Ctx) error {
+	page := c.QueryInt("page", 1)
+	limit := c.QueryInt("limit", 12)
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 50 {
+		limit = 12
+	}
+	offset := (page - 1) * limit
+
+	query := h.DB.Model(&models.Project{}).Where("publish_status = ?", models.PublishPublished)
+
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		like := "%" + escapeLike(q) + "%"
+		query = query.Where(`title ILIKE ? ESCAPE '\' OR abstract ILIKE ? ESCAPE '\' OR array_to_string(keywords, ' ') ILIKE ? ESCAPE '\'`, like, like, like)
+	}
+	if keyword := strings.TrimSpace(c.Query("keyword")); keyword != "" {
+		query = query.Where("? = ANY(keywords)", keyword)
+	}
+	if year := c.QueryInt("year", 0); year > 0 {
+		query = query.Where("academic_year = ?", year)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var projects []models.Project
+	if err := query.Preload("Student.User").Preload("Advisor.User").
+		Order("published_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&projects).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to search projects"})
+	}
+
+	items := make([]fiber.Map, len(projects))
+	for i := range projects {
+		items[i] = showcaseProject(&projects[i], nil)
+	}
+
+	return c.JSON(fiber.Map{
+		"projects": items,
+		"pagination": fiber.Map{
+			"page":  page,
+			"limit": limit,
+			"total": total,
+			"pages": (total + int64(limit) - 1) / int64(limit),
+		},
+	})
+}
+
+// GetShowcaseProject - GET /api/showcase/:id
+// Signed-in members of the project can preview it before it is published
+func (h *ShowcaseHandler) GetShowcaseProject(c *fiber.Ctx) error {
+	project, err := h.findShowcaseProject(c)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Project not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch project"})
+	}
+
+	var files []models.ProjectFile
+	if err := h.DB.Where("project_id = ? AND is_public = ? AND scan_status <> ?", project.ID, true, "infected").
+		Where(latestPublicVersionCondition).
+		Order("file_category, file_name").
+		Find(&files).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch files"})
+	}
+
+	return c.JSON(showcaseProject(project, files))
+}
+
+// latestPublicVersionCondition keeps the newest public version of each document. A new version is
+// private until the student selects it again and the advisor approves, so until then the showcase
+// keeps showing the version that was approved.
+const latestPublicVersionCondition = `NOT EXISTS (SELECT 1 FROM project_files pf WHERE pf.document_id = project_files.document_id
+	AND pf.version > project_files.version AND pf.is_public AND pf.scan_status <> 'infected')`
+
+// DownloadShowcaseFile - GET /api/showcase/:id/files/:fileId/download
+func (h *ShowcaseHandler) DownloadShowcaseFile(c *fiber.Ctx) error {
+	project, err := h.findShowcaseProject(c)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Project not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch project"})
+	}
+
+	fileID := c.Params("fileId")
+	if !isUUID(fileID) {
+		return c.Status(404).JSON(fiber.Map{"error": "File not found"})
+	}
+
+	var projectFile models.ProjectFile
+	if err := h.DB.Where("id = ? AND project_id = ? AND is_public = ?", fileID, project.ID, true).
+		First(&projectFile).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "File not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch file"})
+	}
+
+	return h.Files.sendFile(c, &projectFile)
+}
+
+// findShowcaseProject loads a published project, or an unpublished one the signed-in user can access
+func (h *ShowcaseHandler) findShowcaseProject(c *fiber.Ctx) (*models.Project, error) {
+	if !isUUID(c.Params("id")) {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	var project models.Project
+	if err := h.DB.Preload("Student.User").Preload("Advisor.User").
+		First(&project, "id = ?", c.Params("id")).Error; err != nil {
+		return nil, err
+	}
+
+	if project.PublishStatus == models.PublishPublished {
+		return &project, nil
+	}
+	if c.Locals("user_id") == nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+	if _, err := findAccessibleProject(h.DB, c, project.ID); err != nil {
+		return nil, err
+	}
+	return &project, nil
+}
+
+// showcaseProject is the public view of a project; it leaves out contact details and internal fields
+func showcaseProject(project *models.Project, files []models.ProjectFile) fiber.Map {
+	result := fiber.Map{
+		"id":             project.ID,
+		"title":          project.Title,
+		"abstract":       project.Abstract,
+		"keywords":       project.Keywords,
+		"academic_year":  project.AcademicYear,
+		"semester":       project.Semester,
+		"publish_status": project.PublishStatus,
+		"published_at":   project.PublishedAt,
+	}
+	if project.Keywords == nil {
+		result["keywords"] = []string{}
+	}
+	if project.Student != nil && project.Student.User != nil {
+		result["student_name"] = project.Student.User.FullName
+	}
+	if project.Advisor != nil && project.Advisor.User != nil {
+		result["advisor_name"] = project.Advisor.User.FullName
+	}
+
+	if files != nil {
+		publicFiles := make([]fiber.Map, len(files))
+		for i, f := range files {
+			publicFiles[i] = fiber.Map{
+				"id":            f.ID,
+				"file_name":     f.FileName,
+				"file_category": f.FileCategory,
+				"file_size":     f.FileSize,
+				"version":       f.Version,
+				"uploaded_at":   f.CreatedAt,
+			}
+		}
+		result["files"] = publicFiles
+	}
+	return result
+}
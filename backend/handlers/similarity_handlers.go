@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/similarity"
+	"math"
+	"path/filepath"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// percent turns a 0-1 similarity into a percentage with one decimal
+func percent(score float64) float64 {
+	return math.Round(score*1000) / 10
+}
+
+// GetSimilarityReport - GET /api/files/:id/similarity
+// Lists the closest earlier and later submissions of other projects (advisor/admin only)
+func (h *FileHandler) GetSimilarityReport(c *fiber.Ctx) error {
+	userRole := c.Locals("user_role")
+	if userRole != "advisor" && userRole != "admin" {
+		return c.Status(403).JSON(fiber.Map{"error": "Advisor access required"})
+	}
+
+	projectFile, err := findAccessibleFile(h.DB, c, c.Params("id"))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "File not found or access denied"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch file"})
+	}
+
+	var matches []models.SimilarityMatch
+	if err := h.DB.Where("file_id = ? OR matched_file_id = ?", projectFile.ID, projectFile.ID).
+		Order("score DESC").
+		Limit(20).
+		Find(&matches).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch similarity matches"})
+	}
+
+	otherIDs := make([]string, 0, len(matches))
+	for _, m := range matches {
+		if m.FileID == projectFile.ID {
+			otherIDs = append(otherIDs, m.MatchedFileID)
+		} else {
+			otherIDs = append(otherIDs, m.FileID)
+		}
+	}
+
+	others := map[string]models.ProjectFile{}
+	if len(otherIDs) > 0 {
+		var files []models.ProjectFile
+		if err := h.DB.Preload("Project.Student.User").Where("id IN ?", otherIDs).Find(&files).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch matched files"})
+		}
+		for _, f := range files {
+			others[f.ID] = f
+		}
+	}
+
+	results := []fiber.Map{}
+	for i, m := range matches {
+		other, ok := others[otherIDs[i]]
+		if !ok {
+			continue
+		}
+		result := fiber.Map{
+			"file_id":       other.ID,
+			"file_name":     other.FileName,
+			"version":       other.Version,
+			"file_category": other.FileCategory,
+			"uploaded_at":   other.CreatedAt,
+			"similarity":    percent(m.Score),
+			// earlier: this file matched a submission that already existed when it was checked
+			"earlier": m.FileID == projectFile.ID,
+		}
+		if other.Project != nil {
+			result["project_id"] = other.Project.ID
+			result["project_title"] = other.Project.Title
+			if other.Project.Student != nil && other.Project.Student.User != nil {
+				result["student_name"] = other.Project.Student.User.FullName
+			}
+		}
+		results = append(results, result)
+	}
+
+	report := fiber.Map{
+		"file_id":           projectFile.ID,
+		"similarity_status": projectFile.SimilarityStatus,
+		"flagged":           projectFile.SimilarityFlagged,
+		"checked_at":        projectFile.SimilarityCheckedAt,
+		"matches":           results,
+	}
+	if projectFile.SimilarityScore != nil {
+		report["similarity"] = percent(*projectFile.SimilarityScore)
+	}
+	if h.Similarity != nil {
+		report["threshold"] = percent(h.Similarity.Threshold())
+	}
+	if projectFile.SimilarityStatus == models.SimilarityFailed {
+		report["error"] = projectFile.SimilarityError
+	}
+	return c.JSON(report)
+}
+
+// RecheckSimilarity - POST /api/files/:id/similarity
+// Queues the file to be checked again (advisor/admin only)
+func (h *FileHandler) RecheckSimilarity(c *fiber.Ctx) error {
+	userRole := c.Locals("user_role")
+	if userRole != "advisor" && userRole != "admin" {
+		return c.Status(403).JSON(fiber.Map{"error": "Advisor access required"})
+	}
+	if h.Similarity == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "Similarity checking is disabled"})
+	}
+
+	projectFile, err := findAccessibleFile(h.DB, c, c.Params("id"))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "File not found or access denied"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch file"})
+	}
+
+	if !similarity.Supported(filepath.Ext(projectFile.FileName)) {
+		return c.Status(400).JSON(fiber.Map{"error": "Similarity checks support PDF, DOCX and TXT files"})
+	}
+	if projectFile.ScanStatus == "infected" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "The file is quarantined because it is infected"})
+	}
+
+	if err := h.DB.Model(&models.ProjectFile{}).
+		Where("id = ?", projectFile.ID).
+		Updates(map[string]interface{}{
+			"similarity_status":   models.SimilarityPending,
+			"similarity_attempts": 0,
+			"similarity_error":    "",
+		}).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to queue similarity check"})
+	}
+	h.Similarity.Enqueue()
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"similarity_status": models.SimilarityPending})
+}
+
+// GetSimilarityFlags - GET /api/advisors/similarity-flags
+// Files of the advisor's projects (every project for admins) that exceeded the similarity threshold
+func (h *FileHandler) GetSimilarityFlags(c *fiber.Ctx) error {
+	userID := c.Locals("user_id")
+	userRole := c.Locals("user_role")
+
+	query := h.DB.Preload("Project.Student.User").
+		Select("project_files.*").
+		Joins("JOIN projects ON projects.id = project_files.project_id").
+		Where("project_files.similarity_flagged = ?", true)
+
+	if userRole == "advisor" {
+		var advisor models.Advisor
+		if err := h.DB.Where("user_id = ?", userID).First(&advisor).Error; err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "Advisor record not found"})
+		}
+		query = query.Where("projects.advisor_id = ?", advisor.ID)
+	}
+
+	var files []models.ProjectFile
+	if err := query.Order("project_files.similarity_score DESC").Find(&files).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch flagged files"})
+	}
+
+	return c.JSON(fiber.Map{
+		"files": files,
+		"total": len(files),
+	})
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/settings"
+	"backend/storagegc"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// StorageHandler reports storage usage against the quotas and runs the storage reconciliation
+type StorageHandler struct {
+	DB         *gorm.DB
+	Settings   *settings.Service
+	Reconciler *storagegc.Reconciler
+}
+
+func NewStorageHandler(db *gorm.DB, settingsService *settings.Service, reconciler *storagegc.Reconciler) *StorageHandler {
+	return &StorageHandler{
+		DB:         db,
+		Settings:   settingsService,
+		Reconciler: reconciler,
+	}
+}
+
+// storedBytes sums the size of the files matching query.
+// Quarantined uploads are not counted; students cannot use or remove them.
+func storedBytes(query *gorm.DB) (int64, error) {
+	var total int64
+	err := query.Model(&models.ProjectFile{}).
+		Where("scan_status <> ?", "infected").
+		Select("COALESCE(SUM(file_size), 0)").
+		Scan(&total).Error
+	return total, err
+}
+
+// reservedBytes sums the size of resumable uploads still receiving chunks.
+// Sessions being assembled are left out: they are checked again when their file row is saved.
+func reservedBytes(query *gorm.DB) (int64, error) {
+	var total int64
+	err := query.Model(&models.UploadSession{}).
+		Where("status = ? AND expires_at > ?", "active", time.Now()).
+		Select("COALESCE(SUM(file_size), 0)").
+		Scan(&total).Error
+	return total, err
+}
+
+// checkQuota returns an uploadError when adding size bytes would exceed the project or user quota.
+// withSessions also counts unfinished resumable uploads, so parallel sessions cannot overshoot;
+// it is false when the upload being saved is itself one of those sessions.
+func checkQuota(db *gorm.DB, settingsService *settings.Service, projectID, userID string, size int64, withSessions bool) error {
+	projectQuota, userQuota := settingsService.Quotas()
+
+	check := func(quota int64, column, id, message string) error {
+		if quota <= 0 || id == "" {
+			return nil
+		}
+		used, err := storedBytes(db.Where(column+" = ?", id))
+		if err != nil {
+			return &uploadError{Status: 500, Message: "Failed to check storage quota"}
+		}
+		if withSessions {
+			sessionColumn := "project_id"
+			if column == "uploaded_by" {
+				sessionColumn = "user_id"
+			}
+			reserved, err := reservedBytes(db.Where(sessionColumn+" = ?", id))
+			if err != nil {
+				return &uploadError{Status: 500, Message: "Failed to check storage quota"}
+			}
+			used += reserved
+		}
+		if used+size > quota {
+			return &uploadError{
+				Status:  fiber.StatusRequestEntityTooLarge,
+				Message: fmt.Sprintf("%s (%dMB)", message, quota>>20),
+				Extra:   fiber.Map{"quota_bytes": quota, "used_bytes": used},
+			}
+		}
+		return nil
+	}
+
+	if err := check(projectQuota, "project_id", projectID, "Project storage quota exceeded"); err != nil {
+		return err
+	}
+	return check(userQuota, "uploaded_by", userID, "Your storage quota is exceeded")
+}
+
+// GetProjectStorage - GET /api/projects/:id/storage
+// Bytes used by the project and by the current user against their quotas (0 = unlimited)
+func (h *StorageHandler) GetProjectStorage(c *fiber.Ctx) error {
+	project, err := findAccessibleProject(h.DB, c, c.Params("id"))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Project not found or access denied"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch project"})
+	}
+	userID, _ := c.Locals("user_id").(string)
+
+	projectUsed, err := storedBytes(h.DB.Where("project_id = ?", project.ID))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to calculate storage usage"})
+	}
+	userUsed, err := storedBytes(h.DB.Where("uploaded_by = ?", userID))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to calculate storage usage"})
+	}
+
+	projectQuota, userQuota := h.Settings.Quotas()
+	return c.JSON(fiber.Map{
+		"project": fiber.Map{"used_bytes": projectUsed, "quota_bytes": projectQuota},
+		"user":    fiber.Map{"used_bytes": userUsed, "quota_bytes": userQuota},
+	})
+}
+
+// GetStorageUsage - GET /api/admin/storage
+// Total usage and the projects using the most space
+func (h *StorageHandler) GetStorageUsage(c *fiber.Ctx) error {
+	total, err := storedBytes(h.DB)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to calculate storage usage"})
+	}
+
+	var quarantined int64
+	if err := h.DB.Model(&models.ProjectFile{}).
+		Where("scan_status = ?", "infected").
+		Select("COALESCE(SUM(file_size), 0)").
+		Scan(&quarantined).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to calculate storage usage"})
+	}
+
+	// Bytes not stored again because identical uploads share a blob
+	var deduplicated int64
+	if err := h.DB.Model(&models.FileBlob{}).
+		Where("ref_count > 1").
+		Select("COALESCE(SUM(file_size * (ref_count - 1)), 0)").
+		Scan(&deduplicated).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to calculate storage usage"})
+	}
+
+	var projects []struct {
+		ProjectID string `json:"project_id"`
+		Title     string `json:"title"`
+		Files     int64  `json:"files"`
+		UsedBytes int64  `json:"used_bytes"`
+	}
+	if err := h.DB.Model(&models.ProjectFile{}).
+		Select("project_files.project_id, projects.title, COUNT(*) AS files, COALESCE(SUM(project_files.file_size), 0) AS used_bytes").
+		Joins("JOIN projects ON projects.id = project_files.project_id").
+		Where("project_files.scan_status <> ?", "infected").
+		Group("project_files.project_id, projects.title").
+		Order("used_bytes DESC").
+		Limit(c.QueryInt("limit", 20)).
+		Scan(&projects).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to calculate storage usage"})
+	}
+
+	projectQuota, userQuota := h.Settings.Quotas()
+	return c.JSON(fiber.Map{
+		"used_bytes":         total,
+		"quarantined_bytes":  quarantined,
+		"deduplicated_bytes": deduplicated,
+		"project_quota":      projectQuota,
+		"user_quota":         userQuota,
+		"projects":           projects,
+	})
+}
+
+// ReconcileStorage - GET /api/admin/storage/reconcile (dry run) and POST /api/admin/storage/reconcile
+// POST body {"delete_orphans": true, "delete_dangling": true} selects what is cleaned up
+func (h *StorageHandler) ReconcileStorage(c *fiber.Ctx) error {
+	var opts storagegc.Options
+	if c.Method() == fiber.MethodPost {
+		var input struct {
+			DeleteOrphans  bool `json:"delete_orphans"`
+			DeleteDangling bool `json:"delete_dangling"`
+		}
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+		opts = storagegc.Options{DeleteOrphans: input.DeleteOrphans, DeleteDangling: input.DeleteDangling}
+	}
+
+	report, err := h.Reconciler.Run(c.Context(), opts)
+	if err != nil {
+		log.Printf("Storage reconciliation failed: %v", err)
+		body := fiber.Map{"error": "Storage reconciliation failed", "details": err.Error()}
+		if report != nil {
+			body["report"] = report
+		}
+		return c.Status(500).JSON(body)
+	}
+
+	if !report.DryRun {
+		userID, _ := c.Locals("user_id").(string)
+		log.Printf("Storage reconciliation by %s: deleted %d objects (%d bytes) and %d rows, %d failures",
+			userID, report.DeletedObjects, report.DeletedBytes, report.DeletedRows, report.Failed)
+	}
+	return c.JSON(report)
+}
@@ -0,0 +1,414 @@
+package handlers
+
+import (
+	"backend/models"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultChunkSize = 5 << 20   // 5MB
+	minChunkSize     = 256 << 10 // 256KB
+	maxChunkSize     = 16 << 20  // 16MB
+	uploadSessionTTL = 24 * time.Hour
+	assemblyTimeout  = time.Hour // after which a session stuck assembling, e.g. by a crash, is expired
+)
+
+// UploadSessionHandler implements resumable uploads: init, send chunks in any order (and again after a
+// disconnect), then complete. Chunks are kept in the storage backend so any replica can accept them.
+type UploadSessionHandler struct {
+	DB    *gorm.DB
+	Files *FileHandler
+}
+
+func NewUploadSessionHandler(db *gorm.DB, files *FileHandler) *UploadSessionHandler {
+	return &UploadSessionHandler{DB: db, Files: files}
+}
+
+// CreateUploadSession - POST /api/projects/:id/uploads
+func (h *UploadSessionHandler) CreateUploadSession(c *fiber.Ctx) error {
+	var input struct {
+		FileName     string `json:"file_name"`
+		FileSize     int64  `json:"file_size"`
+		FileCategory string `json:"file_category"`
+		DocumentID   string `json:"document_id"`
+		Description  string `json:"description"`
+		Checksum     string `json:"checksum"` // optional SHA-256 (hex) of the whole file
+		ChunkSize    int64  `json:"chunk_size"`
+	}
+
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	if input.FileName == "" || input.FileSize <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "file_name and file_size are required"})
+	}
+	if input.Checksum != "" && !isSHA256Hex(input.Checksum) {
+		return c.Status(400).JSON(fiber.Map{"error": "checksum must be a SHA-256 in hex (64 characters)"})
+	}
+
+	userID, ok := c.Locals("user_id").(string)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "User not authenticated"})
+	}
+
+	projectId := c.Params("id")
+	target, err := h.Files.resolveUploadTarget(c, projectId, input.DocumentID, input.FileCategory, input.FileName, input.FileSize)
+	if err != nil {
+		return uploadErrorResponse(c, err)
+	}
+
+	chunkSize := input.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = defaultChunkSize
+	}
+	if chunkSize < minChunkSize || chunkSize > maxChunkSize {
+		return c.Status(400).JSON(fiber.Map{"error": "chunk_size must be between 256KB and 16MB"})
+	}
+
+	session := models.UploadSession{
+		ProjectID:    projectId,
+		UserID:       userID,
+		FileName:     input.FileName,
+		FileCategory: target.Category,
+		Description:  input.Description,
+		FileSize:     input.FileSize,
+		ChunkSize:    chunkSize,
+		TotalChunks:  int((input.FileSize + chunkSize - 1) / chunkSize),
+		Checksum:     strings.ToLower(input.Checksum),
+		Status:       "active",
+		ExpiresAt:    time.Now().Add(uploadSessionTTL),
+	}
+	if target.Previous != nil {
+		session.DocumentID = &target.Previous.DocumentID
+	}
+
+	if err := h.DB.Create(&session).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create upload session"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(uploadSessionResponse(&session, nil))
+}
+
+// GetUploadSession - GET /api/uploads/:id
+// Clients call this after reconnecting to learn which chunks still have to be sent
+func (h *UploadSessionHandler) GetUploadSession(c *fiber.Ctx) error {
+	session, findErr := h.findSession(c)
+	if findErr != nil {
+		return uploadErrorResponse(c, findErr)
+	}
+
+	var chunks []models.UploadChunk
+	if err := h.DB.Where("session_id = ?", session.ID).Order("chunk_index").Find(&chunks).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch upload chunks"})
+	}
+
+	return c.JSON(uploadSessionResponse(session, chunks))
+}
+
+// UploadChunk - PUT /api/uploads/:id/chunks/:index
+// The body is the raw chunk; X-Chunk-Checksum carries its SHA-256 in hex
+func (h *UploadSessionHandler) UploadChunk(c *fiber.Ctx) error {
+	session, findErr := h.findSession(c)
+	if findErr != nil {
+		return uploadErrorResponse(c, findErr)
+	}
+	if session.Status != "active" {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Upload session is " + session.Status})
+	}
+
+	index, err := c.ParamsInt("index")
+	if err != nil || index < 0 || index >= session.TotalChunks {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid chunk index"})
+	}
+
+	body := c.Body()
+	if int64(len(body)) != session.ChunkLength(index) {
+		return c.Status(400).JSON(fiber.Map{
+			"error":    "Invalid chunk size",
+			"expected": session.ChunkLength(index),
+		})
+	}
+
+	expected := strings.ToLower(c.Get("X-Chunk-Checksum"))
+	if expected == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "X-Chunk-Checksum header is required"})
+	}
+	if !isSHA256Hex(expected) {
+		return c.Status(400).JSON(fiber.Map{"error": "X-Chunk-Checksum must be a SHA-256 in hex (64 characters)"})
+	}
+	sum := sha256.Sum256(body)
+	actual := hex.EncodeToString(sum[:])
+	if actual != expected {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":    "Chunk checksum mismatch, please resend the chunk",
+			"checksum": actual,
+		})
+	}
+
+	if err := h.Files.Storage.Put(c.Context(), session.ChunkKey(index), bytes.NewReader(body), int64(len(body)), "application/octet-stream"); err != nil {
+		log.Printf("Failed to store chunk %d of upload %s: %v", index, session.ID, err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to store chunk"})
+	}
+
+	// Re-sending a chunk replaces it
+	chunk := models.UploadChunk{
+		SessionID:  session.ID,
+		ChunkIndex: index,
+		Size:       int64(len(body)),
+		SHA256:     actual,
+		CreatedAt:  time.Now(),
+	}
+	if err := h.DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&chunk).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to record chunk"})
+	}
+
+	// Activity keeps the session alive
+	h.DB.Model(session).Update("expires_at", time.Now().Add(uploadSessionTTL))
+
+	var received int64
+	h.DB.Model(&models.UploadChunk{}).Where("session_id = ?", session.ID).Count(&received)
+
+	return c.JSON(fiber.Map{
+		"chunk_index":     index,
+		"checksum":        actual,
+		"received_chunks": received,
+		"total_chunks":    session.TotalChunks,
+	})
+}
+
+// CompleteUploadSession - POST /api/uploads/:id/complete
+// Assembles the chunks, then validates, scans and stores the file like a normal upload
+func (h *UploadSessionHandler) CompleteUploadSession(c *fiber.Ctx) error {
+	session, findErr := h.findSession(c)
+	if findErr != nil {
+		return uploadErrorResponse(c, findErr)
+	}
+
+	var chunks []models.UploadChunk
+	if err := h.DB.Where("session_id = ?", session.ID).Order("chunk_index").Find(&chunks).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch upload chunks"})
+	}
+	if len(chunks) != session.TotalChunks {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":          "Upload is incomplete",
+			"missing_chunks": missingChunks(session, chunks),
+		})
+	}
+
+	// Only one request may assemble a session
+	result := h.DB.Model(&models.UploadSession{}).
+		Where("id = ? AND status = ?", session.ID, "active").
+		Updates(map[string]interface{}{
+			"status":     "assembling",
+			"expires_at": time.Now().Add(assemblyTimeout),
+		})
+	if result.Error != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to complete upload"})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Upload session is not active"})
+	}
+
+	projectFile, err := h.assemble(c, session)
+	now := time.Now()
+	if uploadErr, ok := err.(*uploadError); ok && uploadErr.Quarantined != nil {
+		// Completing again would only quarantine another copy
+		h.DB.Model(&models.UploadSession{}).Where("id = ?", session.ID).Updates(map[string]interface{}{
+			"status":       "failed",
+			"file_id":      uploadErr.Quarantined.ID,
+			"completed_at": &now,
+		})
+		h.deleteChunks(c.Context(), session)
+		return uploadErrorResponse(c, err)
+	}
+	if err != nil {
+		// Let the client retry completion
+		h.DB.Model(&models.UploadSession{}).Where("id = ?", session.ID).Updates(map[string]interface{}{
+			"status":     "active",
+			"expires_at": now.Add(uploadSessionTTL),
+		})
+		return uploadErrorResponse(c, err)
+	}
+
+	h.DB.Model(&models.UploadSession{}).Where("id = ?", session.ID).Updates(map[string]interface{}{
+		"status":       "completed",
+		"file_id":      projectFile.ID,
+		"completed_at": &now,
+	})
+	h.deleteChunks(c.Context(), session)
+
+	return c.JSON(fiber.Map{
+		"message": "File uploaded successfully",
+		"file":    projectFile,
+	})
+}
+
+// AbortUploadSession - DELETE /api/uploads/:id
+func (h *UploadSessionHandler) AbortUploadSession(c *fiber.Ctx) error {
+	session, findErr := h.findSession(c)
+	if findErr != nil {
+		return uploadErrorResponse(c, findErr)
+	}
+	if session.Status != "active" {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Upload session is " + session.Status})
+	}
+
+	h.DB.Model(session).Update("status", "aborted")
+	h.deleteChunks(c.Context(), session)
+
+	return c.JSON(fiber.Map{"message": "Upload aborted"})
+}
+
+// assemble concatenates the chunks into a temporary file and hands it to the normal upload pipeline
+func (h *UploadSessionHandler) assemble(c *fiber.Ctx, session *models.UploadSession) (*models.ProjectFile, error) {
+	documentId := ""
+	if session.DocumentID != nil {
+		documentId = *session.DocumentID
+	}
+	// Limits and the document may have changed since the session started
+	target, err := h.Files.resolveUploadTarget(c, session.ProjectID, documentId, session.FileCategory, session.FileName, session.FileSize)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	w := io.MultiWriter(tmp, hash)
+	for i := 0; i < session.TotalChunks; i++ {
+		r, err := h.Files.Storage.Get(c.Context(), session.ChunkKey(i))
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(w, r)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if session.Checksum != "" && hex.EncodeToString(hash.Sum(nil)) != session.Checksum {
+		return nil, &uploadError{Status: fiber.StatusUnprocessableEntity, Message: "File checksum mismatch after assembly"}
+	}
+
+	return h.Files.saveUpload(c.Context(), target, tmp, session.FileSize, session.FileName, session.Description, session.UserID)
+}
+
+// findSession loads an upload session of the current user
+func (h *UploadSessionHandler) findSession(c *fiber.Ctx) (*models.UploadSession, *uploadError) {
+	var session models.UploadSession
+	if err := h.DB.Where("id = ? AND user_id = ?", c.Params("id"), c.Locals("user_id")).First(&session).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, &uploadError{Status: 404, Message: "Upload session not found"}
+		}
+		return nil, &uploadError{Status: 500, Message: "Failed to fetch upload session"}
+	}
+	if session.Status == "active" && time.Now().After(session.ExpiresAt) {
+		return nil, &uploadError{Status: fiber.StatusGone, Message: "Upload session has expired"}
+	}
+	return &session, nil
+}
+
+func (h *UploadSessionHandler) deleteChunks(ctx context.Context, session *models.UploadSession) {
+	for i := 0; i < session.TotalChunks; i++ {
+		if err := h.Files.Storage.Delete(ctx, session.ChunkKey(i)); err != nil {
+			log.Printf("Failed to delete chunk %d of upload %s: %v", i, session.ID, err)
+		}
+	}
+	h.DB.Where("session_id = ?", session.ID).Delete(&models.UploadChunk{})
+}
+
+// CleanupExpired marks abandoned sessions, and those whose assembly never finished, as expired
+// and deletes their chunks
+func (h *UploadSessionHandler) CleanupExpired(ctx context.Context) (int, error) {
+	var sessions []models.UploadSession
+	if err := h.DB.Where("status IN ? AND expires_at < ?", []string{"active", "assembling"}, time.Now()).Find(&sessions).Error; err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for i := range sessions {
+		// Skip sessions that were resumed or finished assembling in the meantime
+		result := h.DB.Model(&models.UploadSession{}).
+			Where("id = ? AND status = ? AND expires_at < ?", sessions[i].ID, sessions[i].Status, time.Now()).
+			Update("status", "expired")
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
+		h.deleteChunks(ctx, &sessions[i])
+		expired++
+	}
+	return expired, nil
+}
+
+// RunCleanup calls CleanupExpired every interval until ctx is cancelled
+func (h *UploadSessionHandler) RunCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := h.CleanupExpired(ctx); err != nil {
+				log.Printf("Failed to clean up expired uploads: %v", err)
+			} else if n > 0 {
+				log.Printf("Cleaned up %d expired upload sessions", n)
+			}
+		}
+	}
+}
+
+// isSHA256Hex reports whether s is a hex encoded SHA-256
+func isSHA256Hex(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func missingChunks(session *models.UploadSession, chunks []models.UploadChunk) []int {
+	received := make(map[int]bool, len(chunks))
+	for _, chunk := range chunks {
+		received[chunk.ChunkIndex] = true
+	}
+	missing := []int{}
+	for i := 0; i < session.TotalChunks; i++ {
+		if !received[i] {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+func uploadSessionResponse(session *models.UploadSession, chunks []models.UploadChunk) fiber.Map {
+	received := make([]int, 0, len(chunks))
+	for _, chunk := range chunks {
+		received = append(received, chunk.ChunkIndex)
+	}
+	return fiber.Map{
+		"session":         session,
+		"received_chunks": received,
+		"missing_chunks":  missingChunks(session, chunks),
+	}
+}
@@ -0,0 +1,67 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"os"
+	"strconv"
+)
+
+// Message is one email; HTML and Text are alternative renderings of the same content
+type Message struct {
+	To      string
+	Subject string
+	HTML    string
+	Text    string
+}
+
+// Mailer sends email
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewFromEnv returns the mailer selected by MAILER:
+// "smtp" (SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD), "file" (writes .eml files to MAIL_DIR)
+// or "" for no email (nil). MAIL_FROM is the sender of both.
+func NewFromEnv() (Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "Project 4101 <no-reply@rumail.ru.ac.th>"
+	}
+
+	switch os.Getenv("MAILER") {
+	case "":
+		return nil, nil
+	case "smtp":
+		host := os.Getenv("SMTP_HOST")
+		if host == "" {
+			return nil, errors.New("mailer: SMTP_HOST is required")
+		}
+		port := 587
+		if v := os.Getenv("SMTP_PORT"); v != "" {
+			p, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("mailer: invalid SMTP_PORT %q", v)
+			}
+			port = p
+		}
+		return NewSMTPMailer(host, port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), from), nil
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "./mail"
+		}
+		return NewFileMailer(dir, from)
+	default:
+		return nil, fmt.Errorf("mailer: unknown mailer %q", os.Getenv("MAILER"))
+	}
+}
+
+// IsPermanent reports whether sending failed for a reason retrying will not fix,
+// e.g. the server rejected the recipient with a 5xx reply
+func IsPermanent(err error) bool {
+	var protoErr *textproto.Error
+	return errors.As(err, &protoErr) && protoErr.Code >= 500
+}
@@ -1,16 +1,30 @@
 package main
 
 import (
+	"backend/events"
+	"backend/filescan"
 	"backend/handlers"
+	"backend/mailer"
 	"backend/middlewares"
 	"backend/models"
+	"backend/notify"
+	"backend/preview"
+	"backend/realtime"
+	"backend/settings"
+	"backend/similarity"
+	"backend/storage"
+	"backend/storagegc"
+	"backend/webpush"
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/gofiber/websocket/v2"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -18,6 +32,9 @@ import (
 
 var db *gorm.DB
 
+// eventBus carries domain events, e.g. approvals, to the notification service
+var eventBus *events.Bus
+
 func main() {
 	// Database configs from ENV (fallbacks)
 	host := getEnv("DB_HOST", "localhost")
@@ -45,25 +62,142 @@ func main() {
 
 	log.Println("Database connected successfully.")
 
+	// File storage backend (STORAGE_BACKEND=local|s3)
+	store, err := storage.NewFromEnv()
+	if err != nil {
+		log.Fatal("Failed to initialize file storage: ", err)
+	}
+
+	// Malware scanner for uploads (SCANNER=clamav|fake, unset disables scanning)
+	scanner, err := filescan.NewScannerFromEnv()
+	if err != nil {
+		log.Fatal("Failed to initialize malware scanner: ", err)
+	}
+
+	// Document previews (PREVIEW_CONVERTER=command|fake, unset disables previews)
+	converter, err := preview.NewConverterFromEnv()
+	if err != nil {
+		log.Fatal("Failed to initialize preview converter: ", err)
+	}
+	var previewWorker *preview.Worker
+	if converter != nil {
+		previewWorker = preview.NewWorker(db, store, converter)
+	}
+
+	// Email delivery of notifications (MAILER=smtp|file, unset disables email)
+	mail, err := mailer.NewFromEnv()
+	if err != nil {
+		log.Fatal("Failed to initialize mailer: ", err)
+	}
+
+	// Web Push of notifications (VAPID_PUBLIC_KEY and VAPID_PRIVATE_KEY, unset disables push)
+	pushSender, err := webpush.NewFromEnv()
+	if err != nil {
+		log.Fatal("Failed to initialize web push: ", err)
+	}
+
+	// Maintenance commands, e.g. `./main storage-migrate --from local --to s3`
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Hard ceiling for request bodies; upload size settings cannot exceed it
+	bodyLimitMB, err := strconv.Atoi(getEnv("MAX_BODY_SIZE_MB", "200"))
+	if err != nil || bodyLimitMB <= 0 {
+		log.Fatal("MAX_BODY_SIZE_MB must be a positive number")
+	}
+	settingsService := settings.NewService(db, int64(bodyLimitMB)<<20)
+
+	// WebSocket rooms shared by project chats and per-user notification sockets. Broadcasts reach
+	// the other replicas through Postgres LISTEN/NOTIFY (REALTIME_PUBSUB=postgres|local)
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Fatal("Failed to access the database connection pool: ", err)
+	}
+	pubsub, err := realtime.NewPubSubFromEnv(sqlDB, dsn)
+	if err != nil {
+		log.Fatal("Failed to initialize realtime pub/sub: ", err)
+	}
+	hub := realtime.NewHub(pubsub)
+	go hub.Run()
+	appURL := getEnv("APP_URL", "http://localhost:3000")
+	var emailWorker *notify.EmailWorker
+	if mail != nil {
+		emailWorker = notify.NewEmailWorker(db, mail, settingsService, appURL)
+	}
+	var pushWorker *notify.PushWorker
+	if pushSender != nil {
+		pushWorker = notify.NewPushWorker(db, pushSender, appURL)
+	}
+	notifier := notify.NewService(db, hub, emailWorker, pushWorker)
+
+	// Domain events published by the handlers become notifications
+	eventBus = events.NewBus()
+	notifier.Subscribe(eventBus)
+
+	// Similarity checks of reports against earlier submissions (SIMILARITY_CHECK=false disables them)
+	var similarityChecker *similarity.Checker
+	if getEnv("SIMILARITY_CHECK", "true") == "true" {
+		similarityChecker = similarity.NewChecker(db, store, settingsService, notifier)
+	}
+
 	// Initialize Fiber app
-	app := fiber.New()
+	app := fiber.New(fiber.Config{
+		// Leave room for the multipart envelope around the largest allowed file
+		BodyLimit: bodyLimitMB<<20 + 1<<20,
+	})
+	// A panic in one handler answers 500 instead of taking the server down
+	app.Use(recover.New())
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     "http://localhost:3000,http://localhost:3001",
 		AllowMethods:     "GET,POST,PUT,PATCH,DELETE,OPTIONS",
 		AllowHeaders:     "Origin,Content-Type,Accept,Authorization,X-Requested-With",
+		ExposeHeaders:    "X-Next-Cursor, X-Has-More",
 		AllowCredentials: true,
 	}))
 
-	// Static files for uploads
-	app.Static("/uploads", "./uploads")
-
 	// Initialize handlers
-	projectHandler := handlers.NewProjectHandler(db)
-	fileHandler := handlers.NewFileHandler(db)
-	notificationHandler := handlers.NewNotificationHandler(db)
-	adminHandler := handlers.NewAdminHandler(db)
-	advisorStudentHandler := handlers.NewAdvisorStudentHandler(db)
-	chatHandler := handlers.NewChatHandler(db)
+	projectHandler := handlers.NewProjectHandler(db, settingsService)
+	fileHandler := handlers.NewFileHandler(db, store, scanner, settingsService, previewWorker, similarityChecker, notifier, eventBus)
+	notificationHandler := handlers.NewNotificationHandler(db, hub, notifier)
+	adminHandler := handlers.NewAdminHandler(db, store)
+	advisorStudentHandler := handlers.NewAdvisorStudentHandler(db, eventBus)
+	chatHandler := handlers.NewChatHandler(db, hub, eventBus)
+	settingsHandler := handlers.NewSettingsHandler(settingsService)
+	uploadSessionHandler := handlers.NewUploadSessionHandler(db, fileHandler)
+	exportHandler := handlers.NewExportHandler(db, store)
+	showcaseHandler := handlers.NewShowcaseHandler(db, fileHandler)
+	storageHandler := handlers.NewStorageHandler(db, settingsService, storagegc.NewReconciler(db, store))
+	announcementWorker := notify.NewAnnouncementWorker(db, notifier)
+	announcementHandler := handlers.NewAnnouncementHandler(db, announcementWorker)
+	pushHandler := handlers.NewPushHandler(db, pushSender, getEnv("WEBPUSH_ALLOW_HTTP", "false") == "true")
+
+	// Expire abandoned resumable uploads
+	go uploadSessionHandler.RunCleanup(context.Background(), 15*time.Minute)
+
+	// Generate previews for new uploads
+	if previewWorker != nil {
+		go previewWorker.Run(context.Background(), time.Minute)
+	}
+	if similarityChecker != nil {
+		go similarityChecker.Run(context.Background(), time.Minute)
+	}
+
+	if emailWorker != nil {
+		go emailWorker.Run(context.Background(), time.Minute)
+	}
+	if pushWorker != nil {
+		go pushWorker.Run(context.Background(), time.Minute)
+	}
+
+	// Remind students and advisors of approaching expected end dates
+	go notify.NewDeadlineScheduler(db, eventBus).Run(context.Background(), time.Hour)
+
+	// Fan out announcements when their scheduled time comes
+	go announcementWorker.Run(context.Background(), time.Minute)
 
 	// Root route
 	app.Get("/", func(c *fiber.Ctx) error {
@@ -88,6 +222,14 @@ func main() {
 
 	// Public endpoints
 	app.Get("/api/advisors", getAdvisorsHandler)
+	// Uploaded files are never served statically; signed links are checked by the handler
+	app.Get("/api/files/:id/signed-download", fileHandler.SignedDownload)
+
+	// Public project showcase; signed-in project members may also preview unpublished entries
+	showcase := app.Group("/api/showcase", middlewares.OptionalJWTMiddleware)
+	showcase.Get("/", showcaseHandler.SearchShowcase)
+	showcase.Get("/:id", showcaseHandler.GetShowcaseProject)
+	showcase.Get("/:id/files/:fileId/download", showcaseHandler.DownloadShowcaseFile)
 
 	// Protected endpoints (require authentication)
 	protected := app.Group("/api")
@@ -98,13 +240,20 @@ func main() {
 	protected.Post("/projects", projectHandler.CreateProject)
 	protected.Get("/projects/:id", projectHandler.GetProject)
 	protected.Get("/projects/:id/files", projectHandler.GetProjectFiles)
+	protected.Get("/projects/:id/export", exportHandler.ExportProjectFiles)
+	protected.Get("/projects/:id/storage", storageHandler.GetProjectStorage)
+	protected.Put("/projects/:id/publication", showcaseHandler.UpdatePublication)
 
 	// Advisor endpoints (advisor/admin only)
 	advisorRoutes := protected.Group("/advisors")
 	advisorRoutes.Use(middlewares.AdvisorMiddleware)
 	advisorRoutes.Get("/pending-projects", getPendingProjectsHandler)
+	advisorRoutes.Get("/similarity-flags", fileHandler.GetSimilarityFlags)
+	advisorRoutes.Get("/export", exportHandler.ExportAdvisorCategory)
 	advisorRoutes.Post("/projects/:id/approve", approveProjectHandler)
 	advisorRoutes.Post("/projects/:id/reject", rejectProjectHandler)
+	advisorRoutes.Post("/projects/:id/publish", showcaseHandler.PublishProject)
+	advisorRoutes.Post("/projects/:id/unpublish", showcaseHandler.UnpublishProject)
 
 	// Student management endpoints
 	advisorRoutes.Get("/students", advisorStudentHandler.GetAdvisorStudents)
@@ -123,11 +272,49 @@ func main() {
 	protected.Get("/files/recent", fileHandler.GetRecentFiles)
 	protected.Get("/files/:id", fileHandler.GetFileById)
 	protected.Get("/files/:id/download", fileHandler.DownloadFile)
+	protected.Get("/files/:id/download-link", fileHandler.GetDownloadLink)
+	protected.Get("/files/:id/versions", fileHandler.GetFileVersions)
+	protected.Post("/files/:id/versions", fileHandler.UploadFileVersion)
+	protected.Get("/files/:id/versions/:version/download", fileHandler.DownloadFileVersion)
+	protected.Get("/files/:id/preview", fileHandler.GetFilePreview)
+	protected.Post("/files/:id/preview", fileHandler.RegeneratePreview)
+	protected.Get("/files/:id/thumbnail", fileHandler.GetFileThumbnail)
+	protected.Get("/files/:id/similarity", fileHandler.GetSimilarityReport)
+	protected.Post("/files/:id/similarity", fileHandler.RecheckSimilarity)
 	protected.Patch("/files/:id/review", fileHandler.ReviewFile)
+	protected.Get("/files/:id/reviews", fileHandler.GetFileReviews)
+	protected.Get("/files/:id/comments", fileHandler.GetFileComments)
+	protected.Post("/files/:id/comments", fileHandler.AddFileComment)
+	protected.Patch("/files/:id/comments/:commentId", fileHandler.UpdateFileComment)
+	protected.Get("/upload-policy", settingsHandler.GetUploadPolicy)
+
+	// Resumable (chunked) upload endpoints
+	protected.Post("/projects/:id/uploads", uploadSessionHandler.CreateUploadSession)
+	protected.Get("/uploads/:id", uploadSessionHandler.GetUploadSession)
+	protected.Put("/uploads/:id/chunks/:index", uploadSessionHandler.UploadChunk)
+	protected.Post("/uploads/:id/complete", uploadSessionHandler.CompleteUploadSession)
+	protected.Delete("/uploads/:id", uploadSessionHandler.AbortUploadSession)
 
 	// Notification endpoints
 	protected.Get("/notifications", notificationHandler.GetNotifications)
+	protected.Get("/notifications/counts", notificationHandler.GetCounts)
+	protected.Patch("/notifications/read-all", notificationHandler.MarkAllAsRead)
+	protected.Post("/notifications/bulk", notificationHandler.BulkUpdate)
+	protected.Get("/notifications/preferences", notificationHandler.GetPreferences)
+	protected.Put("/notifications/preferences", notificationHandler.UpdatePreferences)
 	protected.Patch("/notifications/:id/read", notificationHandler.MarkAsRead)
+	protected.Patch("/notifications/:id/unread", notificationHandler.MarkAsUnread)
+	protected.Patch("/notifications/:id/archive", notificationHandler.Archive)
+	protected.Patch("/notifications/:id/unarchive", notificationHandler.Unarchive)
+	protected.Delete("/notifications/:id", notificationHandler.DeleteNotification)
+	protected.Get("/announcements/pinned", announcementHandler.GetPinnedAnnouncements)
+	protected.Get("/push/public-key", pushHandler.GetPublicKey)
+	protected.Get("/push/subscriptions", pushHandler.GetSubscriptions)
+	protected.Post("/push/subscriptions", pushHandler.Subscribe)
+	protected.Delete("/push/subscriptions", pushHandler.Unsubscribe)
+	protected.Post("/push/test", pushHandler.SendTest)
+	protected.Post("/projects/:id/mute", notificationHandler.MuteProject)
+	protected.Delete("/projects/:id/mute", notificationHandler.UnmuteProject)
 
 	// Profile endpoints
 	protected.Get("/profile", profileHandler)
@@ -146,11 +333,26 @@ func main() {
 	adminRoutes.Post("/users/:id/reset-password", adminHandler.ResetPassword)
 	adminRoutes.Get("/projects", adminHandler.GetProjects)
 	adminRoutes.Delete("/projects/:id", adminHandler.DeleteProject)
+	adminRoutes.Get("/export", exportHandler.ExportTerm)
+	adminRoutes.Get("/storage", storageHandler.GetStorageUsage)
+	adminRoutes.Get("/storage/reconcile", storageHandler.ReconcileStorage)
+	adminRoutes.Post("/storage/reconcile", storageHandler.ReconcileStorage)
+	adminRoutes.Get("/notifications/deliveries", notificationHandler.GetDeliveries)
+	adminRoutes.Get("/announcements", announcementHandler.GetAnnouncements)
+	adminRoutes.Post("/announcements", announcementHandler.CreateAnnouncement)
+	adminRoutes.Get("/announcements/:id", announcementHandler.GetAnnouncement)
+	adminRoutes.Put("/announcements/:id", announcementHandler.UpdateAnnouncement)
+	adminRoutes.Post("/announcements/:id/cancel", announcementHandler.CancelAnnouncement)
+	adminRoutes.Get("/settings", settingsHandler.GetSettings)
+	adminRoutes.Put("/settings/:key", settingsHandler.UpdateSetting)
 
 	// Chat REST endpoints (protected)
 	protected.Get("/chats/:project_id/messages", chatHandler.GetChatHistory)
 	protected.Patch("/chats/:project_id/read", chatHandler.MarkAsRead)
 	protected.Get("/chats/unread", chatHandler.GetUnreadCount)
+	protected.Get("/chats/search", chatHandler.SearchMessages)
+	protected.Get("/chats/sync", chatHandler.SyncMessages)
+	protected.Get("/chats/:project_id/read", chatHandler.GetReadState)
 
 	// WebSocket endpoint for real-time chat (with JWT authentication middleware)
 	app.Use("/ws", func(c *fiber.Ctx) error {
@@ -182,9 +384,10 @@ func main() {
 		return c.Next()
 	})
 
-	app.Get("/ws/chat/:project_id", websocket.New(chatHandler.HandleWebSocket, websocket.Config{
+	app.Get("/ws/chat/:project_id", chatHandler.AuthorizeWebSocket, websocket.New(chatHandler.HandleWebSocket, websocket.Config{
 		EnableCompression: true,
 	}))
+	app.Get("/ws/notifications", websocket.New(notificationHandler.HandleWebSocket))
 
 	// Starting server
 	serverPort := getEnv("PORT", "8081")
@@ -360,12 +563,10 @@ func profileHandler(c *fiber.Ctx) error {
 }
 
 func updateProfileHandler(c *fiber.Ctx) error {
-	// Try to get user_id from query parameter first
-	userId := c.Query("user_id")
-
-	// If no user_id provided, use default for testing
+	// Only the authenticated user's own profile is updated
+	userId, _ := c.Locals("user_id").(string)
 	if userId == "" {
-		userId = "41b805d8-f8c0-4af6-974b-84f03aeafdb7"
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
 	}
 
 	var input struct {
@@ -380,6 +581,7 @@ func updateProfileHandler(c *fiber.Ctx) error {
 		Department  string `json:"department"`
 		Faculty     string `json:"faculty"`
 		Year        string `json:"year"`
+		Language    string `json:"language"` // "th" or "en", the language of notification emails
 	}
 
 	if err := c.BodyParser(&input); err != nil {
@@ -412,6 +614,13 @@ func updateProfileHandler(c *fiber.Ctx) error {
 		user.EmployeeID = input.EmployeeID2
 	}
 
+	if input.Language != "" {
+		if input.Language != "th" && input.Language != "en" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "language must be th or en"})
+		}
+		user.Language = input.Language
+	}
+
 	// Save updated user
 	if err := db.Save(&user).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to update profile"})
@@ -504,6 +713,9 @@ func approveProjectHandler(c *fiber.Ctx) error {
 		})
 	}
 
+	actorID, _ := c.Locals("user_id").(string)
+	eventBus.Publish(events.ProjectApproved{ProjectID: projectID, ActorID: actorID, Comment: input.Comment})
+
 	return c.JSON(fiber.Map{
 		"message": "Project approved successfully",
 	})
@@ -549,6 +761,9 @@ func rejectProjectHandler(c *fiber.Ctx) error {
 		})
 	}
 
+	actorID, _ := c.Locals("user_id").(string)
+	eventBus.Publish(events.ProjectRejected{ProjectID: projectID, ActorID: actorID, Comment: input.Comment})
+
 	return c.JSON(fiber.Map{
 		"message": "Project rejected successfully",
 	})
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Who an announcement is sent to
+const (
+	TargetAll      = "all"      // every user
+	TargetRole     = "role"     // users with TargetRole
+	TargetTerm     = "term"     // students and advisors of projects registered in a term
+	TargetAdvisor  = "advisor"  // students of an advisor's projects
+	TargetProjects = "projects" // students and advisors of TargetProjectIDs
+)
+
+// Announcement statuses
+const (
+	AnnouncementScheduled = "scheduled" // waiting for ScheduledAt
+	AnnouncementSending   = "sending"   // being fanned out into notifications
+	AnnouncementSent      = "sent"
+	AnnouncementCancelled = "cancelled"
+)
+
+// Announcement is a message an admin broadcasts to a group of users. Once ScheduledAt has passed it
+// is fanned out into one Notification per recipient, which also record whether it was read.
+type Announcement struct {
+	ID       string `gorm:"type:uuid;primaryKey;default:uuid_generate_v4()" json:"id"`
+	Title    string `gorm:"type:varchar(255);not null" json:"title"`
+	Message  string `gorm:"type:text;not null" json:"message"`
+	Type     string `gorm:"type:varchar(50);default:'info';check:type IN ('info','warning','success','error')" json:"type"`
+	Priority string `gorm:"type:varchar(20);default:'medium';check:priority IN ('low','medium','high')" json:"priority"`
+
+	TargetType         string         `gorm:"type:varchar(20);not null;column:target_type" json:"target_type"`
+	TargetRole         *string        `gorm:"type:varchar(20);column:target_role" json:"target_role,omitempty"`
+	TargetAcademicYear *int           `gorm:"column:target_academic_year" json:"target_academic_year,omitempty"`
+	TargetSemester     *int           `gorm:"column:target_semester" json:"target_semester,omitempty"` // nil for the whole year
+	TargetAdvisorID    *string        `gorm:"type:uuid;column:target_advisor_id" json:"target_advisor_id,omitempty"`
+	TargetProjectIDs   pq.StringArray `gorm:"type:uuid[];column:target_project_ids" json:"target_project_ids,omitempty"`
+
+	// Pinned announcements stay on top of the recipients' dashboards until ExpiresAt
+	Pinned    bool       `gorm:"default:false" json:"pinned"`
+	ExpiresAt *time.Time `gorm:"type:timestamp;column:expires_at" json:"expires_at,omitempty"`
+
+	Status         string     `gorm:"type:varchar(20);default:'scheduled';check:status IN ('scheduled','sending','sent','cancelled')" json:"status"`
+	ScheduledAt    time.Time  `gorm:"type:timestamp;column:scheduled_at" json:"scheduled_at"`
+	SentAt         *time.Time `gorm:"type:timestamp;column:sent_at" json:"sent_at,omitempty"`
+	RecipientCount int        `gorm:"default:0;column:recipient_count" json:"recipient_count"`
+	CreatedBy      string     `gorm:"type:uuid;column:created_by" json:"created_by"`
+	CreatedAt      time.Time  `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:created_at" json:"created_at"`
+	UpdatedAt      time.Time  `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:updated_at" json:"updated_at"`
+
+	// Relationships
+	Creator *User `gorm:"foreignKey:CreatedBy" json:"creator,omitempty"`
+}
+
+func (Announcement) TableName() string {
+	return "announcements"
+}
@@ -22,6 +22,35 @@ type ChatMessage struct {
 	Sender  *User    `json:"sender,omitempty" gorm:"foreignKey:SenderID"`
 }
 
+// MessageRead records that a user has read a chat message
+type MessageRead struct {
+	MessageID uuid.UUID `json:"message_id" gorm:"type:uuid;primaryKey"`
+	UserID    uuid.UUID `json:"user_id" gorm:"type:uuid;primaryKey"`
+	ReadAt    time.Time `json:"read_at" gorm:"default:CURRENT_TIMESTAMP"`
+}
+
+// TableName specifies the table name for MessageRead
+func (MessageRead) TableName() string {
+	return "message_reads"
+}
+
+// ChatReadCursor is the last message a user has read in a project's chat; it only moves forward
+type ChatReadCursor struct {
+	ProjectID        uuid.UUID `json:"project_id" gorm:"type:uuid;primaryKey"`
+	UserID           uuid.UUID `json:"user_id" gorm:"type:uuid;primaryKey"`
+	MessageID        uuid.UUID `json:"message_id" gorm:"type:uuid;not null"`
+	MessageCreatedAt time.Time `json:"message_created_at" gorm:"not null"`
+	ReadAt           time.Time `json:"read_at" gorm:"default:CURRENT_TIMESTAMP"`
+
+	// Relations
+	User *User `json:"user,omitempty" gorm:"foreignKey:UserID"`
+}
+
+// TableName specifies the table name for ChatReadCursor
+func (ChatReadCursor) TableName() string {
+	return "chat_read_cursors"
+}
+
 // ChatMessageInput represents the input structure for WebSocket messages (allows empty ID)
 type ChatMessageInput struct {
 	ID         string `json:"id,omitempty"`
@@ -40,11 +69,12 @@ func (ChatMessage) TableName() string {
 
 // WebSocketMessage represents the real-time message structure
 type WebSocketMessage struct {
-	Type      string           `json:"type"` // "message", "typing", "read", "connected"
+	Type      string           `json:"type"` // "message", "typing", "read", "connected", "error"
 	ProjectID string           `json:"project_id,omitempty"`
 	Message   ChatMessageInput `json:"message,omitempty"` // For incoming messages
 	UserID    string           `json:"user_id,omitempty"`
 	UserName  string           `json:"user_name,omitempty"`
+	Error     string           `json:"error,omitempty"` // Why the client's last message was rejected
 	Timestamp time.Time        `json:"timestamp"`
 }
 
@@ -57,3 +87,13 @@ type WebSocketBroadcast struct {
 	UserName  string       `json:"user_name,omitempty"`
 	Timestamp time.Time    `json:"timestamp"`
 }
+
+// WebSocketReadReceipt tells a project's chat that a user has read up to a message
+type WebSocketReadReceipt struct {
+	Type      string    `json:"type"` // "read_receipt"
+	ProjectID string    `json:"project_id"`
+	UserID    string    `json:"user_id"`
+	UserName  string    `json:"user_name,omitempty"`
+	MessageID string    `json:"message_id"` // the last message read
+	ReadAt    time.Time `json:"read_at"`
+}
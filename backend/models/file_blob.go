@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// BlobPrefix is the storage folder of content-addressed uploads
+const BlobPrefix = "blobs/"
+
+// BlobKey is the storage key of the content with the given SHA-256 (hex).
+// The first two characters fan the blobs out over subfolders.
+func BlobKey(sha256 string) string {
+	return BlobPrefix + sha256[:2] + "/" + sha256
+}
+
+// FileBlob is stored content shared by every project file with the same SHA-256.
+// RefCount is maintained by database triggers on project_files.
+type FileBlob struct {
+	SHA256      string    `gorm:"type:varchar(64);primaryKey;column:sha256" json:"sha256"`
+	StorageKey  string    `gorm:"type:text;not null;column:storage_key" json:"storage_key"`
+	FileSize    *int64    `gorm:"type:bigint;column:file_size" json:"file_size,omitempty"`
+	ContentType string    `gorm:"type:varchar(100);column:content_type" json:"content_type,omitempty"`
+	RefCount    int       `gorm:"not null;default:0;column:ref_count" json:"ref_count"`
+	CreatedAt   time.Time `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:created_at" json:"created_at"`
+	UpdatedAt   time.Time `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:updated_at" json:"updated_at"`
+}
+
+func (FileBlob) TableName() string {
+	return "file_blobs"
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Review decisions; the latest one is also stored in ProjectFile.FileStatus
+const (
+	ReviewApproved          = "approved"
+	ReviewRejected          = "rejected"
+	ReviewRevisionRequested = "revision_requested"
+)
+
+// IsReviewDecision reports whether s is a valid review decision
+func IsReviewDecision(s string) bool {
+	return s == ReviewApproved || s == ReviewRejected || s == ReviewRevisionRequested
+}
+
+// FileReview is one review round of a document. Rounds are numbered per document
+// so the history survives new versions being uploaded.
+type FileReview struct {
+	ID         string    `gorm:"type:uuid;primaryKey;default:uuid_generate_v4()" json:"id"`
+	FileID     string    `gorm:"type:uuid;column:file_id" json:"file_id"`
+	DocumentID string    `gorm:"type:uuid;column:document_id" json:"document_id"`
+	ReviewerID string    `gorm:"type:uuid;column:reviewer_id" json:"reviewer_id"`
+	Round      int       `gorm:"not null" json:"round"`
+	Decision   string    `gorm:"type:varchar(20);not null;check:decision IN ('approved','rejected','revision_requested')" json:"decision"`
+	Comments   string    `gorm:"type:text" json:"comments,omitempty"`
+	CreatedAt  time.Time `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:created_at" json:"created_at"`
+
+	// Relationships
+	File     *ProjectFile `gorm:"foreignKey:FileID" json:"file,omitempty"`
+	Reviewer *User        `gorm:"foreignKey:ReviewerID" json:"reviewer,omitempty"`
+}
+
+func (FileReview) TableName() string {
+	return "file_reviews"
+}
+
+// FileReviewComment is a comment on a file version. Comments with a ParentID are replies;
+// a root comment with a Page is a PDF annotation, optionally anchored to a rectangle
+// given in fractions (0-1) of the page width and height.
+type FileReviewComment struct {
+	ID           string    `gorm:"type:uuid;primaryKey;default:uuid_generate_v4()" json:"id"`
+	FileID       string    `gorm:"type:uuid;column:file_id" json:"file_id"`
+	ReviewID     *string   `gorm:"type:uuid;column:review_id" json:"review_id,omitempty"`
+	ParentID     *string   `gorm:"type:uuid;column:parent_id" json:"parent_id,omitempty"`
+	AuthorID     string    `gorm:"type:uuid;column:author_id" json:"author_id"`
+	Body         string    `gorm:"type:text;not null" json:"body"`
+	Page         *int      `json:"page,omitempty"`
+	AnchorX      *float64  `gorm:"column:anchor_x" json:"anchor_x,omitempty"`
+	AnchorY      *float64  `gorm:"column:anchor_y" json:"anchor_y,omitempty"`
+	AnchorWidth  *float64  `gorm:"column:anchor_width" json:"anchor_width,omitempty"`
+	AnchorHeight *float64  `gorm:"column:anchor_height" json:"anchor_height,omitempty"`
+	Resolved     bool      `gorm:"default:false" json:"resolved"`
+	CreatedAt    time.Time `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:created_at" json:"created_at"`
+	UpdatedAt    time.Time `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:updated_at" json:"updated_at"`
+
+	// Relationships
+	Author  *User               `gorm:"foreignKey:AuthorID" json:"author,omitempty"`
+	Replies []FileReviewComment `gorm:"foreignKey:ParentID" json:"replies,omitempty"`
+}
+
+func (FileReviewComment) TableName() string {
+	return "file_review_comments"
+}
+
+// BeforeSave updates UpdatedAt before saving
+func (c *FileReviewComment) BeforeSave(tx *gorm.DB) (err error) {
+	c.UpdatedAt = time.Now()
+	return nil
+}
@@ -15,8 +15,12 @@ type Notification struct {
 	Priority         string     `gorm:"type:varchar(20);default:'medium';check:priority IN ('low','medium','high')" json:"priority"`
 	IsRead           bool       `gorm:"default:false;column:is_read" json:"is_read"`
 	RelatedProjectID *string    `gorm:"type:uuid;column:related_project_id" json:"related_project_id,omitempty"`
+	EventType        string     `gorm:"type:varchar(50);default:'general';column:event_type" json:"event_type"`
+	Hidden           bool       `gorm:"default:false" json:"-"` // the user turned in-app notifications of this type off
 	SentAt           time.Time  `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:sent_at" json:"sent_at"`
 	ReadAt           *time.Time `gorm:"type:timestamp;column:read_at" json:"read_at,omitempty"`
+	ArchivedAt       *time.Time `gorm:"type:timestamp;column:archived_at" json:"archived_at,omitempty"`
+	AnnouncementID   *string    `gorm:"type:uuid;column:announcement_id" json:"announcement_id,omitempty"`
 	CreatedAt        time.Time  `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:created_at" json:"created_at"`
 
 	// Relationships
@@ -35,3 +39,53 @@ func (n *Notification) BeforeSave(tx *gorm.DB) (err error) {
 	}
 	return nil
 }
+
+// DeadlineReminder records a deadline reminder that was sent, so it is sent only once
+type DeadlineReminder struct {
+	ProjectID  string    `gorm:"type:uuid;primaryKey;column:project_id" json:"project_id"`
+	DueDate    time.Time `gorm:"type:date;primaryKey;column:due_date" json:"due_date"`
+	DaysBefore int       `gorm:"primaryKey;column:days_before" json:"days_before"`
+	SentAt     time.Time `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:sent_at" json:"sent_at"`
+}
+
+func (DeadlineReminder) TableName() string {
+	return "deadline_reminders"
+}
+
+// Delivery channels of notification_deliveries
+const (
+	ChannelEmail   = "email"
+	ChannelWebPush = "webpush"
+)
+
+// Delivery statuses of notification_deliveries
+const (
+	DeliveryPending    = "pending"
+	DeliveryProcessing = "processing"
+	DeliverySent       = "sent"
+	DeliveryFailed     = "failed"  // attempts exhausted or rejected permanently
+	DeliverySkipped    = "skipped" // the channel was disabled or the user cannot receive it
+)
+
+// NotificationDelivery tracks sending one notification through one channel outside the app.
+// Digest deliveries wait until NextAttemptAt and are sent together with the user's other due ones.
+type NotificationDelivery struct {
+	ID             string     `gorm:"type:uuid;primaryKey;default:uuid_generate_v4()" json:"id"`
+	NotificationID string     `gorm:"type:uuid;column:notification_id" json:"notification_id"`
+	UserID         string     `gorm:"type:uuid;column:user_id" json:"user_id"`
+	Channel        string     `gorm:"type:varchar(20);not null" json:"channel"`
+	Status         string     `gorm:"type:varchar(20);default:'pending'" json:"status"`
+	Digest         bool       `gorm:"default:false" json:"digest"`
+	Attempts       int        `gorm:"default:0" json:"attempts"`
+	NextAttemptAt  time.Time  `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:next_attempt_at" json:"next_attempt_at"`
+	LastError      string     `gorm:"type:text;column:last_error" json:"last_error,omitempty"`
+	SentAt         *time.Time `gorm:"type:timestamp;column:sent_at" json:"sent_at,omitempty"`
+	CreatedAt      time.Time  `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:created_at" json:"created_at"`
+	UpdatedAt      time.Time  `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:updated_at" json:"updated_at"`
+
+	Notification *Notification `gorm:"foreignKey:NotificationID" json:"notification,omitempty"`
+}
+
+func (NotificationDelivery) TableName() string {
+	return "notification_deliveries"
+}
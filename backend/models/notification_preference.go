@@ -0,0 +1,99 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Digest frequencies of low-priority notification emails
+const (
+	DigestImmediate = "immediate" // no digest, every notification is emailed on its own
+	DigestDaily     = "daily"
+	DigestWeekly    = "weekly" // Mondays
+)
+
+// ChannelSet selects where notifications of one event type are delivered
+type ChannelSet struct {
+	InApp bool `json:"in_app"`
+	Email bool `json:"email"`
+	Push  bool `json:"push"`
+}
+
+// DefaultChannels is used for event types a user has not configured
+var DefaultChannels = ChannelSet{InApp: true, Email: true, Push: true}
+
+// ChannelPreferences maps an event type (see notify.EventTypes) to its channels; stored as JSONB
+type ChannelPreferences map[string]ChannelSet
+
+func (p ChannelPreferences) Value() (driver.Value, error) {
+	if p == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(p)
+	return string(b), err
+}
+
+func (p *ChannelPreferences) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*p = ChannelPreferences{}
+		return nil
+	case []byte:
+		return json.Unmarshal(v, p)
+	case string:
+		return json.Unmarshal([]byte(v), p)
+	default:
+		return errors.New("models: cannot scan channel preferences")
+	}
+}
+
+// NotificationPreference is how a user wants to be notified. Users without a row get the defaults.
+type NotificationPreference struct {
+	UserID          string             `gorm:"type:uuid;primaryKey;column:user_id" json:"user_id"`
+	Channels        ChannelPreferences `gorm:"type:jsonb;not null;default:'{}'" json:"channels"`
+	QuietHoursStart string             `gorm:"type:varchar(5);column:quiet_hours_start" json:"quiet_hours_start"` // "22:00", Thailand time; empty for none
+	QuietHoursEnd   string             `gorm:"type:varchar(5);column:quiet_hours_end" json:"quiet_hours_end"`
+	DigestFrequency string             `gorm:"type:varchar(10);default:'daily';column:digest_frequency;check:digest_frequency IN ('immediate','daily','weekly')" json:"digest_frequency"`
+	MutedProjectIDs pq.StringArray     `gorm:"type:uuid[];column:muted_project_ids" json:"muted_project_ids"`
+	CreatedAt       time.Time          `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:created_at" json:"created_at"`
+	UpdatedAt       time.Time          `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:updated_at" json:"updated_at"`
+}
+
+func (NotificationPreference) TableName() string {
+	return "notification_preferences"
+}
+
+// DefaultNotificationPreference is what a user who never changed their preferences gets
+func DefaultNotificationPreference(userID string) *NotificationPreference {
+	return &NotificationPreference{
+		UserID:          userID,
+		Channels:        ChannelPreferences{},
+		DigestFrequency: DigestDaily,
+		MutedProjectIDs: pq.StringArray{},
+	}
+}
+
+// ChannelsFor returns the channels of an event type
+func (p *NotificationPreference) ChannelsFor(eventType string) ChannelSet {
+	if set, ok := p.Channels[eventType]; ok {
+		return set
+	}
+	return DefaultChannels
+}
+
+// Muted reports whether the user muted the project
+func (p *NotificationPreference) Muted(projectID *string) bool {
+	if projectID == nil {
+		return false
+	}
+	for _, id := range p.MutedProjectIDs {
+		if id == *projectID {
+			return true
+		}
+	}
+	return false
+}
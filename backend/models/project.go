@@ -8,25 +8,32 @@ import (
 )
 
 type Project struct {
-	ID              string         `gorm:"type:uuid;primaryKey;default:uuid_generate_v4()" json:"id"`
-	StudentID       string         `gorm:"type:uuid;column:student_id" json:"student_id"`
-	AdvisorID       *string        `gorm:"type:uuid;column:advisor_id" json:"advisor_id,omitempty"`
-	Title           string         `gorm:"type:varchar(500);not null" json:"title"`
-	Description     string         `gorm:"type:text" json:"description,omitempty"`
-	Objectives      string         `gorm:"type:text" json:"objectives,omitempty"`
-	Scope           string         `gorm:"type:text" json:"scope,omitempty"`
-	Methodology     string         `gorm:"type:text" json:"methodology,omitempty"`
-	ExpectedOutcome string         `gorm:"type:text;column:expected_outcome" json:"expected_outcome,omitempty"`
-	Keywords        pq.StringArray `gorm:"type:text[]" json:"keywords"`
-	Status          string         `gorm:"type:varchar(20);default:'pending';check:status IN ('pending','approved','rejected','in_progress','completed','cancelled')" json:"status"`
-	AdvisorComment  string         `gorm:"type:text;column:advisor_comment" json:"advisor_comment,omitempty"`
-	ApprovedAt      *time.Time     `gorm:"type:timestamp;column:approved_at" json:"approved_at,omitempty"`
-	StartDate       *time.Time     `gorm:"type:date;column:start_date" json:"start_date,omitempty"`
-	ExpectedEndDate *time.Time     `gorm:"type:date;column:expected_end_date" json:"expected_end_date,omitempty"`
-	ActualEndDate   *time.Time     `gorm:"type:date;column:actual_end_date" json:"actual_end_date,omitempty"`
-	Grade           string         `gorm:"type:varchar(5)" json:"grade,omitempty"`
-	CreatedAt       time.Time      `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:created_at" json:"created_at"`
-	UpdatedAt       time.Time      `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:updated_at" json:"updated_at"`
+	ID               string         `gorm:"type:uuid;primaryKey;default:uuid_generate_v4()" json:"id"`
+	StudentID        string         `gorm:"type:uuid;column:student_id" json:"student_id"`
+	AdvisorID        *string        `gorm:"type:uuid;column:advisor_id" json:"advisor_id,omitempty"`
+	Title            string         `gorm:"type:varchar(500);not null" json:"title"`
+	Description      string         `gorm:"type:text" json:"description,omitempty"`
+	Objectives       string         `gorm:"type:text" json:"objectives,omitempty"`
+	Scope            string         `gorm:"type:text" json:"scope,omitempty"`
+	Methodology      string         `gorm:"type:text" json:"methodology,omitempty"`
+	ExpectedOutcome  string         `gorm:"type:text;column:expected_outcome" json:"expected_outcome,omitempty"`
+	Keywords         pq.StringArray `gorm:"type:text[]" json:"keywords"`
+	Status           string         `gorm:"type:varchar(20);default:'pending';check:status IN ('pending','approved','rejected','in_progress','completed','cancelled')" json:"status"`
+	AdvisorComment   string         `gorm:"type:text;column:advisor_comment" json:"advisor_comment,omitempty"`
+	ApprovedAt       *time.Time     `gorm:"type:timestamp;column:approved_at" json:"approved_at,omitempty"`
+	StartDate        *time.Time     `gorm:"type:date;column:start_date" json:"start_date,omitempty"`
+	ExpectedEndDate  *time.Time     `gorm:"type:date;column:expected_end_date" json:"expected_end_date,omitempty"`
+	ActualEndDate    *time.Time     `gorm:"type:date;column:actual_end_date" json:"actual_end_date,omitempty"`
+	Grade            string         `gorm:"type:varchar(5)" json:"grade,omitempty"`
+	AcademicYear     *int           `gorm:"column:academic_year" json:"academic_year,omitempty"`
+	Semester         *int           `gorm:"type:smallint;check:semester IN (1,2,3)" json:"semester,omitempty"`
+	Abstract         string         `gorm:"type:text" json:"abstract,omitempty"`
+	PublishStatus    string         `gorm:"type:varchar(20);default:'private';column:publish_status;check:publish_status IN ('private','pending','published')" json:"publish_status"`
+	PublishConsentAt *time.Time     `gorm:"type:timestamp;column:publish_consent_at" json:"publish_consent_at,omitempty"`
+	PublishedAt      *time.Time     `gorm:"type:timestamp;column:published_at" json:"published_at,omitempty"`
+	PublishedBy      *string        `gorm:"type:uuid;column:published_by" json:"published_by,omitempty"`
+	CreatedAt        time.Time      `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:created_at" json:"created_at"`
+	UpdatedAt        time.Time      `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:updated_at" json:"updated_at"`
 
 	// Relationships
 	Student      *Student      `gorm:"foreignKey:StudentID" json:"student,omitempty"`
@@ -34,6 +41,13 @@ type Project struct {
 	ProjectFiles []ProjectFile `gorm:"foreignKey:ProjectID" json:"project_files,omitempty"`
 }
 
+// Publish statuses of the public showcase
+const (
+	PublishPrivate   = "private"
+	PublishPending   = "pending" // the student consented; waiting for the advisor
+	PublishPublished = "published"
+)
+
 func (Project) TableName() string {
 	return "projects"
 }
@@ -1,37 +1,102 @@
 package models
 
 import (
+	"path/filepath"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
 )
 
 type ProjectFile struct {
-	ID           string    `gorm:"type:uuid;primaryKey;default:uuid_generate_v4()" json:"id"`
-	ProjectID    string    `gorm:"type:uuid;column:project_id" json:"project_id"`
-	UploadedBy   string    `gorm:"type:uuid;column:uploaded_by" json:"uploaded_by"`
-	FileName     string    `gorm:"type:varchar(255);not null;column:file_name" json:"file_name"`
-	FilePath     string    `gorm:"type:text;not null;column:file_path" json:"file_path"`
-	FileSize     *int64    `gorm:"type:bigint;column:file_size" json:"file_size,omitempty"`
-	FileType     string    `gorm:"type:varchar(100);column:file_type" json:"file_type,omitempty"`
-	FileCategory string    `gorm:"type:varchar(50);column:file_category;check:file_category IN ('proposal','progress_report','final_report','presentation','source_code','other')" json:"file_category"`
-	FileStatus   string    `gorm:"type:varchar(20);default:'pending';column:file_status;check:file_status IN ('pending','approved','rejected')" json:"file_status"`
-	Version      int       `gorm:"default:1" json:"version"`
-	Description  string    `gorm:"type:text" json:"description,omitempty"`
-	IsPublic     bool      `gorm:"default:false;column:is_public" json:"is_public"`
-	CreatedAt    time.Time `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:created_at" json:"created_at"`
-	UpdatedAt    time.Time `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:updated_at" json:"updated_at"`
+	ID                  string     `gorm:"type:uuid;primaryKey;default:uuid_generate_v4()" json:"id"`
+	ProjectID           string     `gorm:"type:uuid;column:project_id" json:"project_id"`
+	DocumentID          string     `gorm:"type:uuid;column:document_id" json:"document_id"`
+	UploadedBy          string     `gorm:"type:uuid;column:uploaded_by" json:"uploaded_by"`
+	FileName            string     `gorm:"type:varchar(255);not null;column:file_name" json:"file_name"`
+	FilePath            string     `gorm:"type:text;not null;column:file_path" json:"file_path"`
+	FileSize            *int64     `gorm:"type:bigint;column:file_size" json:"file_size,omitempty"`
+	FileType            string     `gorm:"type:varchar(100);column:file_type" json:"file_type,omitempty"`
+	FileCategory        string     `gorm:"type:varchar(50);column:file_category;check:file_category IN ('proposal','progress_report','final_report','presentation','source_code','other')" json:"file_category"`
+	FileStatus          string     `gorm:"type:varchar(20);default:'pending';column:file_status;check:file_status IN ('pending','approved','rejected','revision_requested')" json:"file_status"`
+	Version             int        `gorm:"default:1" json:"version"`
+	Description         string     `gorm:"type:text" json:"description,omitempty"`
+	IsPublic            bool       `gorm:"default:false;column:is_public" json:"is_public"`
+	ScanStatus          string     `gorm:"type:varchar(20);default:'not_scanned';column:scan_status;check:scan_status IN ('not_scanned','clean','infected')" json:"scan_status"`
+	ScanResult          string     `gorm:"type:text;column:scan_result" json:"scan_result,omitempty"`
+	ScannedAt           *time.Time `gorm:"type:timestamp;column:scanned_at" json:"scanned_at,omitempty"`
+	PreviewStatus       string     `gorm:"type:varchar(20);default:'none';column:preview_status;check:preview_status IN ('none','pending','processing','ready','failed')" json:"preview_status"`
+	PreviewKey          string     `gorm:"type:text;column:preview_key" json:"-"`
+	ThumbnailKey        string     `gorm:"type:text;column:thumbnail_key" json:"-"`
+	PreviewError        string     `gorm:"type:text;column:preview_error" json:"preview_error,omitempty"`
+	PreviewAttempts     int        `gorm:"default:0;column:preview_attempts" json:"-"`
+	PreviewedAt         *time.Time `gorm:"type:timestamp;column:previewed_at" json:"previewed_at,omitempty"`
+	SimilarityStatus    string     `gorm:"type:varchar(20);default:'none';column:similarity_status;check:similarity_status IN ('none','pending','processing','ready','failed')" json:"similarity_status"`
+	SimilarityScore     *float64   `gorm:"column:similarity_score" json:"similarity_score,omitempty"`
+	SimilarityFlagged   bool       `gorm:"default:false;column:similarity_flagged" json:"similarity_flagged"`
+	SimilarityError     string     `gorm:"type:text;column:similarity_error" json:"-"`
+	SimilarityAttempts  int        `gorm:"default:0;column:similarity_attempts" json:"-"`
+	SimilarityCheckedAt *time.Time `gorm:"type:timestamp;column:similarity_checked_at" json:"similarity_checked_at,omitempty"`
+	SHA256              string     `gorm:"type:varchar(64);column:sha256" json:"sha256,omitempty"`
+	IntegrityStatus     string     `gorm:"type:varchar(20);default:'unverified';column:integrity_status;check:integrity_status IN ('unverified','ok','corrupt','missing')" json:"integrity_status"`
+	VerifiedAt          *time.Time `gorm:"type:timestamp;column:verified_at" json:"verified_at,omitempty"`
+	CreatedAt           time.Time  `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:created_at" json:"created_at"`
+	UpdatedAt           time.Time  `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:updated_at" json:"updated_at"`
 
 	// Relationships
 	Project *Project `gorm:"foreignKey:ProjectID" json:"project,omitempty"`
 	User    *User    `gorm:"foreignKey:UploadedBy" json:"user,omitempty"`
 }
 
+// LatestVersionCondition restricts a project_files query to the newest version of each document
+const LatestVersionCondition = "project_files.version = (SELECT MAX(pf.version) FROM project_files pf WHERE pf.document_id = project_files.document_id)"
+
 // TableName specifies the table name
 func (ProjectFile) TableName() string {
 	return "project_files"
 }
 
+// Preview statuses of a project file
+const (
+	PreviewNone       = "none"
+	PreviewPending    = "pending"
+	PreviewProcessing = "processing"
+	PreviewReady      = "ready"
+	PreviewFailed     = "failed"
+)
+
+// PreviewPrefix is the storage folder generated previews and thumbnails are kept in
+const PreviewPrefix = "previews/"
+
+// Integrity statuses of a project file, set by download verification and the integrity-check command
+const (
+	IntegrityUnverified = "unverified"
+	IntegrityOK         = "ok"
+	IntegrityCorrupt    = "corrupt"
+	IntegrityMissing    = "missing"
+)
+
+// QuarantinePrefix is the storage folder infected uploads are moved to
+const QuarantinePrefix = "quarantine/"
+
+// StorageKey returns the key of the file in the storage backend.
+// Rows written before pluggable storage kept the local path ("uploads/<name>").
+func (pf *ProjectFile) StorageKey() string {
+	return strings.TrimPrefix(filepath.ToSlash(pf.FilePath), "uploads/")
+}
+
+// StoredKeys returns every storage key the file owns: the upload and its generated preview and thumbnail
+func (pf *ProjectFile) StoredKeys() []string {
+	keys := []string{pf.StorageKey()}
+	for _, key := range []string{pf.PreviewKey, pf.ThumbnailKey} {
+		// A PDF is its own preview
+		if key != "" && key != keys[0] {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
 // BeforeSave updates UpdatedAt before saving
 func (pf *ProjectFile) BeforeSave(tx *gorm.DB) (err error) {
 	pf.UpdatedAt = time.Now()
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// PushSubscription is a browser the user allowed to receive Web Push notifications
+type PushSubscription struct {
+	ID            string     `gorm:"type:uuid;primaryKey;default:uuid_generate_v4()" json:"id"`
+	UserID        string     `gorm:"type:uuid;column:user_id" json:"user_id"`
+	Endpoint      string     `gorm:"type:text;uniqueIndex;not null" json:"endpoint"`
+	P256dh        string     `gorm:"type:varchar(100);not null;column:p256dh" json:"-"`
+	Auth          string     `gorm:"type:varchar(50);not null" json:"-"`
+	UserAgent     string     `gorm:"type:text;column:user_agent" json:"user_agent,omitempty"`
+	ExpiresAt     *time.Time `gorm:"type:timestamp;column:expires_at" json:"expires_at,omitempty"` // expirationTime of the subscription, if the browser sets one
+	LastSuccessAt *time.Time `gorm:"type:timestamp;column:last_success_at" json:"last_success_at,omitempty"`
+	CreatedAt     time.Time  `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:created_at" json:"created_at"`
+	UpdatedAt     time.Time  `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:updated_at" json:"updated_at"`
+}
+
+func (PushSubscription) TableName() string {
+	return "push_subscriptions"
+}
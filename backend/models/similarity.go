@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// Similarity check statuses of a project file
+const (
+	SimilarityNone       = "none"
+	SimilarityPending    = "pending"
+	SimilarityProcessing = "processing"
+	SimilarityReady      = "ready"
+	SimilarityFailed     = "failed"
+)
+
+// SimilarityFingerprint is the MinHash signature of one file's text
+type SimilarityFingerprint struct {
+	FileID       string    `gorm:"type:uuid;primaryKey;column:file_id" json:"file_id"`
+	Signature    []byte    `gorm:"type:bytea;not null" json:"-"`
+	ShingleCount int       `gorm:"not null;column:shingle_count" json:"shingle_count"`
+	CreatedAt    time.Time `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:created_at" json:"created_at"`
+}
+
+func (SimilarityFingerprint) TableName() string {
+	return "similarity_fingerprints"
+}
+
+// SimilarityBand is one locality-sensitive hashing bucket of a fingerprint
+type SimilarityBand struct {
+	FileID string `gorm:"type:uuid;primaryKey;column:file_id"`
+	Band   int    `gorm:"primaryKey;autoIncrement:false"`
+	Bucket int64  `gorm:"not null"`
+}
+
+func (SimilarityBand) TableName() string {
+	return "similarity_bands"
+}
+
+// SimilarityMatch records that FileID, when checked, closely matched an earlier MatchedFileID
+type SimilarityMatch struct {
+	FileID        string    `gorm:"type:uuid;primaryKey;column:file_id" json:"file_id"`
+	MatchedFileID string    `gorm:"type:uuid;primaryKey;column:matched_file_id" json:"matched_file_id"`
+	Score         float64   `gorm:"not null" json:"score"` // estimated Jaccard similarity, 0-1
+	CreatedAt     time.Time `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:created_at" json:"created_at"`
+}
+
+func (SimilarityMatch) TableName() string {
+	return "similarity_matches"
+}
@@ -7,12 +7,16 @@ import (
 )
 
 type SystemSetting struct {
-	ID           string    `gorm:"type:uuid;primaryKey;default:uuid_generate_v4()"`
-	SettingKey   string    `gorm:"type:varchar(100);unique;not null"`
-	SettingValue string    `gorm:"type:text"`
-	Description  string    `gorm:"type:text"`
-	CreatedAt    time.Time `gorm:"type:timestamp;autoCreateTime"`
-	UpdatedAt    time.Time `gorm:"type:timestamp;autoUpdateTime"`
+	ID           string    `gorm:"type:uuid;primaryKey;default:uuid_generate_v4()" json:"id"`
+	SettingKey   string    `gorm:"type:varchar(100);unique;not null" json:"setting_key"`
+	SettingValue string    `gorm:"type:text" json:"setting_value"`
+	Description  string    `gorm:"type:text" json:"description"`
+	CreatedAt    time.Time `gorm:"type:timestamp;autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time `gorm:"type:timestamp;autoUpdateTime" json:"updated_at"`
+}
+
+func (SystemSetting) TableName() string {
+	return "system_settings"
 }
 
 // BeforeSave updates UpdatedAt before saving
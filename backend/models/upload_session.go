@@ -0,0 +1,70 @@
+package models
+
+import (
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// UploadSession is a resumable upload that is sent in chunks and assembled on completion
+type UploadSession struct {
+	ID           string     `gorm:"type:uuid;primaryKey;default:uuid_generate_v4()" json:"id"`
+	ProjectID    string     `gorm:"type:uuid;column:project_id" json:"project_id"`
+	UserID       string     `gorm:"type:uuid;column:user_id" json:"user_id"`
+	DocumentID   *string    `gorm:"type:uuid;column:document_id" json:"document_id,omitempty"`
+	FileName     string     `gorm:"type:varchar(255);not null;column:file_name" json:"file_name"`
+	FileCategory string     `gorm:"type:varchar(50);column:file_category" json:"file_category"`
+	Description  string     `gorm:"type:text" json:"description,omitempty"`
+	FileSize     int64      `gorm:"type:bigint;not null;column:file_size" json:"file_size"`
+	ChunkSize    int64      `gorm:"type:bigint;not null;column:chunk_size" json:"chunk_size"`
+	TotalChunks  int        `gorm:"not null;column:total_chunks" json:"total_chunks"`
+	Checksum     string     `gorm:"type:varchar(64)" json:"checksum,omitempty"` // optional SHA-256 of the whole file
+	Status       string     `gorm:"type:varchar(20);default:'active';check:status IN ('active','assembling','completed','aborted','expired','failed')" json:"status"`
+	FileID       *string    `gorm:"type:uuid;column:file_id" json:"file_id,omitempty"`
+	ExpiresAt    time.Time  `gorm:"type:timestamp;not null;column:expires_at" json:"expires_at"`
+	CompletedAt  *time.Time `gorm:"type:timestamp;column:completed_at" json:"completed_at,omitempty"`
+	CreatedAt    time.Time  `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:created_at" json:"created_at"`
+	UpdatedAt    time.Time  `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:updated_at" json:"updated_at"`
+
+	Chunks []UploadChunk `gorm:"foreignKey:SessionID" json:"-"`
+}
+
+func (UploadSession) TableName() string {
+	return "upload_sessions"
+}
+
+// BeforeSave updates UpdatedAt before saving
+func (us *UploadSession) BeforeSave(tx *gorm.DB) (err error) {
+	us.UpdatedAt = time.Now()
+	return nil
+}
+
+// ChunkKey is the storage key of one chunk while the upload is in progress
+func (us *UploadSession) ChunkKey(index int) string {
+	return UploadChunkPrefix + us.ID + "/" + strconv.Itoa(index)
+}
+
+// ChunkLength is the exact number of bytes chunk index must contain
+func (us *UploadSession) ChunkLength(index int) int64 {
+	if index == us.TotalChunks-1 {
+		return us.FileSize - int64(index)*us.ChunkSize
+	}
+	return us.ChunkSize
+}
+
+// UploadChunkPrefix is the storage folder holding chunks of unfinished uploads
+const UploadChunkPrefix = "chunks/"
+
+// UploadChunk records a received and verified chunk
+type UploadChunk struct {
+	SessionID  string    `gorm:"type:uuid;primaryKey;column:session_id" json:"session_id"`
+	ChunkIndex int       `gorm:"primaryKey;column:chunk_index" json:"chunk_index"`
+	Size       int64     `gorm:"type:bigint;not null" json:"size"`
+	SHA256     string    `gorm:"type:varchar(64);not null;column:sha256" json:"sha256"`
+	CreatedAt  time.Time `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:created_at" json:"created_at"`
+}
+
+func (UploadChunk) TableName() string {
+	return "upload_chunks"
+}
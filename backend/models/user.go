@@ -23,6 +23,7 @@ type User struct {
 	PasswordResetToken   string     `gorm:"type:varchar(255);column:password_reset_token" json:"-"`
 	PasswordResetExpires *time.Time `gorm:"type:timestamp;column:password_reset_expires" json:"-"`
 	ProfileImage         string     `gorm:"type:text;column:profile_image" json:"profile_image,omitempty"`
+	Language             string     `gorm:"type:varchar(5);default:'th';check:language IN ('th','en')" json:"language"`
 	CreatedAt            time.Time  `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:created_at" json:"created_at"`
 	UpdatedAt            time.Time  `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;column:updated_at" json:"updated_at"`
 
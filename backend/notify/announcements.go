@@ -0,0 +1,256 @@
+package notify
+
+import (
+	"backend/models"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Recipients returns a query selecting the users.id of everyone an announcement is sent to.
+// For term and projects targets TargetRole, when set, keeps only the students or the advisors.
+func Recipients(db *gorm.DB, a *models.Announcement) (*gorm.DB, error) {
+	query := db.Model(&models.User{}).Select("users.id")
+
+	// Students and advisors of the projects matching where
+	members := func(where string, args ...interface{}) *gorm.DB {
+		students := db.Table("projects").Select("students.user_id").
+			Joins("JOIN students ON students.id = projects.student_id").Where(where, args...)
+		advisors := db.Table("projects").Select("advisors.user_id").
+			Joins("JOIN advisors ON advisors.id = projects.advisor_id").Where(where, args...)
+		return query.Where("users.id IN (?) OR users.id IN (?)", students, advisors)
+	}
+
+	switch a.TargetType {
+	case models.TargetAll:
+		return query, nil
+	case models.TargetRole:
+		if a.TargetRole == nil {
+			return nil, fmt.Errorf("target_role is required")
+		}
+		return query.Where("users.role = ?", *a.TargetRole), nil
+	case models.TargetTerm:
+		if a.TargetAcademicYear == nil {
+			return nil, fmt.Errorf("target_academic_year is required")
+		}
+		if a.TargetSemester != nil {
+			query = members("projects.academic_year = ? AND projects.semester = ?", *a.TargetAcademicYear, *a.TargetSemester)
+		} else {
+			query = members("projects.academic_year = ?", *a.TargetAcademicYear)
+		}
+	case models.TargetAdvisor:
+		if a.TargetAdvisorID == nil {
+			return nil, fmt.Errorf("target_advisor_id is required")
+		}
+		// Students assigned to the advisor, whether or not they registered a project yet
+		return query.Where("users.id IN (?) OR users.id IN (?)",
+			db.Table("students").Select("user_id").Where("advisor_id = ?", *a.TargetAdvisorID),
+			db.Table("projects").Select("students.user_id").
+				Joins("JOIN students ON students.id = projects.student_id").
+				Where("projects.advisor_id = ?", *a.TargetAdvisorID),
+		), nil
+	case models.TargetProjects:
+		if len(a.TargetProjectIDs) == 0 {
+			return nil, fmt.Errorf("target_project_ids is required")
+		}
+		query = members("projects.id IN ?", []string(a.TargetProjectIDs))
+	default:
+		return nil, fmt.Errorf("unknown target_type %q", a.TargetType)
+	}
+
+	if a.TargetRole != nil {
+		query = query.Where("users.role = ?", *a.TargetRole)
+	}
+	return query, nil
+}
+
+// AnnouncementWorker fans announcements whose time has come out into a notification per recipient.
+// Like the email worker it claims announcements with SKIP LOCKED; recipients are processed in
+// batches ordered by user ID and users who already have a copy are skipped, so an announcement
+// left half sent by a crash is finished once it is considered stale.
+type AnnouncementWorker struct {
+	DB       *gorm.DB
+	Notifier *Service
+
+	BatchSize  int
+	StaleAfter time.Duration // sending announcements not updated for this long are assumed abandoned
+
+	wake chan struct{}
+}
+
+func NewAnnouncementWorker(db *gorm.DB, notifier *Service) *AnnouncementWorker {
+	return &AnnouncementWorker{
+		DB:         db,
+		Notifier:   notifier,
+		BatchSize:  500,
+		StaleAfter: 15 * time.Minute,
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// Wake makes Run check for due announcements now, e.g. after one was created for immediate sending
+func (w *AnnouncementWorker) Wake() {
+	if w == nil {
+		return
+	}
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends due announcements every interval, or sooner when woken, until ctx is cancelled
+func (w *AnnouncementWorker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := w.ProcessPending(ctx); err != nil {
+			log.Printf("Failed to send announcements: %v", err)
+		} else if n > 0 {
+			log.Printf("Sent %d announcements", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.wake:
+		}
+	}
+}
+
+// ProcessPending sends every due announcement and returns how many were sent
+func (w *AnnouncementWorker) ProcessPending(ctx context.Context) (int, error) {
+	w.DB.Model(&models.Announcement{}).
+		Where("status = ? AND updated_at < ?", models.AnnouncementSending, time.Now().Add(-w.StaleAfter)).
+		Update("status", models.AnnouncementScheduled)
+
+	sent := 0
+	for ctx.Err() == nil {
+		a, err := w.claim()
+		if err != nil {
+			return sent, err
+		}
+		if a == nil {
+			break
+		}
+		if err := w.send(ctx, a); err != nil {
+			// Left as sending; it is picked up again once stale
+			return sent, fmt.Errorf("announcement %s: %w", a.ID, err)
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// claim marks the earliest due announcement as sending
+func (w *AnnouncementWorker) claim() (*models.Announcement, error) {
+	var claimed *models.Announcement
+	err := w.DB.Transaction(func(tx *gorm.DB) error {
+		var due []models.Announcement
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND scheduled_at <= ?", models.AnnouncementScheduled, time.Now()).
+			Order("scheduled_at").Limit(1).Find(&due).Error; err != nil {
+			return err
+		}
+		if len(due) == 0 {
+			return nil
+		}
+		if err := tx.Model(&due[0]).Update("status", models.AnnouncementSending).Error; err != nil {
+			return err
+		}
+		claimed = &due[0]
+		return nil
+	})
+	return claimed, err
+}
+
+// send creates the notifications of an announcement batch by batch, then marks it sent
+func (w *AnnouncementWorker) send(ctx context.Context, a *models.Announcement) error {
+	last := ""
+	for ctx.Err() == nil {
+		query, err := Recipients(w.DB, a)
+		if err != nil {
+			return err
+		}
+		if last != "" {
+			query = query.Where("users.id > ?", last)
+		}
+		var userIDs []string
+		if err := query.Order("users.id").Limit(w.BatchSize).Pluck("users.id", &userIDs).Error; err != nil {
+			return err
+		}
+		if len(userIDs) == 0 {
+			now := time.Now()
+			return w.DB.Model(a).Updates(map[string]interface{}{
+				"status":  models.AnnouncementSent,
+				"sent_at": now,
+			}).Error
+		}
+		last = userIDs[len(userIDs)-1]
+
+		created, err := w.sendBatch(a, userIDs)
+		if err != nil {
+			return err
+		}
+		// Also refreshes updated_at, which tells ProcessPending the announcement is still being sent
+		if err := w.DB.Model(a).Update("recipient_count", gorm.Expr("recipient_count + ?", created)).Error; err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// sendBatch creates and delivers the notifications of the users who have not got one yet and
+// returns how many were created. Users who turned every channel of announcements off are skipped.
+func (w *AnnouncementWorker) sendBatch(a *models.Announcement, userIDs []string) (int, error) {
+	var done []string
+	if err := w.DB.Model(&models.Notification{}).
+		Where("announcement_id = ? AND user_id IN ?", a.ID, userIDs).
+		Pluck("user_id", &done).Error; err != nil {
+		return 0, err
+	}
+	skip := make(map[string]bool, len(done))
+	for _, id := range done {
+		skip[id] = true
+	}
+
+	prefs, err := w.Notifier.preferencesOf(userIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	var notifications []*models.Notification
+	for _, userID := range userIDs {
+		channels := prefs[userID].ChannelsFor(TypeAnnouncement)
+		if skip[userID] || (!channels.InApp && !channels.Email && !channels.Push) {
+			continue
+		}
+		notifications = append(notifications, &models.Notification{
+			UserID:         userID,
+			Title:          a.Title,
+			Message:        a.Message,
+			Type:           a.Type,
+			Priority:       a.Priority,
+			EventType:      TypeAnnouncement,
+			Hidden:         !channels.InApp,
+			AnnouncementID: &a.ID,
+		})
+	}
+	if len(notifications) == 0 {
+		return 0, nil
+	}
+
+	if err := w.DB.Create(&notifications).Error; err != nil {
+		return 0, err
+	}
+	for _, n := range notifications {
+		w.Notifier.deliver(n, prefs[n.UserID])
+	}
+	return len(notifications), nil
+}
@@ -0,0 +1,106 @@
+package notify
+
+import (
+	"backend/events"
+	"backend/models"
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DeadlineScheduler publishes DeadlineApproaching for running projects whose expected end date is near
+type DeadlineScheduler struct {
+	DB  *gorm.DB
+	Bus *events.Bus
+
+	// Reminder windows in days before the expected end date. A project entering a window is reminded
+	// once; one that skipped windows, e.g. because its date was set late, gets only the nearest.
+	Days []int
+}
+
+func NewDeadlineScheduler(db *gorm.DB, bus *events.Bus) *DeadlineScheduler {
+	return &DeadlineScheduler{
+		DB:   db,
+		Bus:  bus,
+		Days: []int{7, 1},
+	}
+}
+
+// Run calls Scan every interval until ctx is cancelled
+func (d *DeadlineScheduler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := d.Scan(ctx); err != nil {
+			log.Printf("Failed to check project deadlines: %v", err)
+		} else if n > 0 {
+			log.Printf("Sent %d deadline reminders", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Scan publishes the reminders that are due and returns how many were published
+func (d *DeadlineScheduler) Scan(ctx context.Context) (int, error) {
+	if len(d.Days) == 0 {
+		return 0, nil
+	}
+	days := append([]int(nil), d.Days...)
+	sort.Ints(days)
+
+	var due []struct {
+		ID              string
+		ExpectedEndDate time.Time
+		DaysLeft        int
+	}
+	if err := d.DB.WithContext(ctx).Model(&models.Project{}).
+		Select("id, expected_end_date, expected_end_date - CURRENT_DATE AS days_left").
+		Where("status IN ? AND expected_end_date BETWEEN CURRENT_DATE AND CURRENT_DATE + ?::int",
+			[]string{"approved", "in_progress"}, days[len(days)-1]).
+		Scan(&due).Error; err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, p := range due {
+		// The nearest window the project is in
+		window := days[len(days)-1]
+		for _, w := range days {
+			if p.DaysLeft <= w {
+				window = w
+				break
+			}
+		}
+
+		// Claim the reminder first so parallel instances do not both send it
+		result := d.DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&models.DeadlineReminder{
+			ProjectID:  p.ID,
+			DueDate:    p.ExpectedEndDate,
+			DaysBefore: window,
+		})
+		if result.Error != nil {
+			return sent, result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+
+		d.Bus.Publish(events.DeadlineApproaching{
+			ProjectID: p.ID,
+			DueDate:   p.ExpectedEndDate,
+			DaysLeft:  p.DaysLeft,
+		})
+		sent++
+	}
+	return sent, nil
+}
@@ -0,0 +1,407 @@
+package notify
+
+import (
+	"backend/mailer"
+	"backend/models"
+	"backend/settings"
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var templates = template.Must(template.ParseFS(templateFS, "templates/*.html"))
+
+// bangkok is the time zone of the digest hour and of times shown in emails; Thailand has no DST
+var bangkok = time.FixedZone("ICT", 7*60*60)
+
+const subjectPrefix = "[Project 4101] "
+
+// skipError marks a delivery that will never be sent, e.g. because the user already read it
+type skipError struct {
+	reason string
+}
+
+func (e *skipError) Error() string {
+	return e.reason
+}
+
+// EmailWorker sends notifications by email. Enqueue records a notification_deliveries row per
+// notification; like the preview worker it takes due rows with SKIP LOCKED, so several replicas
+// can run it. Low-priority notifications are held for the user's digest.
+type EmailWorker struct {
+	DB       *gorm.DB
+	Mailer   mailer.Mailer
+	Settings *settings.Service
+	AppURL   string // frontend base URL the emails link to
+
+	MaxAttempts int           // sends tried before a delivery is marked failed
+	RetryBase   time.Duration // wait after the first failure; doubled after every further one
+	RetryMax    time.Duration
+	StaleAfter  time.Duration // processing rows older than this are assumed abandoned
+
+	wake chan struct{}
+}
+
+func NewEmailWorker(db *gorm.DB, m mailer.Mailer, settingsService *settings.Service, appURL string) *EmailWorker {
+	return &EmailWorker{
+		DB:          db,
+		Mailer:      m,
+		Settings:    settingsService,
+		AppURL:      strings.TrimRight(appURL, "/"),
+		MaxAttempts: 8,
+		RetryBase:   time.Minute,
+		RetryMax:    6 * time.Hour,
+		StaleAfter:  15 * time.Minute,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Enabled reports whether notification_email_enabled is on
+func (w *EmailWorker) Enabled() bool {
+	return w.Settings == nil || w.Settings.GetBool(settings.KeyNotificationEmail, true)
+}
+
+// Enqueue records an email delivery of a stored notification and wakes the worker.
+// The send time follows the recipient's digest frequency and quiet hours.
+func (w *EmailWorker) Enqueue(n *models.Notification, prefs *models.NotificationPreference) {
+	if w == nil || !w.Enabled() {
+		return
+	}
+
+	now := time.Now()
+	d := models.NotificationDelivery{
+		NotificationID: n.ID,
+		UserID:         n.UserID,
+		Channel:        models.ChannelEmail,
+		Status:         models.DeliveryPending,
+		NextAttemptAt:  now,
+	}
+	if n.Priority == "low" && prefs.DigestFrequency != models.DigestImmediate {
+		d.Digest = true
+		d.NextAttemptAt = w.nextDigest(now, prefs.DigestFrequency)
+	}
+	d.NextAttemptAt = quietUntil(prefs, d.NextAttemptAt)
+
+	if err := w.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&d).Error; err != nil {
+		log.Printf("Failed to queue email of notification %s: %v", n.ID, err)
+		return
+	}
+	if !d.NextAttemptAt.After(now) {
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// nextDigest returns the next time a daily, or on Mondays weekly, digest is sent after t
+func (w *EmailWorker) nextDigest(t time.Time, frequency string) time.Time {
+	hour := 8
+	if w.Settings != nil {
+		hour = int(w.Settings.GetInt(settings.KeyDigestHour, 8))
+	}
+	local := t.In(bangkok)
+	next := time.Date(local.Year(), local.Month(), local.Day(), hour, 0, 0, 0, bangkok)
+	if !next.After(local) {
+		next = next.AddDate(0, 0, 1)
+	}
+	if frequency == models.DigestWeekly {
+		for next.Weekday() != time.Monday {
+			next = next.AddDate(0, 0, 1)
+		}
+	}
+	return next
+}
+
+// Run sends due emails every interval, or sooner when Enqueue is called, until ctx is cancelled
+func (w *EmailWorker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := w.ProcessPending(ctx); err != nil {
+			log.Printf("Failed to process notification emails: %v", err)
+		} else if n > 0 {
+			log.Printf("Processed %d notification emails", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.wake:
+		}
+	}
+}
+
+// ProcessPending sends every due delivery and digest and returns how many deliveries were processed
+func (w *EmailWorker) ProcessPending(ctx context.Context) (int, error) {
+	w.DB.Model(&models.NotificationDelivery{}).
+		Where("channel = ? AND status = ? AND updated_at < ?", models.ChannelEmail, models.DeliveryProcessing, time.Now().Add(-w.StaleAfter)).
+		Update("status", models.DeliveryPending)
+
+	total := 0
+	for ctx.Err() == nil {
+		deliveries, err := w.claim(false)
+		if err != nil {
+			return total, err
+		}
+		if len(deliveries) == 0 {
+			break
+		}
+		w.finish(deliveries, w.sendOne(ctx, &deliveries[0]))
+		total++
+	}
+
+	for ctx.Err() == nil {
+		deliveries, err := w.claim(true)
+		if err != nil {
+			return total, err
+		}
+		if len(deliveries) == 0 {
+			break
+		}
+		w.sendDigest(ctx, deliveries)
+		total += len(deliveries)
+	}
+	return total, nil
+}
+
+// claim marks due deliveries as processing: a single one, or with digest every due digest
+// delivery of the first user who has one. The deliveries are loaded with their notifications.
+func (w *EmailWorker) claim(digest bool) ([]models.NotificationDelivery, error) {
+	var ids []string
+	err := w.DB.Transaction(func(tx *gorm.DB) error {
+		due := func() *gorm.DB {
+			return tx.Model(&models.NotificationDelivery{}).
+				Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				Where("channel = ? AND status = ? AND digest = ? AND next_attempt_at <= ?",
+					models.ChannelEmail, models.DeliveryPending, digest, time.Now())
+		}
+
+		var first []models.NotificationDelivery
+		if err := due().Order("next_attempt_at").Limit(1).Find(&first).Error; err != nil {
+			return err
+		}
+		if len(first) == 0 {
+			return nil
+		}
+		ids = []string{first[0].ID}
+		if digest {
+			if err := due().Where("user_id = ?", first[0].UserID).Pluck("id", &ids).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Model(&models.NotificationDelivery{}).
+			Where("id IN ?", ids).
+			Updates(map[string]interface{}{
+				"status":   models.DeliveryProcessing,
+				"attempts": gorm.Expr("attempts + 1"),
+			}).Error
+	})
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	var deliveries []models.NotificationDelivery
+	err = w.DB.Preload("Notification.User").
+		Preload("Notification.RelatedProject", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id", "title")
+		}).
+		Where("id IN ?", ids).
+		Order("created_at").
+		Find(&deliveries).Error
+	return deliveries, err
+}
+
+// check returns why a delivery should not be sent, or nil
+func (w *EmailWorker) check(d *models.NotificationDelivery) error {
+	switch {
+	case !w.Enabled():
+		return &skipError{"email notifications are disabled"}
+	case d.Notification == nil:
+		return &skipError{"notification was deleted"}
+	case d.Notification.IsRead:
+		return &skipError{"read in the app before it was emailed"}
+	case d.Notification.User == nil || d.Notification.User.Email == "":
+		return &skipError{"user has no email address"}
+	}
+	return nil
+}
+
+func (w *EmailWorker) sendOne(ctx context.Context, d *models.NotificationDelivery) error {
+	if err := w.check(d); err != nil {
+		return err
+	}
+	n := d.Notification
+
+	data := map[string]interface{}{
+		"Name":         n.User.FullName,
+		"Title":        n.Title,
+		"Message":      n.Message,
+		"ProjectTitle": projectTitle(n),
+		"Link":         w.AppURL + "/notifications",
+	}
+	html, err := render("notification", n.User.Language, data)
+	if err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("%s\n\n%s\n\n%s\n", n.Title, n.Message, data["Link"])
+	return w.Mailer.Send(ctx, mailer.Message{
+		To:      n.User.Email,
+		Subject: subjectPrefix + n.Title,
+		HTML:    html,
+		Text:    text,
+	})
+}
+
+// sendDigest emails one user's due low-priority notifications together.
+// Notifications read in the meantime are left out.
+func (w *EmailWorker) sendDigest(ctx context.Context, deliveries []models.NotificationDelivery) {
+	var included []models.NotificationDelivery
+	for i := range deliveries {
+		if err := w.check(&deliveries[i]); err != nil {
+			w.finish(deliveries[i:i+1], err)
+			continue
+		}
+		included = append(included, deliveries[i])
+	}
+	if len(included) == 0 {
+		return
+	}
+
+	user := included[0].Notification.User
+	type item struct {
+		Title, Message, ProjectTitle, Time string
+	}
+	items := make([]item, len(included))
+	var text strings.Builder
+	for i, d := range included {
+		n := d.Notification
+		items[i] = item{
+			Title:        n.Title,
+			Message:      n.Message,
+			ProjectTitle: projectTitle(n),
+			Time:         n.CreatedAt.In(bangkok).Format("2006-01-02 15:04"),
+		}
+		fmt.Fprintf(&text, "- %s: %s\n", n.Title, n.Message)
+	}
+
+	link := w.AppURL + "/notifications"
+	// The frequency is read now, so a digest switched to weekly in the meantime is named as such
+	frequency := models.DigestDaily
+	var prefs []models.NotificationPreference
+	if err := w.DB.Where("user_id = ?", user.ID).Limit(1).Find(&prefs).Error; err != nil {
+		log.Printf("Failed to load notification preferences of %s: %v", user.ID, err)
+	} else if len(prefs) > 0 && prefs[0].DigestFrequency == models.DigestWeekly {
+		frequency = models.DigestWeekly
+	}
+	subject := digestSubject(frequency, user.Language, len(items))
+
+	html, err := render("digest", user.Language, map[string]interface{}{
+		"Name":  user.FullName,
+		"Items": items,
+		"Link":  link,
+	})
+	if err == nil {
+		text.WriteString("\n" + link + "\n")
+		err = w.Mailer.Send(ctx, mailer.Message{
+			To:      user.Email,
+			Subject: subjectPrefix + subject,
+			HTML:    html,
+			Text:    text.String(),
+		})
+	}
+	w.finish(included, err)
+}
+
+// digestSubject names a daily or weekly digest of count notifications in the user's language
+func digestSubject(frequency, language string, count int) string {
+	weekly := frequency == models.DigestWeekly
+	switch {
+	case language == "en" && weekly:
+		return fmt.Sprintf("Weekly notification digest (%d)", count)
+	case language == "en":
+		return fmt.Sprintf("Daily notification digest (%d)", count)
+	case weekly:
+		return fmt.Sprintf("สรุปการแจ้งเตือนประจำสัปดาห์ (%d รายการ)", count)
+	default:
+		return fmt.Sprintf("สรุปการแจ้งเตือนประจำวัน (%d รายการ)", count)
+	}
+}
+
+// finish records the outcome of sending deliveries; failures are retried with exponential backoff
+func (w *EmailWorker) finish(deliveries []models.NotificationDelivery, err error) {
+	for _, d := range deliveries {
+		updates := map[string]interface{}{}
+		var skip *skipError
+		switch {
+		case err == nil:
+			now := time.Now()
+			updates["status"] = models.DeliverySent
+			updates["sent_at"] = &now
+			updates["last_error"] = ""
+		case errors.As(err, &skip):
+			updates["status"] = models.DeliverySkipped
+			updates["last_error"] = skip.reason
+		case mailer.IsPermanent(err) || d.Attempts >= w.MaxAttempts:
+			log.Printf("Giving up on emailing notification %s after %d attempts: %v", d.NotificationID, d.Attempts, err)
+			updates["status"] = models.DeliveryFailed
+			updates["last_error"] = err.Error()
+		default:
+			log.Printf("Failed to email notification %s (attempt %d): %v", d.NotificationID, d.Attempts, err)
+			updates["status"] = models.DeliveryPending
+			updates["last_error"] = err.Error()
+			updates["next_attempt_at"] = time.Now().Add(backoff(w.RetryBase, w.RetryMax, d.Attempts))
+		}
+		if err := w.DB.Model(&models.NotificationDelivery{}).Where("id = ?", d.ID).Updates(updates).Error; err != nil {
+			log.Printf("Failed to record delivery %s: %v", d.ID, err)
+		}
+	}
+}
+
+// backoff is the wait before the next attempt after the given number of failed ones:
+// base, doubled after every further failure, up to limit
+func backoff(base, limit time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+	return delay
+}
+
+// render executes the "<name>_<language>.html" template; anything but English is sent in Thai
+func render(name, language string, data interface{}) (string, error) {
+	if language != "en" {
+		language = "th"
+	}
+	var b bytes.Buffer
+	if err := templates.ExecuteTemplate(&b, name+"_"+language+".html", data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func projectTitle(n *models.Notification) string {
+	if n.RelatedProject == nil {
+		return ""
+	}
+	return n.RelatedProject.Title
+}
@@ -0,0 +1,24 @@
+package notify
+
+import (
+	"backend/models"
+	"testing"
+)
+
+func TestDigestSubject(t *testing.T) {
+	tests := []struct {
+		frequency, language, want string
+	}{
+		{models.DigestDaily, "en", "Daily notification digest (3)"},
+		{models.DigestWeekly, "en", "Weekly notification digest (3)"},
+		{models.DigestDaily, "th", "สรุปการแจ้งเตือนประจำวัน (3 รายการ)"},
+		{models.DigestWeekly, "th", "สรุปการแจ้งเตือนประจำสัปดาห์ (3 รายการ)"},
+		{models.DigestWeekly, "", "สรุปการแจ้งเตือนประจำสัปดาห์ (3 รายการ)"},
+		{models.DigestImmediate, "en", "Daily notification digest (3)"},
+	}
+	for _, tt := range tests {
+		if got := digestSubject(tt.frequency, tt.language, 3); got != tt.want {
+			t.Errorf("digestSubject(%q, %q) = %q, want %q", tt.frequency, tt.language, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package notify
+
+import (
+	"backend/events"
+	"backend/models"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// reviewTypes maps a review decision to the notification shown to the uploader
+var reviewTypes = map[string]string{
+	models.ReviewApproved:          "success",
+	models.ReviewRejected:          "error",
+	models.ReviewRevisionRequested: "warning",
+}
+
+// members are the users taking part in a project
+type members struct {
+	Title         string
+	StudentUserID string
+	AdvisorUserID string
+}
+
+// Subscribe makes the service turn the domain events published on bus into notifications
+func (s *Service) Subscribe(bus *events.Bus) {
+	bus.Subscribe(s.handle)
+}
+
+func (s *Service) handle(e events.Event) {
+	var err error
+	switch e := e.(type) {
+	case events.ProjectApproved:
+		err = s.projectDecided(e.Name(), e.ProjectID, e.ActorID, "Project approved", "%s was approved by your advisor", e.Comment, "success")
+	case events.ProjectRejected:
+		err = s.projectDecided(e.Name(), e.ProjectID, e.ActorID, "Project rejected", "%s was rejected by your advisor", e.Comment, "error")
+	case events.FileUploaded:
+		err = s.fileUploaded(e)
+	case events.FileReviewed:
+		err = s.fileReviewed(e)
+	case events.MessageReceived:
+		err = s.messageReceived(e)
+	case events.DeadlineApproaching:
+		err = s.deadlineApproaching(e)
+	}
+	if err != nil {
+		log.Printf("Failed to create notifications for %s: %v", e.Name(), err)
+	}
+}
+
+// projectMembers loads the title and the student and advisor user IDs of a project
+func (s *Service) projectMembers(projectID string) (*members, error) {
+	var m members
+	err := s.DB.Table("projects").
+		Select("projects.title, COALESCE(students.user_id::text, '') AS student_user_id, COALESCE(advisors.user_id::text, '') AS advisor_user_id").
+		Joins("LEFT JOIN students ON students.id = projects.student_id").
+		Joins("LEFT JOIN advisors ON advisors.id = projects.advisor_id").
+		Where("projects.id = ?", projectID).
+		Take(&m).Error
+	return &m, err
+}
+
+// sendAll stores and pushes a copy of n for every recipient, skipping empty IDs, duplicates and except
+func (s *Service) sendAll(recipients []string, except string, n models.Notification) error {
+	seen := map[string]bool{except: true, "": true}
+	for _, userID := range recipients {
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+
+		notification := n
+		notification.UserID = userID
+		if err := s.Send(&notification); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) projectDecided(eventType, projectID, actorID, title, format, comment, kind string) error {
+	m, err := s.projectMembers(projectID)
+	if err != nil {
+		return err
+	}
+	message := fmt.Sprintf(format, m.Title)
+	if comment = strings.TrimSpace(comment); comment != "" {
+		message += ": " + comment
+	}
+	return s.sendAll([]string{m.StudentUserID}, actorID, models.Notification{
+		Title:            title,
+		Message:          message,
+		Type:             kind,
+		Priority:         "high",
+		RelatedProjectID: &projectID,
+		EventType:        eventType,
+	})
+}
+
+// fileUploaded tells the other members of the project about a new file or version
+func (s *Service) fileUploaded(e events.FileUploaded) error {
+	m, err := s.projectMembers(e.ProjectID)
+	if err != nil {
+		return err
+	}
+	message := fmt.Sprintf("%s was uploaded to %s", e.FileName, m.Title)
+	if e.Version > 1 {
+		message = fmt.Sprintf("%s (version %d) was uploaded to %s", e.FileName, e.Version, m.Title)
+	}
+	return s.sendAll([]string{m.AdvisorUserID, m.StudentUserID}, e.UploaderID, models.Notification{
+		Title:            "New file uploaded",
+		Message:          message,
+		Type:             "info",
+		Priority:         "medium",
+		RelatedProjectID: &e.ProjectID,
+		EventType:        e.Name(),
+	})
+}
+
+// fileReviewed tells the uploader, and the student if someone else uploaded the file, about a review
+func (s *Service) fileReviewed(e events.FileReviewed) error {
+	m, err := s.projectMembers(e.ProjectID)
+	if err != nil {
+		return err
+	}
+	message := fmt.Sprintf("%s (version %d): %s", e.FileName, e.Version, strings.ReplaceAll(e.Decision, "_", " "))
+	if comments := strings.TrimSpace(e.Comments); comments != "" {
+		message += " - " + comments
+	}
+	priority := "medium"
+	if e.Decision != models.ReviewApproved {
+		priority = "high"
+	}
+	return s.sendAll([]string{e.UploaderID, m.StudentUserID}, e.ReviewerID, models.Notification{
+		Title:            "File reviewed",
+		Message:          message,
+		Type:             reviewTypes[e.Decision],
+		Priority:         priority,
+		RelatedProjectID: &e.ProjectID,
+		EventType:        e.Name(),
+	})
+}
+
+// messageReceived notifies the other members of a chat. While a recipient still has an unread
+// message notification for the project, no new one is created, so a conversation is one entry.
+// Hidden rows (in-app turned off) can never be read, so they do not hold back later messages.
+func (s *Service) messageReceived(e events.MessageReceived) error {
+	m, err := s.projectMembers(e.ProjectID)
+	if err != nil {
+		return err
+	}
+
+	var recipients []string
+	for _, userID := range []string{m.StudentUserID, m.AdvisorUserID} {
+		if userID == "" || userID == e.SenderID {
+			continue
+		}
+		var pending int64
+		if err := s.DB.Model(&models.Notification{}).
+			Where("user_id = ? AND related_project_id = ? AND event_type = ? AND is_read = ? AND hidden = ?", userID, e.ProjectID, e.Name(), false, false).
+			Count(&pending).Error; err != nil {
+			return err
+		}
+		if pending == 0 {
+			recipients = append(recipients, userID)
+		}
+	}
+
+	text := []rune(strings.TrimSpace(e.Message))
+	if len(text) > 100 {
+		text = append(text[:100], '…')
+	}
+	return s.sendAll(recipients, e.SenderID, models.Notification{
+		Title:            "New message",
+		Message:          fmt.Sprintf("%s in %s: %s", e.SenderName, m.Title, string(text)),
+		Type:             "info",
+		Priority:         "low",
+		RelatedProjectID: &e.ProjectID,
+		EventType:        e.Name(),
+	})
+}
+
+// deadlineApproaching reminds the student and the advisor of the project's expected end date
+func (s *Service) deadlineApproaching(e events.DeadlineApproaching) error {
+	m, err := s.projectMembers(e.ProjectID)
+	if err != nil {
+		return err
+	}
+	message := fmt.Sprintf("%s is due on %s (%d days left)", m.Title, e.DueDate.Format("2006-01-02"), e.DaysLeft)
+	switch e.DaysLeft {
+	case 0:
+		message = fmt.Sprintf("%s is due today", m.Title)
+	case 1:
+		message = fmt.Sprintf("%s is due tomorrow", m.Title)
+	}
+	priority := "medium"
+	if e.DaysLeft <= 1 {
+		priority = "high"
+	}
+	return s.sendAll([]string{m.StudentUserID, m.AdvisorUserID}, "", models.Notification{
+		Title:            "Deadline approaching",
+		Message:          message,
+		Type:             "warning",
+		Priority:         priority,
+		RelatedProjectID: &e.ProjectID,
+		EventType:        e.Name(),
+	})
+}
@@ -0,0 +1,108 @@
+package notify
+
+import (
+	"backend/models"
+	"backend/realtime"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// Publisher delivers a message to the open connections of a room (see realtime.Hub)
+type Publisher interface {
+	Publish(room string, message interface{})
+}
+
+// Event is what a user's notification socket receives
+type Event struct {
+	Type         string               `json:"type"` // "connected", "notification" or "unread_count"
+	Notification *models.Notification `json:"notification,omitempty"`
+	UnreadCount  int64                `json:"unread_count"`
+}
+
+// Service stores notifications, pushes them to the recipient in real time and queues their
+// emails and Web Push messages
+type Service struct {
+	DB        *gorm.DB
+	Publisher Publisher    // nil only stores notifications
+	Email     *EmailWorker // nil sends no email
+	WebPush   *PushWorker  // nil sends no Web Push
+}
+
+func NewService(db *gorm.DB, publisher Publisher, email *EmailWorker, webPush *PushWorker) *Service {
+	return &Service{
+		DB:        db,
+		Publisher: publisher,
+		Email:     email,
+		WebPush:   webPush,
+	}
+}
+
+// Send stores a notification unless the recipient's preferences drop it, and pushes it
+func (s *Service) Send(n *models.Notification) error {
+	created, err := s.Create(s.DB, n)
+	if err != nil || !created {
+		return err
+	}
+	s.Push(n)
+	return nil
+}
+
+// Push delivers notifications that are already stored, e.g. created inside a transaction with
+// Create, through the channels the recipient chose. Call it after the transaction has committed.
+func (s *Service) Push(notifications ...*models.Notification) {
+	if s == nil {
+		return
+	}
+	for _, n := range notifications {
+		if n == nil || n.ID == "" {
+			continue
+		}
+		prefs, err := s.Preferences(n.UserID)
+		if err != nil {
+			log.Printf("Failed to load notification preferences of %s, using defaults: %v", n.UserID, err)
+		}
+		s.deliver(n, prefs)
+	}
+}
+
+// deliver queues the email and push of a stored notification and sends it to the recipient's sockets
+func (s *Service) deliver(n *models.Notification, prefs *models.NotificationPreference) {
+	channels := prefs.ChannelsFor(n.EventType)
+	if channels.Email {
+		s.Email.Enqueue(n, prefs)
+	}
+	if channels.Push {
+		s.WebPush.Enqueue(n, prefs)
+	}
+	if n.Hidden || s.Publisher == nil {
+		return
+	}
+	s.Publisher.Publish(realtime.UserRoom(n.UserID), Event{
+		Type:         "notification",
+		Notification: n,
+		UnreadCount:  s.UnreadCount(n.UserID),
+	})
+}
+
+// PushUnreadCount tells a user's open connections the current unread count, e.g. after marking read
+func (s *Service) PushUnreadCount(userID string) {
+	if s == nil || s.Publisher == nil {
+		return
+	}
+	s.Publisher.Publish(realtime.UserRoom(userID), Event{
+		Type:        "unread_count",
+		UnreadCount: s.UnreadCount(userID),
+	})
+}
+
+// UnreadCount returns how many notifications of the user are unread, leaving out archived ones
+func (s *Service) UnreadCount(userID string) int64 {
+	var count int64
+	if err := s.DB.Model(&models.Notification{}).
+		Where("user_id = ? AND is_read = ? AND hidden = ? AND archived_at IS NULL", userID, false, false).
+		Count(&count).Error; err != nil {
+		log.Printf("Failed to count unread notifications of %s: %v", userID, err)
+	}
+	return count
+}
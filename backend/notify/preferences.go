@@ -0,0 +1,132 @@
+package notify
+
+import (
+	"backend/events"
+	"backend/models"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Event types of notifications that are not created from a domain event
+const (
+	TypeSimilarityFlagged = "similarity.flagged"
+	TypePublication       = "showcase.publication"
+	TypeAnnouncement      = "announcement"
+)
+
+// EventTypes are the notification types users choose channels for
+var EventTypes = []string{
+	events.ProjectApproved{}.Name(),
+	events.ProjectRejected{}.Name(),
+	events.FileUploaded{}.Name(),
+	events.FileReviewed{}.Name(),
+	events.MessageReceived{}.Name(),
+	events.DeadlineApproaching{}.Name(),
+	TypeSimilarityFlagged,
+	TypePublication,
+	TypeAnnouncement,
+}
+
+// IsEventType reports whether t is one of EventTypes
+func IsEventType(t string) bool {
+	for _, known := range EventTypes {
+		if known == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Preferences returns the user's notification preferences, or the defaults when none were saved
+func (s *Service) Preferences(userID string) (*models.NotificationPreference, error) {
+	var prefs []models.NotificationPreference
+	if err := s.DB.Where("user_id = ?", userID).Limit(1).Find(&prefs).Error; err != nil {
+		return models.DefaultNotificationPreference(userID), err
+	}
+	if len(prefs) == 0 {
+		return models.DefaultNotificationPreference(userID), nil
+	}
+	if prefs[0].Channels == nil {
+		prefs[0].Channels = models.ChannelPreferences{}
+	}
+	return &prefs[0], nil
+}
+
+// preferencesOf loads the preferences of several users at once; users without a row get the defaults
+func (s *Service) preferencesOf(userIDs []string) (map[string]*models.NotificationPreference, error) {
+	var rows []models.NotificationPreference
+	if err := s.DB.Where("user_id IN ?", userIDs).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	prefs := make(map[string]*models.NotificationPreference, len(userIDs))
+	for _, id := range userIDs {
+		prefs[id] = models.DefaultNotificationPreference(id)
+	}
+	for i := range rows {
+		if rows[i].Channels == nil {
+			rows[i].Channels = models.ChannelPreferences{}
+		}
+		prefs[rows[i].UserID] = &rows[i]
+	}
+	return prefs, nil
+}
+
+// Create stores n with db, e.g. inside a transaction, following the recipient's preferences.
+// It returns false without storing anything when the recipient muted the project or turned
+// every channel of the event type off. Push the created notification once db has committed.
+func (s *Service) Create(db *gorm.DB, n *models.Notification) (bool, error) {
+	if n.EventType == "" {
+		n.EventType = "general"
+	}
+	prefs, err := s.Preferences(n.UserID)
+	if err != nil {
+		log.Printf("Failed to load notification preferences of %s, using defaults: %v", n.UserID, err)
+	}
+	channels := prefs.ChannelsFor(n.EventType)
+	if prefs.Muted(n.RelatedProjectID) || (!channels.InApp && !channels.Email && !channels.Push) {
+		return false, nil
+	}
+	n.Hidden = !channels.InApp
+	if err := db.Create(n).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ParseClock parses "HH:MM" into minutes after midnight
+func ParseClock(v string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(v, "%d:%d", &h, &m); err != nil || len(v) != 5 || h < 0 || h > 23 || m < 0 || m > 59 {
+		return 0, fmt.Errorf("invalid time %q, use HH:MM", v)
+	}
+	return h*60 + m, nil
+}
+
+// quietUntil returns t, or the end of the user's quiet hours when t falls within them.
+// Quiet hours may span midnight, e.g. 22:00 to 07:00.
+func quietUntil(prefs *models.NotificationPreference, t time.Time) time.Time {
+	if prefs.QuietHoursStart == "" || prefs.QuietHoursEnd == "" {
+		return t
+	}
+	start, err1 := ParseClock(prefs.QuietHoursStart)
+	end, err2 := ParseClock(prefs.QuietHoursEnd)
+	if err1 != nil || err2 != nil || start == end {
+		return t
+	}
+
+	local := t.In(bangkok)
+	now := local.Hour()*60 + local.Minute()
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, bangkok)
+	switch {
+	case start < end && now >= start && now < end:
+		return midnight.Add(time.Duration(end) * time.Minute)
+	case start > end && now >= start:
+		return midnight.AddDate(0, 0, 1).Add(time.Duration(end) * time.Minute)
+	case start > end && now < end:
+		return midnight.Add(time.Duration(end) * time.Minute)
+	}
+	return t
+}
@@ -0,0 +1,276 @@
+package notify
+
+import (
+	"backend/events"
+	"backend/models"
+	"backend/webpush"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PushWorker sends high-priority notifications and chat messages to the users' browsers with
+// Web Push. Deliveries are queued in notification_deliveries like emails, wait for the
+// recipient's quiet hours to end and are retried with backoff; subscriptions the push service
+// reports gone are deleted.
+type PushWorker struct {
+	DB     *gorm.DB
+	Sender *webpush.Sender
+	AppURL string // frontend base URL a click on the notification opens
+
+	MaxAttempts int
+	RetryBase   time.Duration
+	RetryMax    time.Duration
+	StaleAfter  time.Duration
+
+	wake chan struct{}
+}
+
+func NewPushWorker(db *gorm.DB, sender *webpush.Sender, appURL string) *PushWorker {
+	return &PushWorker{
+		DB:          db,
+		Sender:      sender,
+		AppURL:      strings.TrimRight(appURL, "/"),
+		MaxAttempts: 5,
+		RetryBase:   time.Minute,
+		RetryMax:    time.Hour,
+		StaleAfter:  15 * time.Minute,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// pushed reports whether notifications like n are sent with Web Push
+func pushed(n *models.Notification) bool {
+	return n.Priority == "high" || n.EventType == events.MessageReceived{}.Name()
+}
+
+// Enqueue records a push delivery of a stored notification and wakes the worker
+func (w *PushWorker) Enqueue(n *models.Notification, prefs *models.NotificationPreference) {
+	if w == nil || !pushed(n) {
+		return
+	}
+
+	now := time.Now()
+	d := models.NotificationDelivery{
+		NotificationID: n.ID,
+		UserID:         n.UserID,
+		Channel:        models.ChannelWebPush,
+		Status:         models.DeliveryPending,
+		NextAttemptAt:  quietUntil(prefs, now),
+	}
+	if err := w.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&d).Error; err != nil {
+		log.Printf("Failed to queue push of notification %s: %v", n.ID, err)
+		return
+	}
+	if !d.NextAttemptAt.After(now) {
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run sends due pushes every interval, or sooner when Enqueue is called, until ctx is cancelled
+func (w *PushWorker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := w.ProcessPending(ctx); err != nil {
+			log.Printf("Failed to process push notifications: %v", err)
+		} else if n > 0 {
+			log.Printf("Processed %d push notifications", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.wake:
+		}
+	}
+}
+
+// ProcessPending sends every due push and returns how many deliveries were processed.
+// Subscriptions past the expiration time their browser gave are deleted first.
+func (w *PushWorker) ProcessPending(ctx context.Context) (int, error) {
+	if err := w.DB.Where("expires_at < ?", time.Now()).Delete(&models.PushSubscription{}).Error; err != nil {
+		log.Printf("Failed to prune expired push subscriptions: %v", err)
+	}
+	w.DB.Model(&models.NotificationDelivery{}).
+		Where("channel = ? AND status = ? AND updated_at < ?", models.ChannelWebPush, models.DeliveryProcessing, time.Now().Add(-w.StaleAfter)).
+		Update("status", models.DeliveryPending)
+
+	total := 0
+	for ctx.Err() == nil {
+		d, err := w.claim()
+		if err != nil {
+			return total, err
+		}
+		if d == nil {
+			break
+		}
+		w.finish(d, w.send(ctx, d))
+		total++
+	}
+	return total, nil
+}
+
+// claim marks the earliest due push delivery as processing and loads its notification
+func (w *PushWorker) claim() (*models.NotificationDelivery, error) {
+	var id string
+	err := w.DB.Transaction(func(tx *gorm.DB) error {
+		var due []models.NotificationDelivery
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("channel = ? AND status = ? AND next_attempt_at <= ?", models.ChannelWebPush, models.DeliveryPending, time.Now()).
+			Order("next_attempt_at").Limit(1).Find(&due).Error; err != nil {
+			return err
+		}
+		if len(due) == 0 {
+			return nil
+		}
+		id = due[0].ID
+		return tx.Model(&due[0]).Updates(map[string]interface{}{
+			"status":   models.DeliveryProcessing,
+			"attempts": gorm.Expr("attempts + 1"),
+		}).Error
+	})
+	if err != nil || id == "" {
+		return nil, err
+	}
+
+	var d models.NotificationDelivery
+	err = w.DB.Preload("Notification.User").First(&d, "id = ?", id).Error
+	return &d, err
+}
+
+// pushPayload is the JSON the service worker receives and shows with showNotification
+type pushPayload struct {
+	NotificationID string `json:"notification_id"`
+	Title          string `json:"title"`
+	Body           string `json:"body"`
+	URL            string `json:"url"` // opened when the notification is clicked
+	Tag            string `json:"tag"` // a newer notification with the same tag replaces the shown one
+}
+
+// send pushes a delivery to every subscription of the user. It succeeds when any of them
+// accepted the push; subscriptions that are gone are deleted along the way.
+func (w *PushWorker) send(ctx context.Context, d *models.NotificationDelivery) error {
+	n := d.Notification
+	switch {
+	case n == nil:
+		return &skipError{"notification was deleted"}
+	case n.IsRead:
+		return &skipError{"read in the app before it was pushed"}
+	}
+
+	var subs []models.PushSubscription
+	if err := w.DB.Where("user_id = ?", d.UserID).Find(&subs).Error; err != nil {
+		return err
+	}
+	if len(subs) == 0 {
+		return &skipError{"user has no push subscriptions"}
+	}
+
+	msg := w.message(n)
+	var failure error
+	delivered := false
+	for _, sub := range subs {
+		err := w.Sender.Send(ctx, webpush.Subscription{
+			Endpoint: sub.Endpoint,
+			Keys:     webpush.Keys{P256dh: sub.P256dh, Auth: sub.Auth},
+		}, msg)
+		switch {
+		case err == nil:
+			delivered = true
+			w.DB.Model(&sub).Update("last_success_at", time.Now())
+		case webpush.IsGone(err):
+			if err := w.DB.Delete(&sub).Error; err != nil {
+				log.Printf("Failed to delete push subscription %s: %v", sub.ID, err)
+			}
+		default:
+			log.Printf("Failed to push notification %s to subscription %s: %v", n.ID, sub.ID, err)
+			// A temporary failure of any subscription is worth a retry
+			if failure == nil || webpush.IsPermanent(failure) {
+				failure = err
+			}
+		}
+	}
+
+	switch {
+	case delivered:
+		return nil
+	case failure == nil:
+		return &skipError{"every push subscription of the user has expired"}
+	}
+	return failure
+}
+
+// message builds the push of a notification; chat pushes of a project share a topic and a tag,
+// so only the latest is shown
+func (w *PushWorker) message(n *models.Notification) webpush.Message {
+	payload := pushPayload{
+		NotificationID: n.ID,
+		Title:          n.Title,
+		Body:           n.Message,
+		URL:            w.AppURL + "/notifications",
+		Tag:            n.ID,
+	}
+	msg := webpush.Message{TTL: 24 * time.Hour, Urgency: webpush.UrgencyHigh}
+
+	if n.EventType == (events.MessageReceived{}).Name() && n.RelatedProjectID != nil {
+		projectID := *n.RelatedProjectID
+		payload.URL = w.AppURL + "/student/projects/" + projectID + "/chat"
+		if n.User != nil && n.User.Role == "advisor" {
+			payload.URL = w.AppURL + "/advisor/chat/" + projectID
+		}
+		payload.Tag = "chat-" + projectID
+		msg.TTL = time.Hour
+		msg.Urgency = webpush.UrgencyNormal
+		msg.Topic = strings.ReplaceAll(projectID, "-", "")
+	}
+
+	// Long messages are cut so the encrypted payload fits in one record
+	for {
+		b, _ := json.Marshal(payload)
+		if len(b) <= webpush.MaxPayload {
+			msg.Payload = b
+			return msg
+		}
+		body := []rune(payload.Body)
+		payload.Body = string(body[:len(body)*3/4]) + "…"
+	}
+}
+
+// finish records the outcome of a push; temporary failures are retried with backoff
+func (w *PushWorker) finish(d *models.NotificationDelivery, err error) {
+	updates := map[string]interface{}{}
+	var skip *skipError
+	switch {
+	case err == nil:
+		now := time.Now()
+		updates["status"] = models.DeliverySent
+		updates["sent_at"] = &now
+		updates["last_error"] = ""
+	case errors.As(err, &skip):
+		updates["status"] = models.DeliverySkipped
+		updates["last_error"] = skip.reason
+	case webpush.IsPermanent(err) || d.Attempts >= w.MaxAttempts:
+		log.Printf("Giving up on pushing notification %s after %d attempts: %v", d.NotificationID, d.Attempts, err)
+		updates["status"] = models.DeliveryFailed
+		updates["last_error"] = err.Error()
+	default:
+		updates["status"] = models.DeliveryPending
+		updates["last_error"] = err.Error()
+		updates["next_attempt_at"] = time.Now().Add(backoff(w.RetryBase, w.RetryMax, d.Attempts))
+	}
+	if err := w.DB.Model(&models.NotificationDelivery{}).Where("id = ?", d.ID).Updates(updates).Error; err != nil {
+		log.Printf("Failed to record delivery %s: %v", d.ID, err)
+	}
+}
@@ -0,0 +1,198 @@
+package preview
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// documentExts are the uploads that get a PDF preview and a thumbnail
+var documentExts = map[string]bool{
+	".pdf": true, ".doc": true, ".docx": true, ".ppt": true, ".pptx": true,
+}
+
+// Supported reports whether previews are generated for files with the extension (".docx")
+func Supported(ext string) bool {
+	return documentExts[strings.ToLower(ext)]
+}
+
+// Converter renders documents; paths are local files in a scratch directory owned by the caller
+type Converter interface {
+	// ToPDF converts the office document at src into a PDF written to dst. It is not called for PDFs.
+	ToPDF(ctx context.Context, src, dst string) error
+	// Thumbnail renders the first page of the PDF at src as a PNG written to dst
+	Thumbnail(ctx context.Context, src, dst string) error
+}
+
+// NewConverterFromEnv returns the converter selected by PREVIEW_CONVERTER:
+// "command" (LibreOffice and poppler binaries), "fake" or "" to disable previews (nil)
+func NewConverterFromEnv() (Converter, error) {
+	switch os.Getenv("PREVIEW_CONVERTER") {
+	case "":
+		return nil, nil
+	case "command":
+		c := NewCommandConverter()
+		if v := os.Getenv("SOFFICE_PATH"); v != "" {
+			c.Soffice = v
+		}
+		if v := os.Getenv("PDFTOPPM_PATH"); v != "" {
+			c.Pdftoppm = v
+		}
+		return c, nil
+	case "fake":
+		return &FakeConverter{}, nil
+	default:
+		return nil, fmt.Errorf("preview: unknown converter %q", os.Getenv("PREVIEW_CONVERTER"))
+	}
+}
+
+// CommandConverter shells out to LibreOffice (soffice) and poppler (pdftoppm)
+type CommandConverter struct {
+	Soffice        string
+	Pdftoppm       string
+	Timeout        time.Duration // per conversion
+	ThumbnailWidth int           // pixels
+}
+
+func NewCommandConverter() *CommandConverter {
+	return &CommandConverter{
+		Soffice:        "soffice",
+		Pdftoppm:       "pdftoppm",
+		Timeout:        2 * time.Minute,
+		ThumbnailWidth: 320,
+	}
+}
+
+func (c *CommandConverter) ToPDF(ctx context.Context, src, dst string) error {
+	outDir, err := os.MkdirTemp(filepath.Dir(dst), "soffice-out-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(outDir)
+
+	// Every run gets its own profile; soffice refuses to start twice on a shared one
+	profile, err := os.MkdirTemp(filepath.Dir(dst), "soffice-profile-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(profile)
+
+	err = c.run(ctx, c.Soffice,
+		"--headless", "--norestore", "--nolockcheck",
+		"-env:UserInstallation=file://"+filepath.ToSlash(profile),
+		"--convert-to", "pdf", "--outdir", outDir, src)
+	if err != nil {
+		return err
+	}
+
+	out := filepath.Join(outDir, strings.TrimSuffix(filepath.Base(src), filepath.Ext(src))+".pdf")
+	if _, err := os.Stat(out); err != nil {
+		return fmt.Errorf("soffice produced no PDF for %s", filepath.Base(src))
+	}
+	return os.Rename(out, dst)
+}
+
+func (c *CommandConverter) Thumbnail(ctx context.Context, src, dst string) error {
+	// pdftoppm appends ".png" to the output root
+	root := strings.TrimSuffix(dst, ".png")
+	err := c.run(ctx, c.Pdftoppm,
+		"-png", "-f", "1", "-l", "1", "-singlefile",
+		"-scale-to", strconv.Itoa(c.ThumbnailWidth),
+		src, root)
+	if err != nil {
+		return err
+	}
+	if root+".png" != dst {
+		return os.Rename(root+".png", dst)
+	}
+	return nil
+}
+
+func (c *CommandConverter) run(ctx context.Context, name string, args ...string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("%s timed out after %s", filepath.Base(name), c.Timeout)
+		}
+		return fmt.Errorf("%s: %v: %s", filepath.Base(name), err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// FakeConverter writes a one-page placeholder PDF and a grey thumbnail.
+// It stands in for LibreOffice in development and tests.
+type FakeConverter struct {
+	Err error // returned instead of converting when set
+}
+
+func (f *FakeConverter) ToPDF(ctx context.Context, src, dst string) error {
+	if f.Err != nil {
+		return f.Err
+	}
+	return os.WriteFile(dst, fakePDF(filepath.Base(src)), 0644)
+}
+
+func (f *FakeConverter) Thumbnail(ctx context.Context, src, dst string) error {
+	if f.Err != nil {
+		return f.Err
+	}
+	img := image.NewGray(image.Rect(0, 0, 160, 226))
+	for i := range img.Pix {
+		img.Pix[i] = 0xe0
+	}
+	for x := 0; x < 160; x++ {
+		img.SetGray(x, 0, color.Gray{Y: 0x80})
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(out, img); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// fakePDF builds a minimal valid PDF whose single page shows title
+func fakePDF(title string) []byte {
+	title = strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`).Replace(title)
+	content := fmt.Sprintf("BT /F1 12 Tf 72 720 Td (Preview of %s) Tj ET", title)
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
@@ -0,0 +1,240 @@
+package preview
+
+import (
+	"backend/models"
+	"backend/storage"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Worker generates previews for project files whose preview_status is pending.
+// Files are claimed with SKIP LOCKED so several backend replicas can share the queue.
+type Worker struct {
+	DB          *gorm.DB
+	Storage     storage.Storage
+	Converter   Converter
+	Concurrency int           // files converted at the same time
+	MaxAttempts int           // conversions tried before a file is marked failed
+	StaleAfter  time.Duration // processing rows older than this are assumed abandoned
+
+	wake chan struct{}
+}
+
+func NewWorker(db *gorm.DB, store storage.Storage, converter Converter) *Worker {
+	return &Worker{
+		DB:          db,
+		Storage:     store,
+		Converter:   converter,
+		Concurrency: 2,
+		MaxAttempts: 3,
+		StaleAfter:  15 * time.Minute,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Enqueue wakes the worker after a file was marked pending
+func (w *Worker) Enqueue() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run processes the queue every interval, or sooner when Enqueue is called, until ctx is cancelled
+func (w *Worker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := w.ProcessPending(ctx); err != nil {
+			log.Printf("Failed to process preview queue: %v", err)
+		} else if n > 0 {
+			log.Printf("Processed %d file previews", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.wake:
+		}
+	}
+}
+
+// ProcessPending converts pending files until the queue is empty and returns how many were processed
+func (w *Worker) ProcessPending(ctx context.Context) (int, error) {
+	// Put back files whose worker died mid-conversion
+	w.DB.Model(&models.ProjectFile{}).
+		Where("preview_status = ? AND updated_at < ?", models.PreviewProcessing, time.Now().Add(-w.StaleAfter)).
+		Update("preview_status", models.PreviewPending)
+
+	total := 0
+	for ctx.Err() == nil {
+		files, err := w.claim(w.Concurrency)
+		if err != nil {
+			return total, err
+		}
+		if len(files) == 0 {
+			break
+		}
+
+		var wg sync.WaitGroup
+		for i := range files {
+			wg.Add(1)
+			go func(f *models.ProjectFile) {
+				defer wg.Done()
+				w.finish(f, w.generate(ctx, f))
+			}(&files[i])
+		}
+		wg.Wait()
+		total += len(files)
+	}
+	return total, nil
+}
+
+// claim marks up to n pending files as processing
+func (w *Worker) claim(n int) ([]models.ProjectFile, error) {
+	var files []models.ProjectFile
+	err := w.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("preview_status = ?", models.PreviewPending).
+			Order("created_at").
+			Limit(n).
+			Find(&files).Error; err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return nil
+		}
+
+		ids := make([]string, len(files))
+		for i, f := range files {
+			ids[i] = f.ID
+		}
+		return tx.Model(&models.ProjectFile{}).
+			Where("id IN ?", ids).
+			Updates(map[string]interface{}{
+				"preview_status":   models.PreviewProcessing,
+				"preview_attempts": gorm.Expr("preview_attempts + 1"),
+			}).Error
+	})
+	return files, err
+}
+
+// finish records the outcome of one conversion
+func (w *Worker) finish(f *models.ProjectFile, err error) {
+	updates := map[string]interface{}{}
+	if err == nil {
+		now := time.Now()
+		updates["preview_status"] = models.PreviewReady
+		updates["preview_key"] = f.PreviewKey
+		updates["thumbnail_key"] = f.ThumbnailKey
+		updates["preview_error"] = ""
+		updates["previewed_at"] = &now
+	} else {
+		log.Printf("Failed to generate preview for file %s: %v", f.ID, err)
+		updates["preview_error"] = err.Error()
+		// f.PreviewAttempts was read before claim incremented it
+		if f.PreviewAttempts+1 >= w.MaxAttempts {
+			updates["preview_status"] = models.PreviewFailed
+		} else {
+			updates["preview_status"] = models.PreviewPending
+		}
+	}
+
+	if err := w.DB.Model(&models.ProjectFile{}).Where("id = ?", f.ID).Updates(updates).Error; err != nil {
+		log.Printf("Failed to save preview status of file %s: %v", f.ID, err)
+	}
+}
+
+// generate converts one file and stores its preview and thumbnail, setting their keys on f
+func (w *Worker) generate(ctx context.Context, f *models.ProjectFile) error {
+	ext := strings.ToLower(filepath.Ext(f.FileName))
+	if !Supported(ext) {
+		return fmt.Errorf("previews are not supported for %s files", ext)
+	}
+	if f.ScanStatus == "infected" {
+		return errors.New("file is quarantined")
+	}
+
+	dir, err := os.MkdirTemp("", "preview-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "source"+ext)
+	if err := w.download(ctx, f.StorageKey(), src); err != nil {
+		return err
+	}
+
+	// PDFs are their own preview; everything else is converted first
+	pdf := src
+	previewKey := f.StorageKey()
+	if ext != ".pdf" {
+		pdf = filepath.Join(dir, "preview.pdf")
+		if err := w.Converter.ToPDF(ctx, src, pdf); err != nil {
+			return err
+		}
+		previewKey = models.PreviewPrefix + f.ID + ".pdf"
+		if err := w.upload(ctx, pdf, previewKey, "application/pdf"); err != nil {
+			return err
+		}
+	}
+
+	thumb := filepath.Join(dir, "thumbnail.png")
+	if err := w.Converter.Thumbnail(ctx, pdf, thumb); err != nil {
+		return err
+	}
+	thumbnailKey := models.PreviewPrefix + f.ID + ".png"
+	if err := w.upload(ctx, thumb, thumbnailKey, "image/png"); err != nil {
+		return err
+	}
+
+	f.PreviewKey = previewKey
+	f.ThumbnailKey = thumbnailKey
+	return nil
+}
+
+func (w *Worker) download(ctx context.Context, key, dst string) error {
+	r, err := w.Storage.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func (w *Worker) upload(ctx context.Context, path, key, contentType string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	return w.Storage.Put(ctx, key, in, info.Size(), contentType)
+}
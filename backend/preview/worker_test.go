@@ -0,0 +1,345 @@
+package preview
+
+import (
+	"backend/models"
+	"backend/storage"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeFiles is just enough of a database for the worker's statements on project_files,
+// including row locks for SELECT ... FOR UPDATE SKIP LOCKED
+type fakeFiles struct {
+	mu       sync.Mutex
+	rows     map[string]map[string]driver.Value
+	lockedBy map[string]*fakeConn
+
+	selectDelay time.Duration // between selecting rows and returning them, so claims overlap
+}
+
+var fileColumns = []string{"id", "file_name", "file_path", "scan_status", "preview_status", "preview_attempts",
+	"preview_key", "thumbnail_key", "preview_error", "created_at", "updated_at"}
+
+func newFakeFiles() *fakeFiles {
+	return &fakeFiles{rows: map[string]map[string]driver.Value{}, lockedBy: map[string]*fakeConn{}}
+}
+
+func (f *fakeFiles) add(id, name, status string, updatedAt time.Time) {
+	f.rows[id] = map[string]driver.Value{
+		"id": id, "file_name": name, "file_path": "uploads/" + id + "-" + name, "scan_status": "clean",
+		"preview_status": status, "preview_attempts": int64(0), "preview_key": "", "thumbnail_key": "",
+		"preview_error": "", "created_at": time.Now(), "updated_at": updatedAt,
+	}
+}
+
+func (f *fakeFiles) get(id string) map[string]driver.Value {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rows[id]
+}
+
+func (f *fakeFiles) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: f}, nil }
+func (f *fakeFiles) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{ db *fakeFiles }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) { return &fakeStmt{c, query}, nil }
+func (c *fakeConn) Close() error                              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)                 { return c, nil }
+func (c *fakeConn) Commit() error                             { c.unlock(); return nil }
+func (c *fakeConn) Rollback() error                           { c.unlock(); return nil }
+
+func (c *fakeConn) unlock() {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	for id, holder := range c.db.lockedBy {
+		if holder == c {
+			delete(c.db.lockedBy, id)
+		}
+	}
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+var (
+	updateStatement = regexp.MustCompile(`^UPDATE "project_files" SET (.+) WHERE (.+)$`)
+	setItem         = regexp.MustCompile(`"(\w+)"=([^,]+)`)
+	placeholder     = regexp.MustCompile(`^\$(\d+)$`)
+)
+
+func arg(args []driver.Value, ref string) driver.Value {
+	var n int
+	fmt.Sscanf(ref, "$%d", &n)
+	return args[n-1]
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	f := s.conn.db
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	m := updateStatement.FindStringSubmatch(s.query)
+	if m == nil {
+		return nil, fmt.Errorf("unexpected statement: %s", s.query)
+	}
+	var match func(row map[string]driver.Value) bool
+	where := m[2]
+	switch {
+	case where == "preview_status = $3 AND updated_at < $4":
+		match = func(row map[string]driver.Value) bool {
+			return row["preview_status"] == args[2] && row["updated_at"].(time.Time).Before(args[3].(time.Time))
+		}
+	case strings.HasPrefix(where, "id IN ("):
+		ids := map[driver.Value]bool{}
+		for _, ref := range strings.Split(strings.TrimSuffix(strings.TrimPrefix(where, "id IN ("), ")"), ",") {
+			ids[arg(args, ref)] = true
+		}
+		match = func(row map[string]driver.Value) bool { return ids[row["id"]] }
+	case strings.HasPrefix(where, "id = "):
+		id := arg(args, strings.TrimPrefix(where, "id = "))
+		match = func(row map[string]driver.Value) bool { return row["id"] == id }
+	default:
+		return nil, fmt.Errorf("unexpected condition: %s", where)
+	}
+
+	var n int64
+	for _, row := range f.rows {
+		if !match(row) {
+			continue
+		}
+		for _, item := range setItem.FindAllStringSubmatch(m[1], -1) {
+			switch value := item[2]; {
+			case placeholder.MatchString(value):
+				row[item[1]] = arg(args, value)
+			case value == "preview_attempts + 1":
+				row[item[1]] = row["preview_attempts"].(int64) + 1
+			default:
+				return nil, fmt.Errorf("unexpected value: %s", value)
+			}
+		}
+		n++
+	}
+	return driver.RowsAffected(n), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows, err := s.selectRows(args)
+	time.Sleep(s.conn.db.selectDelay)
+	return rows, err
+}
+
+func (s *fakeStmt) selectRows(args []driver.Value) (driver.Rows, error) {
+	f := s.conn.db
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if s.query != `SELECT * FROM "project_files" WHERE preview_status = $1 ORDER BY created_at LIMIT $2 FOR UPDATE SKIP LOCKED` {
+		return nil, fmt.Errorf("unexpected query: %s", s.query)
+	}
+	var candidates []map[string]driver.Value
+	for id, row := range f.rows {
+		if holder := f.lockedBy[id]; row["preview_status"] == args[0] && (holder == nil || holder == s.conn) {
+			candidates = append(candidates, row)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i]["created_at"].(time.Time).Before(candidates[j]["created_at"].(time.Time))
+	})
+	if limit := int(args[1].(int64)); len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+
+	rows := &fakeRows{}
+	for _, row := range candidates {
+		f.lockedBy[row["id"].(string)] = s.conn
+		values := make([]driver.Value, len(fileColumns))
+		for i, column := range fileColumns {
+			values[i] = row[column]
+		}
+		rows.values = append(rows.values, values)
+	}
+	return rows, nil
+}
+
+type fakeRows struct{ values [][]driver.Value }
+
+func (r *fakeRows) Columns() []string { return fileColumns }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+// countingConverter counts the conversions of the fake converter
+type countingConverter struct {
+	FakeConverter
+	conversions atomic.Int32
+}
+
+func (c *countingConverter) ToPDF(ctx context.Context, src, dst string) error {
+	c.conversions.Add(1)
+	return c.FakeConverter.ToPDF(ctx, src, dst)
+}
+
+func newTestWorker(t *testing.T, files *fakeFiles, converter Converter) (*Worker, storage.Storage) {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(files)}), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := storage.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewWorker(db, store, converter), store
+}
+
+// putSource stores the upload of a fake file row
+func putSource(t *testing.T, store storage.Storage, files *fakeFiles, id string) {
+	t.Helper()
+	f := models.ProjectFile{FilePath: files.rows[id]["file_path"].(string)}
+	if err := store.Put(context.Background(), f.StorageKey(), strings.NewReader("content"), 7, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWorkerGeneratesPreviews(t *testing.T) {
+	files := newFakeFiles()
+	files.add("doc", "report.docx", models.PreviewPending, time.Now())
+	files.add("pdf", "paper.pdf", models.PreviewPending, time.Now())
+	files.add("done", "old.docx", models.PreviewReady, time.Now())
+	worker, store := newTestWorker(t, files, &FakeConverter{})
+	putSource(t, store, files, "doc")
+	putSource(t, store, files, "pdf")
+
+	n, err := worker.ProcessPending(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("processed %d files, want 2", n)
+	}
+
+	doc := files.get("doc")
+	if doc["preview_status"] != models.PreviewReady || doc["preview_attempts"] != int64(1) ||
+		doc["preview_key"] != "previews/doc.pdf" || doc["thumbnail_key"] != "previews/doc.png" {
+		t.Fatalf("docx row %v", doc)
+	}
+	for _, key := range []string{"previews/doc.pdf", "previews/doc.png", "previews/pdf.png"} {
+		if _, err := store.Stat(context.Background(), key); err != nil {
+			t.Fatalf("%s was not stored: %v", key, err)
+		}
+	}
+	// A PDF is its own preview
+	if pdf := files.get("pdf"); pdf["preview_status"] != models.PreviewReady || pdf["preview_key"] != "pdf-paper.pdf" {
+		t.Fatalf("pdf row %v", pdf)
+	}
+	if done := files.get("done"); done["preview_attempts"] != int64(0) {
+		t.Fatal("a file that was not pending was processed")
+	}
+}
+
+func TestWorkerRetriesThenFails(t *testing.T) {
+	files := newFakeFiles()
+	files.add("missing", "gone.docx", models.PreviewPending, time.Now())
+	files.add("unsupported", "archive.zip", models.PreviewPending, time.Now())
+	worker, _ := newTestWorker(t, files, &FakeConverter{})
+
+	// A failed conversion goes back to pending, so one pass retries it until MaxAttempts
+	if _, err := worker.ProcessPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"missing", "unsupported"} {
+		row := files.get(id)
+		if row["preview_status"] != models.PreviewFailed || row["preview_attempts"] != int64(worker.MaxAttempts) || row["preview_error"] == "" {
+			t.Fatalf("%s row %v", id, row)
+		}
+	}
+
+	// Failed files stay failed
+	if n, _ := worker.ProcessPending(context.Background()); n != 0 {
+		t.Fatalf("processed %d failed files again", n)
+	}
+}
+
+func TestWorkerReclaimsStaleFiles(t *testing.T) {
+	files := newFakeFiles()
+	files.add("stale", "report.docx", models.PreviewProcessing, time.Now().Add(-time.Hour))
+	files.add("busy", "other.docx", models.PreviewProcessing, time.Now())
+	worker, store := newTestWorker(t, files, &FakeConverter{})
+	putSource(t, store, files, "stale")
+
+	if _, err := worker.ProcessPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if files.get("stale")["preview_status"] != models.PreviewReady {
+		t.Fatal("abandoned file was not processed again")
+	}
+	if files.get("busy")["preview_status"] != models.PreviewProcessing {
+		t.Fatal("file another worker is converting was taken over")
+	}
+}
+
+func TestWorkersShareQueue(t *testing.T) {
+	files := newFakeFiles()
+	files.selectDelay = 5 * time.Millisecond
+	converter := &countingConverter{}
+	a, store := newTestWorker(t, files, converter)
+	b, _ := newTestWorker(t, files, converter)
+	b.Storage = store
+
+	const count = 20
+	for i := 0; i < count; i++ {
+		id := fmt.Sprintf("f%02d", i)
+		files.add(id, "report.docx", models.PreviewPending, time.Now())
+		putSource(t, store, files, id)
+	}
+
+	var wg sync.WaitGroup
+	var processed atomic.Int32
+	for _, w := range []*Worker{a, b} {
+		wg.Add(1)
+		go func(w *Worker) {
+			defer wg.Done()
+			n, err := w.ProcessPending(context.Background())
+			if err != nil {
+				t.Error(err)
+			}
+			processed.Add(int32(n))
+		}(w)
+	}
+	wg.Wait()
+
+	if processed.Load() != count || converter.conversions.Load() != count {
+		t.Fatalf("processed %d files with %d conversions, want %d each", processed.Load(), converter.conversions.Load(), count)
+	}
+	for id, row := range files.rows {
+		if row["preview_status"] != models.PreviewReady || row["preview_attempts"] != int64(1) {
+			t.Fatalf("%s row %v", id, row)
+		}
+	}
+}
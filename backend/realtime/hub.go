@@ -0,0 +1,240 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofiber/websocket/v2"
+)
+
+// ProjectRoom is the room of a project's chat
+func ProjectRoom(projectID string) string {
+	return "project:" + projectID
+}
+
+// UserRoom is the room of every connection a user has open, e.g. one per browser tab
+func UserRoom(userID string) string {
+	return "user:" + userID
+}
+
+// Hub keeps the clients of every room and fans published messages out to them. Run never
+// writes to a socket: each client has a buffered send queue drained by its own write pump, and
+// a client whose queue is full is evicted instead of holding up the room. With a PubSub,
+// messages published on any replica reach the room's clients on every replica.
+type Hub struct {
+	WriteWait  time.Duration // for one write to the peer
+	PongWait   time.Duration // without a pong before the connection is considered dead
+	PingPeriod time.Duration // must be shorter than PongWait
+	SendBuffer int           // messages queued per client before it is evicted as too slow
+
+	// Registered clients by room
+	rooms map[string]map[*Client]bool
+
+	broadcast  chan envelope
+	register   chan *Client
+	unregister chan *Client
+
+	// Guards rooms for Count; only Run modifies it
+	mu sync.RWMutex
+
+	pubsub PubSub // nil keeps broadcasts within this process
+}
+
+// Client is one registered connection
+type Client struct {
+	hub  *Hub
+	room string
+	conn *websocket.Conn
+
+	send chan []byte   // closed by Run when the client leaves the room
+	done chan struct{} // closed when the write pump has stopped
+}
+
+type envelope struct {
+	room    string
+	message []byte  // JSON
+	client  *Client // only this client of the room, when set
+}
+
+func NewHub(pubsub PubSub) *Hub {
+	return &Hub{
+		WriteWait:  10 * time.Second,
+		PongWait:   60 * time.Second,
+		PingPeriod: 50 * time.Second,
+		SendBuffer: 64,
+		rooms:      make(map[string]map[*Client]bool),
+		broadcast:  make(chan envelope),
+		register:   make(chan *Client),
+		unregister: make(chan *Client),
+		pubsub:     pubsub,
+	}
+}
+
+// Run serves registrations and broadcasts, including those of other replicas, until the process exits
+func (h *Hub) Run() {
+	if h.pubsub != nil {
+		go h.listen()
+	}
+
+	for {
+		select {
+		case c := <-h.register:
+			h.mu.Lock()
+			if _, ok := h.rooms[c.room]; !ok {
+				h.rooms[c.room] = make(map[*Client]bool)
+			}
+			h.rooms[c.room][c] = true
+			h.mu.Unlock()
+
+		case c := <-h.unregister:
+			h.remove(c)
+
+		case e := <-h.broadcast:
+			var slow []*Client
+			h.mu.RLock()
+			for c := range h.rooms[e.room] {
+				if e.client != nil && e.client != c {
+					continue
+				}
+				select {
+				case c.send <- e.message:
+				default:
+					slow = append(slow, c)
+				}
+			}
+			h.mu.RUnlock()
+
+			for _, c := range slow {
+				log.Printf("Client in %s is not keeping up, dropping connection", e.room)
+				h.remove(c)
+			}
+		}
+	}
+}
+
+// remove drops a client from its room and stops its write pump, which closes the connection
+func (h *Hub) remove(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	clients, ok := h.rooms[c.room]
+	if !ok || !clients[c] {
+		return
+	}
+	delete(clients, c)
+	close(c.send)
+	if len(clients) == 0 {
+		delete(h.rooms, c.room)
+	}
+}
+
+// Register adds a connection to a room and starts writing the messages published afterwards
+// to it. The caller keeps reading from the connection, which also processes the pongs that
+// keep it alive; reads fail once the peer stops answering pings or the client is evicted.
+func (h *Hub) Register(room string, conn *websocket.Conn) *Client {
+	c := &Client{
+		hub:  h,
+		room: room,
+		conn: conn,
+		send: make(chan []byte, h.SendBuffer),
+		done: make(chan struct{}),
+	}
+
+	conn.SetReadDeadline(time.Now().Add(h.PongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(h.PongWait))
+	})
+
+	go c.writePump()
+	h.register <- c
+	return c
+}
+
+// Unregister removes a client from its room and waits for its write pump to close the
+// connection, so the handler can return without a write still in flight
+func (h *Hub) Unregister(c *Client) {
+	h.unregister <- c
+	<-c.done
+}
+
+// writePump writes queued messages and pings to the connection until the client leaves the
+// room or a write fails; closing the connection then ends the caller's reads
+func (c *Client) writePump() {
+	ticker := time.NewTicker(c.hub.PingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+		close(c.done)
+	}()
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(c.hub.WriteWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(c.hub.WriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Publish sends message, encoded as JSON, to every connection in the room on every replica
+func (h *Hub) Publish(room string, message interface{}) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Failed to encode message for %s: %v", room, err)
+		return
+	}
+	h.broadcast <- envelope{room: room, message: data}
+
+	if h.pubsub != nil {
+		if err := h.pubsub.Publish(room, data); err != nil {
+			log.Printf("Failed to relay message for %s to other replicas: %v", room, err)
+		}
+	}
+}
+
+// Send queues message, encoded as JSON, for one client only, e.g. an error about what it sent;
+// it is dropped if the client has left its room
+func (h *Hub) Send(c *Client, message interface{}) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Failed to encode message for %s: %v", c.room, err)
+		return
+	}
+	h.broadcast <- envelope{room: c.room, message: data, client: c}
+}
+
+// listen delivers the messages other replicas publish, listening again after failures
+func (h *Hub) listen() {
+	for {
+		err := h.pubsub.Listen(context.Background(), func(room string, message []byte) {
+			h.broadcast <- envelope{room: room, message: message}
+		})
+		if err == nil {
+			return
+		}
+		log.Printf("Realtime listener failed, retrying: %v", err)
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// Count returns the number of connections in a room on this replica
+func (h *Hub) Count(room string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.rooms[room])
+}
@@ -0,0 +1,249 @@
+package realtime
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	fws "github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+)
+
+// serve runs the hub behind /ws/:room the way the handlers use it: register, read until the
+// connection fails, unregister. registered receives every client, when not nil.
+func serve(t *testing.T, h *Hub, registered chan<- *Client) string {
+	t.Helper()
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/ws/:room", websocket.New(func(c *websocket.Conn) {
+		client := h.Register(c.Params("room"), c)
+		defer h.Unregister(client)
+		if registered != nil {
+			registered <- client
+		}
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	t.Cleanup(func() { app.Shutdown() })
+	return "ws://" + ln.Addr().String()
+}
+
+func dial(t *testing.T, url string) *fws.Conn {
+	t.Helper()
+	conn, _, err := fws.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// waitFor polls cond for up to five seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// readUntilClosed reads from conn until it fails and reports whether that happened within timeout
+func readUntilClosed(conn *fws.Conn, timeout time.Duration) bool {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return !isTimeout(err)
+		}
+	}
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+func newTestHub() *Hub {
+	h := NewHub(nil)
+	go h.Run()
+	return h
+}
+
+func TestHubDeliversToEveryClientInRoom(t *testing.T) {
+	h := newTestHub()
+	url := serve(t, h, nil)
+
+	a, b, other := dial(t, url+"/ws/r"), dial(t, url+"/ws/r"), dial(t, url+"/ws/other")
+	waitFor(t, "registrations", func() bool { return h.Count("r") == 2 && h.Count("other") == 1 })
+
+	h.Publish("r", map[string]string{"text": "hello"})
+	for _, conn := range []*fws.Conn{a, b} {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(message) != `{"text":"hello"}` {
+			t.Fatalf("got %s", message)
+		}
+	}
+
+	other.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, message, err := other.ReadMessage(); err == nil {
+		t.Fatalf("client of another room got %s", message)
+	}
+}
+
+func TestHubEvictsSlowClient(t *testing.T) {
+	h := NewHub(nil)
+	h.SendBuffer = 4
+	h.WriteWait = 200 * time.Millisecond
+	go h.Run()
+	url := serve(t, h, nil)
+
+	// Never reads, so the socket buffers fill, the write pump blocks and the queue backs up
+	slow := dial(t, url+"/ws/r")
+	waitFor(t, "registration", func() bool { return h.Count("r") == 1 })
+
+	big := strings.Repeat("x", 256<<10)
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for i := 0; i < 1000 && h.Count("r") > 0; i++ {
+			h.Publish("r", big)
+		}
+	}()
+
+	select {
+	case <-published:
+	case <-time.After(10 * time.Second):
+		t.Fatal("publishing blocked on a slow client")
+	}
+	if h.Count("r") != 0 {
+		t.Fatal("slow client was not evicted")
+	}
+
+	// The write pump gives up on its blocked write after WriteWait and closes the connection
+	if !readUntilClosed(slow, 10*time.Second) {
+		t.Fatal("connection of the evicted client was not closed")
+	}
+}
+
+func TestHubClosesClientWithoutPongs(t *testing.T) {
+	h := NewHub(nil)
+	h.PongWait = 300 * time.Millisecond
+	h.PingPeriod = 100 * time.Millisecond
+	go h.Run()
+	url := serve(t, h, nil)
+
+	alive := dial(t, url+"/ws/r")
+	silent := dial(t, url+"/ws/r")
+	silent.SetPingHandler(func(string) error { return nil })
+	waitFor(t, "registrations", func() bool { return h.Count("r") == 2 })
+
+	// Reading answers pings with pongs, except on the silent client
+	aliveClosed := make(chan bool, 1)
+	go func() { aliveClosed <- readUntilClosed(alive, time.Second) }()
+
+	if !readUntilClosed(silent, 5*time.Second) {
+		t.Fatal("client that never answers pings was not closed")
+	}
+	if <-aliveClosed {
+		t.Fatal("client answering pings was closed")
+	}
+	waitFor(t, "unregistration", func() bool { return h.Count("r") == 1 })
+}
+
+func TestHubConcurrentRegisterPublishUnregister(t *testing.T) {
+	h := newTestHub()
+	url := serve(t, h, nil)
+
+	const clients, rounds = 50, 5
+	stop := make(chan struct{})
+	var publishers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		publishers.Add(1)
+		go func() {
+			defer publishers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					h.Publish("r", "tick")
+				}
+			}
+		}()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for round := 0; round < rounds; round++ {
+				conn, _, err := fws.DefaultDialer.Dial(url+"/ws/r", nil)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				for n := 0; n < 3; n++ {
+					if _, _, err := conn.ReadMessage(); err != nil {
+						t.Error(err)
+						break
+					}
+				}
+				conn.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	publishers.Wait()
+
+	waitFor(t, "every client to unregister", func() bool { return h.Count("r") == 0 })
+}
+
+func TestHubSendAfterUnregister(t *testing.T) {
+	h := newTestHub()
+	registered := make(chan *Client, 1)
+	url := serve(t, h, registered)
+
+	for i := 0; i < 20; i++ {
+		conn := dial(t, url+"/ws/r")
+		client := <-registered
+
+		// Messages for the client race with it going away; none may hit its closed queue
+		var wg sync.WaitGroup
+		for j := 0; j < 4; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for k := 0; k < 50; k++ {
+					h.Send(client, "direct")
+					h.Publish("r", "room")
+				}
+			}()
+		}
+		conn.Close()
+		wg.Wait()
+
+		waitFor(t, "unregistration", func() bool { return h.Count("r") == 0 })
+		h.Send(client, "after unregister")
+		h.Unregister(client)
+	}
+}
@@ -0,0 +1,154 @@
+package realtime
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// NOTIFY payloads must stay under 8000 bytes; larger messages go through hub_broadcasts
+const maxNotifyPayload = 7000
+
+// Rows of hub_broadcasts are only read right after their notification, so they are kept briefly
+const broadcastRetention = 5 * time.Minute
+
+// NewPubSubFromEnv returns the PubSub selected by REALTIME_PUBSUB: "postgres" (the default),
+// which needs no infrastructure besides the database, or "local" for a single replica (nil)
+func NewPubSubFromEnv(db *sql.DB, dsn string) (PubSub, error) {
+	switch v := os.Getenv("REALTIME_PUBSUB"); v {
+	case "", "postgres":
+		return NewPostgresPubSub(db, dsn), nil
+	case "local":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("realtime: unknown REALTIME_PUBSUB %q", v)
+	}
+}
+
+// PostgresPubSub relays broadcasts between replicas with LISTEN/NOTIFY on one channel.
+// Notifications are not stored, so a replica whose listening connection drops misses the
+// broadcasts sent until it reconnects; clients catch up through the REST endpoints.
+type PostgresPubSub struct {
+	DB      *sql.DB
+	DSN     string // of the dedicated listening connection
+	Channel string
+
+	// How often Listen deletes hub_broadcasts rows older than broadcastRetention
+	CleanupEvery time.Duration
+
+	origin string // this replica; its own notifications are skipped
+}
+
+func NewPostgresPubSub(db *sql.DB, dsn string) *PostgresPubSub {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return &PostgresPubSub{
+		DB:           db,
+		DSN:          dsn,
+		Channel:      "hub_broadcast",
+		CleanupEvery: time.Minute,
+		origin:       hex.EncodeToString(b),
+	}
+}
+
+// pgEnvelope is a NOTIFY payload: the message itself, or the ID of the hub_broadcasts row holding it
+type pgEnvelope struct {
+	Origin  string          `json:"o"`
+	Room    string          `json:"r"`
+	Message json.RawMessage `json:"m,omitempty"`
+	Ref     int64           `json:"ref,omitempty"`
+}
+
+func (p *PostgresPubSub) Publish(room string, message []byte) error {
+	payload, err := json.Marshal(pgEnvelope{Origin: p.origin, Room: room, Message: message})
+	if err != nil {
+		return err
+	}
+	if len(payload) > maxNotifyPayload {
+		var id int64
+		if err := p.DB.QueryRow("INSERT INTO hub_broadcasts (room, message) VALUES ($1, $2) RETURNING id", room, string(message)).Scan(&id); err != nil {
+			return fmt.Errorf("realtime: %w", err)
+		}
+		payload, _ = json.Marshal(pgEnvelope{Origin: p.origin, Room: room, Ref: id})
+	}
+
+	if _, err := p.DB.Exec("SELECT pg_notify($1, $2)", p.Channel, string(payload)); err != nil {
+		return fmt.Errorf("realtime: %w", err)
+	}
+	return nil
+}
+
+func (p *PostgresPubSub) Listen(ctx context.Context, handler func(room string, message []byte)) error {
+	listener := pq.NewListener(p.DSN, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		switch event {
+		case pq.ListenerEventDisconnected:
+			log.Printf("Realtime listener disconnected: %v", err)
+		case pq.ListenerEventReconnected:
+			log.Println("Realtime listener reconnected; broadcasts from other replicas in between were missed")
+		}
+	})
+	defer listener.Close()
+	if err := listener.Listen(p.Channel); err != nil {
+		return fmt.Errorf("realtime: %w", err)
+	}
+
+	// Pinging detects a dead connection that would otherwise wait for notifications forever
+	ping := time.NewTicker(90 * time.Second)
+	defer ping.Stop()
+	cleanup := time.NewTicker(p.CleanupEvery)
+	defer cleanup.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n := <-listener.Notify:
+			// nil after a reconnect
+			if n != nil {
+				p.receive(n.Extra, handler)
+			}
+		case <-ping.C:
+			go listener.Ping()
+		case <-cleanup.C:
+			if err := p.deleteOld(); err != nil {
+				log.Printf("Failed to delete old hub broadcasts: %v", err)
+			}
+		}
+	}
+}
+
+// deleteOld deletes the hub_broadcasts rows older than broadcastRetention
+func (p *PostgresPubSub) deleteOld() error {
+	_, err := p.DB.Exec("DELETE FROM hub_broadcasts WHERE created_at < NOW() - make_interval(secs => $1)", broadcastRetention.Seconds())
+	return err
+}
+
+func (p *PostgresPubSub) receive(payload string, handler func(room string, message []byte)) {
+	var e pgEnvelope
+	if err := json.Unmarshal([]byte(payload), &e); err != nil {
+		log.Printf("Ignoring malformed hub broadcast: %v", err)
+		return
+	}
+	if e.Origin == p.origin {
+		return
+	}
+
+	message := []byte(e.Message)
+	if e.Ref != 0 {
+		var stored string
+		if err := p.DB.QueryRow("SELECT message FROM hub_broadcasts WHERE id = $1", e.Ref).Scan(&stored); err != nil {
+			log.Printf("Failed to load hub broadcast %d: %v", e.Ref, err)
+			return
+		}
+		message = []byte(stored)
+	}
+	handler(e.Room, message)
+}
@@ -0,0 +1,59 @@
+package realtime
+
+import (
+	"context"
+	"sync"
+)
+
+// PubSub carries hub broadcasts between the replicas of the backend, so a message published on
+// one reaches the connections of a room on every other. Messages are JSON.
+type PubSub interface {
+	// Publish sends a message for room to the other replicas
+	Publish(room string, message []byte) error
+	// Listen calls handler with the messages other replicas publish until ctx is cancelled
+	Listen(ctx context.Context, handler func(room string, message []byte)) error
+}
+
+// MemoryBroker connects hubs within one process, e.g. to run several hubs as replicas in tests
+type MemoryBroker struct {
+	mu       sync.RWMutex
+	handlers map[*MemoryPubSub]func(room string, message []byte)
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{handlers: map[*MemoryPubSub]func(string, []byte){}}
+}
+
+// PubSub returns the PubSub of one replica attached to the broker
+func (b *MemoryBroker) PubSub() *MemoryPubSub {
+	return &MemoryPubSub{broker: b}
+}
+
+// MemoryPubSub is one replica's side of a MemoryBroker
+type MemoryPubSub struct {
+	broker *MemoryBroker
+}
+
+func (p *MemoryPubSub) Publish(room string, message []byte) error {
+	p.broker.mu.RLock()
+	defer p.broker.mu.RUnlock()
+	for other, handler := range p.broker.handlers {
+		if other != p {
+			handler(room, message)
+		}
+	}
+	return nil
+}
+
+func (p *MemoryPubSub) Listen(ctx context.Context, handler func(room string, message []byte)) error {
+	p.broker.mu.Lock()
+	p.broker.handlers[p] = handler
+	p.broker.mu.Unlock()
+
+	<-ctx.Done()
+
+	p.broker.mu.Lock()
+	delete(p.broker.handlers, p)
+	p.broker.mu.Unlock()
+	return nil
+}
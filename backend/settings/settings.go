@@ -0,0 +1,298 @@
+package settings
+
+import (
+	"backend/models"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Setting keys read by the backend. Upload keys may be overridden per file category
+// by appending ".<category>", e.g. "max_file_size_mb.source_code".
+const (
+	KeyMaxFileSizeMB    = "max_file_size_mb"
+	KeyAllowedFileTypes = "allowed_file_types"
+	// KeyAcademicYear and KeyCurrentSemester are the term new projects are registered in
+	KeyAcademicYear    = "academic_year"
+	KeyCurrentSemester = "current_semester"
+	// KeySimilarityThreshold is the similarity percentage at which a report is flagged for the advisor
+	KeySimilarityThreshold = "similarity_threshold_percent"
+	// KeyProjectQuotaMB and KeyUserQuotaMB cap the stored bytes of a project and of an uploader (0 = unlimited)
+	KeyProjectQuotaMB = "project_quota_mb"
+	KeyUserQuotaMB    = "user_quota_mb"
+	// KeyNotificationEmail turns email delivery of notifications on or off
+	KeyNotificationEmail = "notification_email_enabled"
+	// KeyDigestHour is the hour (0-23, Thailand time) the daily digest of low-priority notifications is sent
+	KeyDigestHour = "notification_digest_hour"
+)
+
+// Service reads system_settings with a short-lived cache so admins can change
+// settings at runtime; every replica picks up a change within TTL.
+type Service struct {
+	DB  *gorm.DB
+	TTL time.Duration
+	// BodyLimit is the Fiber BodyLimit in bytes; no upload setting may exceed it
+	BodyLimit int64
+
+	mu       sync.RWMutex
+	values   map[string]string
+	loadedAt time.Time
+}
+
+func NewService(db *gorm.DB, bodyLimit int64) *Service {
+	return &Service{
+		DB:        db,
+		TTL:       30 * time.Second,
+		BodyLimit: bodyLimit,
+	}
+}
+
+// UploadPolicy is what UploadFile accepts for one file category
+type UploadPolicy struct {
+	Category    string   `json:"category"`
+	MaxBytes    int64    `json:"max_bytes"`
+	MaxSizeMB   int64    `json:"max_size_mb"`
+	AllowedExts []string `json:"allowed_extensions"`
+}
+
+// Allows reports whether ext (".pdf") is allowed
+func (p UploadPolicy) Allows(ext string) bool {
+	ext = strings.ToLower(ext)
+	for _, allowed := range p.AllowedExts {
+		if allowed == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns a setting value, reloading the cache when it is older than TTL
+func (s *Service) Get(key string) (string, bool) {
+	s.mu.RLock()
+	fresh := s.values != nil && time.Since(s.loadedAt) < s.TTL
+	if fresh {
+		v, ok := s.values[key]
+		s.mu.RUnlock()
+		return v, ok
+	}
+	s.mu.RUnlock()
+
+	if err := s.reload(); err != nil {
+		// Keep serving the previous values if the database is briefly unavailable
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		v, ok := s.values[key]
+		return v, ok
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.values[key]
+	return v, ok
+}
+
+// GetInt returns an integer setting or def when missing or invalid
+func (s *Service) GetInt(key string, def int64) int64 {
+	v, ok := s.Get(key)
+	if !ok {
+		return def
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// GetBool returns a boolean setting or def when missing or invalid
+func (s *Service) GetBool(key string, def bool) bool {
+	v, ok := s.Get(key)
+	if !ok {
+		return def
+	}
+	b, err := strconv.ParseBool(strings.TrimSpace(v))
+	if err != nil {
+		return def
+	}
+	return b
+}
+
+// categoryValue returns "<key>.<category>" when set, otherwise "<key>"
+func (s *Service) categoryValue(key, category string) (string, bool) {
+	if category != "" {
+		if v, ok := s.Get(key + "." + category); ok && strings.TrimSpace(v) != "" {
+			return v, true
+		}
+	}
+	return s.Get(key)
+}
+
+// UploadPolicy returns the size limit and allowed extensions for a file category
+func (s *Service) UploadPolicy(category string) UploadPolicy {
+	policy := UploadPolicy{Category: category, MaxSizeMB: 10}
+
+	if v, ok := s.categoryValue(KeyMaxFileSizeMB, category); ok {
+		if n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil && n > 0 {
+			policy.MaxSizeMB = n
+		}
+	}
+	policy.MaxBytes = policy.MaxSizeMB << 20
+	if s.BodyLimit > 0 && policy.MaxBytes > s.BodyLimit {
+		policy.MaxBytes = s.BodyLimit
+		policy.MaxSizeMB = s.BodyLimit >> 20
+	}
+
+	if v, ok := s.categoryValue(KeyAllowedFileTypes, category); ok {
+		policy.AllowedExts = parseExtensions(v)
+	}
+	return policy
+}
+
+// List returns every setting
+func (s *Service) List() ([]models.SystemSetting, error) {
+	var list []models.SystemSetting
+	err := s.DB.Order("setting_key").Find(&list).Error
+	return list, err
+}
+
+// Set validates and stores a setting, creating it when missing
+func (s *Service) Set(key, value, description string) (*models.SystemSetting, error) {
+	if err := s.validate(key, value); err != nil {
+		return nil, err
+	}
+
+	var setting models.SystemSetting
+	err := s.DB.Where("setting_key = ?", key).First(&setting).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	setting.SettingKey = key
+	setting.SettingValue = value
+	if description != "" {
+		setting.Description = description
+	}
+	if err := s.DB.Save(&setting).Error; err != nil {
+		return nil, err
+	}
+
+	s.Invalidate()
+	return &setting, nil
+}
+
+// CurrentTerm returns the academic year and semester new projects are registered in
+func (s *Service) CurrentTerm() (year int, semester int) {
+	return int(s.GetInt(KeyAcademicYear, 0)), int(s.GetInt(KeyCurrentSemester, 1))
+}
+
+// Quotas returns the project and per-user storage quotas in bytes; 0 means unlimited
+func (s *Service) Quotas() (project int64, user int64) {
+	return s.GetInt(KeyProjectQuotaMB, 0) << 20, s.GetInt(KeyUserQuotaMB, 0) << 20
+}
+
+// Invalidate forces the next Get to reload from the database
+func (s *Service) Invalidate() {
+	s.mu.Lock()
+	s.values = nil
+	s.mu.Unlock()
+}
+
+// ValidationError is returned by Set for values that cannot be accepted
+type ValidationError struct {
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func (s *Service) validate(key, value string) error {
+	if key == "" {
+		return &ValidationError{Message: "setting key is required"}
+	}
+
+	base := key
+	if i := strings.Index(key, "."); i > 0 {
+		base = key[:i]
+	}
+
+	switch base {
+	case KeyMaxFileSizeMB:
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil || n <= 0 {
+			return &ValidationError{Message: key + " must be a positive number of megabytes"}
+		}
+		if s.BodyLimit > 0 && n<<20 > s.BodyLimit {
+			return &ValidationError{Message: fmt.Sprintf("%s cannot exceed the server body limit of %d MB (MAX_BODY_SIZE_MB)", key, s.BodyLimit>>20)}
+		}
+	case KeyAllowedFileTypes:
+		if len(parseExtensions(value)) == 0 {
+			return &ValidationError{Message: key + " must list at least one extension, e.g. pdf,docx"}
+		}
+	case KeyAcademicYear:
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil || n < 2500 || n > 2700 {
+			return &ValidationError{Message: key + " must be a Buddhist Era year, e.g. 2568"}
+		}
+	case KeyCurrentSemester:
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil || n < 1 || n > 3 {
+			return &ValidationError{Message: key + " must be 1, 2 or 3 (summer)"}
+		}
+	case KeyProjectQuotaMB, KeyUserQuotaMB:
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil || n < 0 {
+			return &ValidationError{Message: key + " must be a number of megabytes (0 for unlimited)"}
+		}
+	case KeyNotificationEmail:
+		if _, err := strconv.ParseBool(strings.TrimSpace(value)); err != nil {
+			return &ValidationError{Message: key + " must be true or false"}
+		}
+	case KeyDigestHour:
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil || n < 0 || n > 23 {
+			return &ValidationError{Message: key + " must be an hour between 0 and 23"}
+		}
+	case KeySimilarityThreshold:
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil || n < 1 || n > 100 {
+			return &ValidationError{Message: key + " must be a percentage between 1 and 100"}
+		}
+	}
+	return nil
+}
+
+func (s *Service) reload() error {
+	var list []models.SystemSetting
+	if err := s.DB.Find(&list).Error; err != nil {
+		return err
+	}
+
+	values := make(map[string]string, len(list))
+	for _, setting := range list {
+		values[setting.SettingKey] = setting.SettingValue
+	}
+
+	s.mu.Lock()
+	s.values = values
+	s.loadedAt = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// parseExtensions turns "pdf, .DOCX" into [".pdf", ".docx"]
+func parseExtensions(v string) []string {
+	var exts []string
+	for _, part := range strings.Split(v, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		part = strings.TrimPrefix(part, ".")
+		if part != "" {
+			exts = append(exts, "."+part)
+		}
+	}
+	return exts
+}
@@ -0,0 +1,341 @@
+package similarity
+
+import (
+	"backend/models"
+	"backend/notify"
+	"backend/settings"
+	"backend/storage"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// reportCategories are the file categories compared for similarity
+var reportCategories = map[string]bool{
+	"proposal":        true,
+	"progress_report": true,
+	"final_report":    true,
+}
+
+// Applies reports whether files of the category and extension are similarity checked
+func Applies(category, ext string) bool {
+	return reportCategories[category] && Supported(ext)
+}
+
+// Checker indexes report text and compares it with every earlier submission of other projects.
+// Like the preview worker it takes pending files from project_files with SKIP LOCKED.
+type Checker struct {
+	DB        *gorm.DB
+	Storage   storage.Storage
+	Settings  *settings.Service
+	Extractor *Extractor
+	Notifier  *notify.Service
+
+	MinShingles   int           // documents with less text (e.g. scanned PDFs) are not compared
+	MaxCandidates int           // LSH candidates scored per file
+	MaxMatches    int           // closest matches kept per file
+	ReportFloor   float64       // matches below this similarity are not kept
+	MaxAttempts   int           // checks tried before a file is marked failed
+	StaleAfter    time.Duration // processing rows older than this are assumed abandoned
+
+	wake chan struct{}
+}
+
+func NewChecker(db *gorm.DB, store storage.Storage, settingsService *settings.Service, notifier *notify.Service) *Checker {
+	return &Checker{
+		DB:            db,
+		Storage:       store,
+		Settings:      settingsService,
+		Extractor:     NewExtractor(),
+		Notifier:      notifier,
+		MinShingles:   200,
+		MaxCandidates: 500,
+		MaxMatches:    10,
+		ReportFloor:   0.1,
+		MaxAttempts:   3,
+		StaleAfter:    15 * time.Minute,
+		wake:          make(chan struct{}, 1),
+	}
+}
+
+// Threshold returns the similarity (0-1) at which a file is flagged
+func (c *Checker) Threshold() float64 {
+	if c.Settings == nil {
+		return 0.4
+	}
+	return float64(c.Settings.GetInt(settings.KeySimilarityThreshold, 40)) / 100
+}
+
+// Enqueue wakes the checker after a file was marked pending
+func (c *Checker) Enqueue() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run processes the queue every interval, or sooner when Enqueue is called, until ctx is cancelled
+func (c *Checker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := c.ProcessPending(ctx); err != nil {
+			log.Printf("Failed to process similarity queue: %v", err)
+		} else if n > 0 {
+			log.Printf("Checked %d files for similarity", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-c.wake:
+		}
+	}
+}
+
+// ProcessPending checks pending files oldest first, so earlier submissions are indexed before the
+// files that may have copied them, and returns how many were processed
+func (c *Checker) ProcessPending(ctx context.Context) (int, error) {
+	c.DB.Model(&models.ProjectFile{}).
+		Where("similarity_status = ? AND updated_at < ?", models.SimilarityProcessing, time.Now().Add(-c.StaleAfter)).
+		Update("similarity_status", models.SimilarityPending)
+
+	total := 0
+	for ctx.Err() == nil {
+		f, err := c.claim()
+		if err != nil {
+			return total, err
+		}
+		if f == nil {
+			break
+		}
+		c.finish(f, c.check(ctx, f))
+		total++
+	}
+	return total, nil
+}
+
+// claim marks the oldest pending file as processing
+func (c *Checker) claim() (*models.ProjectFile, error) {
+	var files []models.ProjectFile
+	err := c.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("similarity_status = ?", models.SimilarityPending).
+			Order("created_at").
+			Limit(1).
+			Find(&files).Error; err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return nil
+		}
+		return tx.Model(&models.ProjectFile{}).
+			Where("id = ?", files[0].ID).
+			Updates(map[string]interface{}{
+				"similarity_status":   models.SimilarityProcessing,
+				"similarity_attempts": gorm.Expr("similarity_attempts + 1"),
+			}).Error
+	})
+	if err != nil || len(files) == 0 {
+		return nil, err
+	}
+	return &files[0], nil
+}
+
+// finish records the outcome of one check
+func (c *Checker) finish(f *models.ProjectFile, err error) {
+	updates := map[string]interface{}{}
+	if err == nil {
+		now := time.Now()
+		updates["similarity_status"] = models.SimilarityReady
+		updates["similarity_score"] = f.SimilarityScore
+		updates["similarity_flagged"] = f.SimilarityFlagged
+		updates["similarity_error"] = ""
+		updates["similarity_checked_at"] = &now
+	} else {
+		log.Printf("Failed to check similarity of file %s: %v", f.ID, err)
+		updates["similarity_error"] = err.Error()
+		// f.SimilarityAttempts was read before claim incremented it
+		if f.SimilarityAttempts+1 >= c.MaxAttempts {
+			updates["similarity_status"] = models.SimilarityFailed
+		} else {
+			updates["similarity_status"] = models.SimilarityPending
+		}
+	}
+
+	if err := c.DB.Model(&models.ProjectFile{}).Where("id = ?", f.ID).Updates(updates).Error; err != nil {
+		log.Printf("Failed to save similarity status of file %s: %v", f.ID, err)
+	}
+}
+
+// check fingerprints one file, stores its closest matches and sets its score and flag on f
+func (c *Checker) check(ctx context.Context, f *models.ProjectFile) error {
+	ext := strings.ToLower(filepath.Ext(f.FileName))
+	if !Supported(ext) {
+		return fmt.Errorf("text cannot be extracted from %s files", ext)
+	}
+
+	text, err := c.extract(ctx, f, ext)
+	if err != nil {
+		return err
+	}
+
+	shingles := Shingles(Normalize(text))
+	if len(shingles) < c.MinShingles {
+		return fmt.Errorf("not enough text to compare (%d shingles); the document may be scanned images", len(shingles))
+	}
+	sig := Compute(shingles)
+
+	matches, err := c.findMatches(f, sig)
+	if err != nil {
+		return err
+	}
+
+	err = c.DB.Transaction(func(tx *gorm.DB) error {
+		fingerprint := models.SimilarityFingerprint{
+			FileID:       f.ID,
+			Signature:    sig.Bytes(),
+			ShingleCount: len(shingles),
+		}
+		if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&fingerprint).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("file_id = ?", f.ID).Delete(&models.SimilarityBand{}).Error; err != nil {
+			return err
+		}
+		buckets := sig.BandBuckets()
+		bands := make([]models.SimilarityBand, len(buckets))
+		for band, bucket := range buckets {
+			bands[band] = models.SimilarityBand{FileID: f.ID, Band: band, Bucket: bucket}
+		}
+		if err := tx.Create(&bands).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("file_id = ?", f.ID).Delete(&models.SimilarityMatch{}).Error; err != nil {
+			return err
+		}
+		if len(matches) > 0 {
+			return tx.Create(&matches).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var best float64
+	if len(matches) > 0 {
+		best = matches[0].Score
+	}
+	wasFlagged := f.SimilarityFlagged
+	f.SimilarityScore = &best
+	f.SimilarityFlagged = best >= c.Threshold()
+
+	if f.SimilarityFlagged && !wasFlagged {
+		c.notifyAdvisor(f, best)
+	}
+	return nil
+}
+
+// findMatches scores the LSH candidates from other projects and returns the closest ones, best first
+func (c *Checker) findMatches(f *models.ProjectFile, sig Signature) ([]models.SimilarityMatch, error) {
+	buckets := sig.BandBuckets()
+	pairs := make([][]interface{}, len(buckets))
+	for band, bucket := range buckets {
+		pairs[band] = []interface{}{band, bucket}
+	}
+
+	// A student's own earlier versions and reports are not plagiarism
+	var candidateIDs []string
+	if err := c.DB.Model(&models.SimilarityBand{}).
+		Select("DISTINCT similarity_bands.file_id").
+		Joins("JOIN project_files pf ON pf.id = similarity_bands.file_id").
+		Where("(similarity_bands.band, similarity_bands.bucket) IN ?", pairs).
+		Where("pf.project_id <> ?", f.ProjectID).
+		Limit(c.MaxCandidates).
+		Scan(&candidateIDs).Error; err != nil {
+		return nil, err
+	}
+	if len(candidateIDs) == 0 {
+		return nil, nil
+	}
+
+	var fingerprints []models.SimilarityFingerprint
+	if err := c.DB.Where("file_id IN ?", candidateIDs).Find(&fingerprints).Error; err != nil {
+		return nil, err
+	}
+
+	var matches []models.SimilarityMatch
+	for _, fp := range fingerprints {
+		other, err := ParseSignature(fp.Signature)
+		if err != nil {
+			continue
+		}
+		if score := sig.Similarity(other); score >= c.ReportFloor {
+			matches = append(matches, models.SimilarityMatch{FileID: f.ID, MatchedFileID: fp.FileID, Score: score})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+	if len(matches) > c.MaxMatches {
+		matches = matches[:c.MaxMatches]
+	}
+	return matches, nil
+}
+
+func (c *Checker) extract(ctx context.Context, f *models.ProjectFile, ext string) (string, error) {
+	r, err := c.Storage.Get(ctx, f.StorageKey())
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	tmp, err := os.CreateTemp("", "similarity-*"+ext)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return c.Extractor.Extract(ctx, tmp.Name(), ext)
+}
+
+// notifyAdvisor tells the project's advisor that a file crossed the similarity threshold
+func (c *Checker) notifyAdvisor(f *models.ProjectFile, score float64) {
+	var project models.Project
+	if err := c.DB.Preload("Advisor").First(&project, "id = ?", f.ProjectID).Error; err != nil || project.Advisor == nil {
+		return
+	}
+
+	notification := models.Notification{
+		UserID:           project.Advisor.UserID,
+		Title:            "Similarity check flagged a file",
+		Message:          fmt.Sprintf("%s in %s is %.0f%% similar to an earlier submission", f.FileName, project.Title, score*100),
+		Type:             "warning",
+		Priority:         "high",
+		RelatedProjectID: &project.ID,
+		EventType:        notify.TypeSimilarityFlagged,
+	}
+	if err := c.Notifier.Send(&notification); err != nil {
+		log.Printf("Failed to notify advisor about similarity of file %s: %v", f.ID, err)
+	}
+}
@@ -0,0 +1,320 @@
+package similarity
+
+import (
+	"backend/models"
+	"backend/storage"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeFiles is just enough of a database for the checker's queue statements on project_files,
+// including row locks for SELECT ... FOR UPDATE SKIP LOCKED
+type fakeFiles struct {
+	mu           sync.Mutex
+	rows         map[string]map[string]driver.Value
+	lockedBy     map[string]*fakeConn
+	doubleClaims int // files claimed while already processing
+
+	selectDelay time.Duration // between selecting rows and returning them, so claims overlap
+}
+
+var fileColumns = []string{"id", "project_id", "file_name", "file_path", "similarity_status", "similarity_attempts",
+	"similarity_flagged", "similarity_error", "created_at", "updated_at"}
+
+func newFakeFiles() *fakeFiles {
+	return &fakeFiles{rows: map[string]map[string]driver.Value{}, lockedBy: map[string]*fakeConn{}}
+}
+
+func (f *fakeFiles) add(id, name, status string, updatedAt time.Time) {
+	f.rows[id] = map[string]driver.Value{
+		"id": id, "project_id": "project", "file_name": name, "file_path": "uploads/" + id + "-" + name,
+		"similarity_status": status, "similarity_attempts": int64(0), "similarity_flagged": false,
+		"similarity_error": "", "created_at": time.Now(), "updated_at": updatedAt,
+	}
+}
+
+func (f *fakeFiles) get(id string) map[string]driver.Value {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rows[id]
+}
+
+func (f *fakeFiles) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: f}, nil }
+func (f *fakeFiles) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{ db *fakeFiles }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) { return &fakeStmt{c, query}, nil }
+func (c *fakeConn) Close() error                              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)                 { return c, nil }
+func (c *fakeConn) Commit() error                             { c.unlock(); return nil }
+func (c *fakeConn) Rollback() error                           { c.unlock(); return nil }
+
+func (c *fakeConn) unlock() {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	for id, holder := range c.db.lockedBy {
+		if holder == c {
+			delete(c.db.lockedBy, id)
+		}
+	}
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+var (
+	updateStatement = regexp.MustCompile(`^UPDATE "project_files" SET (.+) WHERE (.+)$`)
+	setItem         = regexp.MustCompile(`"(\w+)"=([^,]+)`)
+	placeholder     = regexp.MustCompile(`^\$(\d+)$`)
+)
+
+func arg(args []driver.Value, ref string) driver.Value {
+	var n int
+	fmt.Sscanf(ref, "$%d", &n)
+	return args[n-1]
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	f := s.conn.db
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	m := updateStatement.FindStringSubmatch(s.query)
+	if m == nil {
+		return nil, fmt.Errorf("unexpected statement: %s", s.query)
+	}
+	var match func(row map[string]driver.Value) bool
+	where := m[2]
+	switch {
+	case where == "similarity_status = $3 AND updated_at < $4":
+		match = func(row map[string]driver.Value) bool {
+			return row["similarity_status"] == args[2] && row["updated_at"].(time.Time).Before(args[3].(time.Time))
+		}
+	case strings.HasPrefix(where, "id = "):
+		id := arg(args, strings.TrimPrefix(where, "id = "))
+		match = func(row map[string]driver.Value) bool { return row["id"] == id }
+	default:
+		return nil, fmt.Errorf("unexpected condition: %s", where)
+	}
+
+	var n int64
+	for _, row := range f.rows {
+		if !match(row) {
+			continue
+		}
+		for _, item := range setItem.FindAllStringSubmatch(m[1], -1) {
+			switch value := item[2]; {
+			case placeholder.MatchString(value):
+				v := arg(args, value)
+				if item[1] == "similarity_status" && v == models.SimilarityProcessing && row["similarity_status"] == v {
+					f.doubleClaims++
+				}
+				row[item[1]] = v
+			case value == "similarity_attempts + 1":
+				row[item[1]] = row["similarity_attempts"].(int64) + 1
+			default:
+				return nil, fmt.Errorf("unexpected value: %s", value)
+			}
+		}
+		n++
+	}
+	return driver.RowsAffected(n), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows, err := s.selectRows(args)
+	time.Sleep(s.conn.db.selectDelay)
+	return rows, err
+}
+
+func (s *fakeStmt) selectRows(args []driver.Value) (driver.Rows, error) {
+	f := s.conn.db
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if s.query != `SELECT * FROM "project_files" WHERE similarity_status = $1 ORDER BY created_at LIMIT $2 FOR UPDATE SKIP LOCKED` {
+		return nil, fmt.Errorf("unexpected query: %s", s.query)
+	}
+	var candidates []map[string]driver.Value
+	for id, row := range f.rows {
+		if holder := f.lockedBy[id]; row["similarity_status"] == args[0] && (holder == nil || holder == s.conn) {
+			candidates = append(candidates, row)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i]["created_at"].(time.Time).Before(candidates[j]["created_at"].(time.Time))
+	})
+	if limit := int(args[1].(int64)); len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+
+	rows := &fakeRows{}
+	for _, row := range candidates {
+		f.lockedBy[row["id"].(string)] = s.conn
+		values := make([]driver.Value, len(fileColumns))
+		for i, column := range fileColumns {
+			values[i] = row[column]
+		}
+		rows.values = append(rows.values, values)
+	}
+	return rows, nil
+}
+
+type fakeRows struct{ values [][]driver.Value }
+
+func (r *fakeRows) Columns() []string { return fileColumns }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func newTestChecker(t *testing.T, files *fakeFiles) (*Checker, storage.Storage) {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(files)}), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := storage.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewChecker(db, store, nil, nil), store
+}
+
+// putText stores the upload of a fake file row
+func putText(t *testing.T, store storage.Storage, files *fakeFiles, id, text string) {
+	t.Helper()
+	f := models.ProjectFile{FilePath: files.rows[id]["file_path"].(string)}
+	if err := store.Put(context.Background(), f.StorageKey(), strings.NewReader(text), int64(len(text)), ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The failures below are all found before the fingerprint tables are touched
+func TestCheckerRetriesThenFails(t *testing.T) {
+	files := newFakeFiles()
+	files.add("unsupported", "slides.pptx", models.SimilarityPending, time.Now())
+	files.add("scanned", "report.txt", models.SimilarityPending, time.Now())
+	files.add("empty", "blank.txt", models.SimilarityPending, time.Now())
+	files.add("missing", "gone.txt", models.SimilarityPending, time.Now())
+	files.add("done", "old.txt", models.SimilarityReady, time.Now())
+	checker, store := newTestChecker(t, files)
+	putText(t, store, files, "scanned", "Page 1 of 1")
+	putText(t, store, files, "empty", "")
+
+	// A failed check goes back to pending, so one pass retries it until MaxAttempts
+	n, err := checker.ProcessPending(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4*checker.MaxAttempts {
+		t.Fatalf("processed %d checks, want %d", n, 4*checker.MaxAttempts)
+	}
+
+	tests := []struct {
+		id, errPart string
+	}{
+		{"unsupported", "cannot be extracted from .pptx"},
+		{"scanned", "not enough text"},
+		{"empty", "not enough text"},
+		{"missing", ""},
+	}
+	for _, tt := range tests {
+		row := files.get(tt.id)
+		if row["similarity_status"] != models.SimilarityFailed || row["similarity_attempts"] != int64(checker.MaxAttempts) {
+			t.Fatalf("%s row %v", tt.id, row)
+		}
+		if msg := row["similarity_error"].(string); msg == "" || !strings.Contains(msg, tt.errPart) {
+			t.Fatalf("%s error %q, want %q", tt.id, msg, tt.errPart)
+		}
+	}
+	if done := files.get("done"); done["similarity_attempts"] != int64(0) {
+		t.Fatal("a file that was not pending was checked")
+	}
+
+	// Failed files stay failed
+	if n, _ := checker.ProcessPending(context.Background()); n != 0 {
+		t.Fatalf("processed %d failed files again", n)
+	}
+}
+
+func TestCheckerReclaimsStaleFiles(t *testing.T) {
+	files := newFakeFiles()
+	files.add("stale", "slides.pptx", models.SimilarityProcessing, time.Now().Add(-time.Hour))
+	files.add("busy", "other.pptx", models.SimilarityProcessing, time.Now())
+	checker, _ := newTestChecker(t, files)
+
+	if _, err := checker.ProcessPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if files.get("stale")["similarity_status"] != models.SimilarityFailed {
+		t.Fatal("abandoned file was not checked again")
+	}
+	if files.get("busy")["similarity_status"] != models.SimilarityProcessing {
+		t.Fatal("file another checker is working on was taken over")
+	}
+}
+
+func TestCheckersShareQueue(t *testing.T) {
+	files := newFakeFiles()
+	files.selectDelay = 2 * time.Millisecond
+	a, _ := newTestChecker(t, files)
+	b, _ := newTestChecker(t, files)
+
+	const count = 20
+	for i := 0; i < count; i++ {
+		files.add(fmt.Sprintf("f%02d", i), "slides.pptx", models.SimilarityPending, time.Now())
+	}
+
+	var wg sync.WaitGroup
+	var processed atomic.Int32
+	for _, c := range []*Checker{a, b} {
+		wg.Add(1)
+		go func(c *Checker) {
+			defer wg.Done()
+			n, err := c.ProcessPending(context.Background())
+			if err != nil {
+				t.Error(err)
+			}
+			processed.Add(int32(n))
+		}(c)
+	}
+	wg.Wait()
+
+	if files.doubleClaims > 0 {
+		t.Fatalf("%d files were claimed by both checkers", files.doubleClaims)
+	}
+	if want := int32(count * a.MaxAttempts); processed.Load() != want {
+		t.Fatalf("processed %d checks, want %d", processed.Load(), want)
+	}
+	for id, row := range files.rows {
+		if row["similarity_status"] != models.SimilarityFailed || row["similarity_attempts"] != int64(a.MaxAttempts) {
+			t.Fatalf("%s row %v", id, row)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package similarity
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// maxTextBytes bounds how much text is read from one document
+const maxTextBytes = 20 << 20
+
+// textExts are the uploads whose text can be extracted
+var textExts = map[string]bool{".pdf": true, ".docx": true, ".txt": true}
+
+// Supported reports whether text can be extracted from files with the extension (".pdf")
+func Supported(ext string) bool {
+	return textExts[strings.ToLower(ext)]
+}
+
+// Extractor pulls plain text out of uploaded documents
+type Extractor struct {
+	Pdftotext string        // path of poppler's pdftotext
+	Timeout   time.Duration // per PDF
+}
+
+func NewExtractor() *Extractor {
+	pdftotext := os.Getenv("PDFTOTEXT_PATH")
+	if pdftotext == "" {
+		pdftotext = "pdftotext"
+	}
+	return &Extractor{Pdftotext: pdftotext, Timeout: time.Minute}
+}
+
+// Extract returns the text of the document at path
+func (e *Extractor) Extract(ctx context.Context, path, ext string) (string, error) {
+	switch strings.ToLower(ext) {
+	case ".txt":
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		data, err := io.ReadAll(io.LimitReader(f, maxTextBytes))
+		if err != nil {
+			return "", err
+		}
+		return strings.ToValidUTF8(string(data), " "), nil
+	case ".docx":
+		return extractDocx(path)
+	case ".pdf":
+		return e.extractPDF(ctx, path)
+	default:
+		return "", fmt.Errorf("text extraction is not supported for %s files", ext)
+	}
+}
+
+// extractPDF runs pdftotext and reads the text from its stdout
+func (e *Extractor) extractPDF(ctx context.Context, path string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.Timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.Pdftotext, "-q", "-enc", "UTF-8", path, "-")
+	cmd.Stdout = &limitedWriter{w: &stdout, n: maxTextBytes}
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", fmt.Errorf("pdftotext timed out after %s", e.Timeout)
+		}
+		return "", fmt.Errorf("pdftotext: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.ToValidUTF8(stdout.String(), " "), nil
+}
+
+// extractDocx reads the paragraphs of word/document.xml
+func extractDocx(path string) (string, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if f.Name != "word/document.xml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return "", err
+		}
+		defer rc.Close()
+
+		var text strings.Builder
+		inText := false
+		dec := xml.NewDecoder(io.LimitReader(rc, 10*maxTextBytes))
+		for {
+			tok, err := dec.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", err
+			}
+			switch t := tok.(type) {
+			case xml.StartElement:
+				switch t.Name.Local {
+				case "t":
+					inText = true
+				case "tab", "br":
+					text.WriteByte(' ')
+				}
+			case xml.EndElement:
+				switch t.Name.Local {
+				case "t":
+					inText = false
+				case "p":
+					text.WriteByte('\n')
+				}
+			case xml.CharData:
+				if inText {
+					text.Write(t)
+				}
+			}
+			if text.Len() > maxTextBytes {
+				break
+			}
+		}
+		return text.String(), nil
+	}
+	return "", errors.New("word/document.xml not found")
+}
+
+// limitedWriter discards everything after n bytes instead of failing the command
+type limitedWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.n <= 0 {
+		return len(p), nil
+	}
+	written := p
+	if int64(len(written)) > l.n {
+		written = written[:l.n]
+	}
+	n, err := l.w.Write(written)
+	l.n -= int64(n)
+	if err != nil {
+		return n, err
+	}
+	return len(p), nil
+}
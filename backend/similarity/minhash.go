@@ -0,0 +1,127 @@
+package similarity
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math/rand"
+	"unicode"
+)
+
+const (
+	// ShingleSize is the number of characters per shingle. Characters rather than words are
+	// used because Thai is written without spaces between words.
+	ShingleSize = 8
+	// NumHashes is the length of a MinHash signature
+	NumHashes = 128
+	// Bands and Rows split a signature for locality-sensitive hashing (Bands*Rows = NumHashes).
+	// Two rows per band makes documents about 20% similar likely to become candidates.
+	Bands = 64
+	Rows  = NumHashes / Bands
+)
+
+// hashSeeds are the odd multipliers and offsets of the NumHashes hash functions.
+// They are fixed so signatures stored in the database stay comparable.
+var hashSeeds = func() [NumHashes][2]uint64 {
+	var seeds [NumHashes][2]uint64
+	r := rand.New(rand.NewSource(4101))
+	for i := range seeds {
+		seeds[i] = [2]uint64{r.Uint64() | 1, r.Uint64()}
+	}
+	return seeds
+}()
+
+// Signature is the MinHash of a document's shingle set
+type Signature [NumHashes]uint32
+
+// Normalize lowercases text and keeps only letters, digits and combining marks,
+// so layout, punctuation and spacing differences do not affect the comparison
+func Normalize(text string) []rune {
+	out := make([]rune, 0, len(text))
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) {
+			out = append(out, unicode.ToLower(r))
+		}
+	}
+	return out
+}
+
+// Shingles returns the hashes of every ShingleSize-character window of the normalized text
+func Shingles(text []rune) map[uint64]struct{} {
+	shingles := make(map[uint64]struct{})
+	buf := make([]byte, 0, ShingleSize*4)
+	for i := 0; i+ShingleSize <= len(text); i++ {
+		buf = buf[:0]
+		for _, r := range text[i : i+ShingleSize] {
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(r))
+		}
+		h := fnv.New64a()
+		h.Write(buf)
+		shingles[h.Sum64()] = struct{}{}
+	}
+	return shingles
+}
+
+// Compute returns the MinHash signature of a shingle set
+func Compute(shingles map[uint64]struct{}) Signature {
+	var sig Signature
+	for i := range sig {
+		sig[i] = ^uint32(0)
+	}
+	for x := range shingles {
+		for i, seed := range hashSeeds {
+			// Multiply-shift hashing; the high bits are the well mixed ones
+			if h := uint32((seed[0]*x + seed[1]) >> 32); h < sig[i] {
+				sig[i] = h
+			}
+		}
+	}
+	return sig
+}
+
+// Similarity estimates the Jaccard similarity (0-1) of the documents behind two signatures
+func (s Signature) Similarity(other Signature) float64 {
+	same := 0
+	for i := range s {
+		if s[i] == other[i] {
+			same++
+		}
+	}
+	return float64(same) / NumHashes
+}
+
+// BandBuckets returns the LSH bucket of each band; documents sharing any bucket are candidates
+func (s Signature) BandBuckets() [Bands]int64 {
+	var buckets [Bands]int64
+	buf := make([]byte, Rows*4)
+	for band := 0; band < Bands; band++ {
+		for row := 0; row < Rows; row++ {
+			binary.LittleEndian.PutUint32(buf[row*4:], s[band*Rows+row])
+		}
+		h := fnv.New64a()
+		h.Write(buf)
+		buckets[band] = int64(h.Sum64())
+	}
+	return buckets
+}
+
+// Bytes encodes the signature for storage
+func (s Signature) Bytes() []byte {
+	buf := make([]byte, NumHashes*4)
+	for i, v := range s {
+		binary.LittleEndian.PutUint32(buf[i*4:], v)
+	}
+	return buf
+}
+
+// ParseSignature decodes a stored signature
+func ParseSignature(b []byte) (Signature, error) {
+	var sig Signature
+	if len(b) != NumHashes*4 {
+		return sig, errors.New("similarity: invalid signature length")
+	}
+	for i := range sig {
+		sig[i] = binary.LittleEndian.Uint32(b[i*4:])
+	}
+	return sig, nil
+}
@@ -0,0 +1,175 @@
+package similarity
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// randomText returns words of random letters, so texts from different seeds share almost no shingles
+func randomText(seed int64, words int) string {
+	r := rand.New(rand.NewSource(seed))
+	var b strings.Builder
+	for i := 0; i < words; i++ {
+		for j := 3 + r.Intn(6); j > 0; j-- {
+			b.WriteByte(byte('a' + r.Intn(26)))
+		}
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+func signatureOf(text string) Signature {
+	return Compute(Shingles(Normalize(text)))
+}
+
+// sharedBands counts the LSH bands in which two signatures fall into the same bucket
+func sharedBands(a, b Signature) int {
+	ba, bb := a.BandBuckets(), b.BandBuckets()
+	n := 0
+	for i := range ba {
+		if ba[i] == bb[i] {
+			n++
+		}
+	}
+	return n
+}
+
+func jaccard(a, b map[uint64]struct{}) float64 {
+	both := 0
+	for x := range a {
+		if _, ok := b[x]; ok {
+			both++
+		}
+	}
+	return float64(both) / float64(len(a)+len(b)-both)
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"empty", "", ""},
+		{"case and punctuation", "Hello, World! 2024", "helloworld2024"},
+		{"layout", "line one\n\tline two", "lineonelinetwo"},
+		{"thai keeps vowel and tone marks", "การ ทดลอง", "การทดลอง"},
+		{"symbols only", "--- * ---", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(Normalize(tt.in)); got != tt.want {
+				t.Fatalf("Normalize(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShingles(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want int
+	}{
+		{"empty", "", 0},
+		{"shorter than a shingle", "abcdefg", 0},
+		{"one shingle", "abcdefgh", 1},
+		{"sliding window", "abcdefghij", 3},
+		{"repeats are counted once", strings.Repeat("a", 20), 1},
+		{"thai", "ภาษาไทยไม่มีช่องว่าง", len([]rune("ภาษาไทยไม่มีช่องว่าง")) - ShingleSize + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := len(Shingles([]rune(tt.text))); got != tt.want {
+				t.Fatalf("%d shingles, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignatureComparison(t *testing.T) {
+	base := randomText(1, 400)
+	words := strings.Fields(base)
+	// Every fourth word replaced leaves about a fifth of the shingles in common
+	edited := append([]string(nil), words...)
+	for i := range edited {
+		if i%4 == 0 {
+			edited[i] = strings.Fields(randomText(int64(100+i), 1))[0]
+		}
+	}
+
+	tests := []struct {
+		name           string
+		a, b           string
+		minSim, maxSim float64
+		candidates     bool // shares at least one LSH bucket
+	}{
+		{"identical", base, base, 1, 1, true},
+		{"formatting only", base, strings.ToUpper(strings.Join(words, ",\n")), 1, 1, true},
+		{"small edit", base, base + " an added closing sentence", 0.8, 1, true},
+		{"every fourth word replaced", base, strings.Join(edited, " "), 0.05, 0.5, true},
+		{"unrelated", base, randomText(2, 400), 0, 0.05, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := signatureOf(tt.a), signatureOf(tt.b)
+			sim := a.Similarity(b)
+			if sim < tt.minSim || sim > tt.maxSim {
+				t.Fatalf("similarity %.3f, want %.2f-%.2f", sim, tt.minSim, tt.maxSim)
+			}
+			if sim != b.Similarity(a) {
+				t.Fatal("similarity is not symmetric")
+			}
+			if shared := sharedBands(a, b); (shared > 0) != tt.candidates {
+				t.Fatalf("%d shared bands, want candidates=%v", shared, tt.candidates)
+			}
+			if tt.minSim == 1 && sharedBands(a, b) != Bands {
+				t.Fatal("identical text did not collide in every band")
+			}
+		})
+	}
+}
+
+func TestSimilarityEstimatesJaccard(t *testing.T) {
+	a := Shingles(Normalize(randomText(3, 300)))
+	b := Shingles(Normalize(randomText(3, 200) + randomText(4, 100)))
+	want := jaccard(a, b)
+	got := Compute(a).Similarity(Compute(b))
+	// With 128 hashes the standard error is at most about 0.045
+	if d := got - want; d > 0.15 || d < -0.15 {
+		t.Fatalf("estimated %.3f, exact Jaccard %.3f", got, want)
+	}
+}
+
+func TestShortDocuments(t *testing.T) {
+	for _, text := range []string{"", "   ", "short", "เล็ก"} {
+		shingles := Shingles(Normalize(text))
+		if len(shingles) != 0 {
+			t.Fatalf("%q has %d shingles", text, len(shingles))
+		}
+		// An empty set has the all-maximum signature; the checker skips such documents through MinShingles
+		sig := Compute(shingles)
+		for i, v := range sig {
+			if v != ^uint32(0) {
+				t.Fatalf("hash %d of an empty signature is %d", i, v)
+			}
+		}
+		sig.BandBuckets()
+	}
+}
+
+func TestSignatureBytes(t *testing.T) {
+	sig := signatureOf(randomText(5, 100))
+	parsed, err := ParseSignature(sig.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed != sig {
+		t.Fatal("signature changed after encoding")
+	}
+
+	for _, n := range []int{0, NumHashes*4 - 1, NumHashes*4 + 4} {
+		if _, err := ParseSignature(make([]byte, n)); err == nil {
+			t.Fatalf("%d bytes parsed as a signature", n)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+)
+
+// ErrChecksumMismatch is returned by a verifying reader whose content does not match the expected SHA-256
+var ErrChecksumMismatch = errors.New("storage: checksum mismatch")
+
+// HashReader returns the SHA-256 (hex) and length of everything read from r
+func HashReader(r io.Reader) (string, int64, error) {
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return "", n, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// verifyingReader hashes what passes through it and checks the sum once size bytes were read
+type verifyingReader struct {
+	r          io.ReadCloser
+	hash       hash.Hash
+	expected   string
+	size       int64
+	read       int64
+	done       bool
+	onMismatch func(actual string)
+}
+
+// NewVerifyingReader wraps r so the read that completes the object fails with ErrChecksumMismatch
+// when its SHA-256 is not expected. That last chunk is withheld, so a client never receives a
+// complete copy of corrupted content. size < 0 verifies at EOF instead.
+func NewVerifyingReader(r io.ReadCloser, size int64, expected string, onMismatch func(actual string)) io.ReadCloser {
+	return &verifyingReader{r: r, hash: sha256.New(), expected: expected, size: size, onMismatch: onMismatch}
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	if v.done {
+		return v.r.Read(p)
+	}
+
+	n, err := v.r.Read(p)
+	v.hash.Write(p[:n])
+	v.read += int64(n)
+
+	if (v.size >= 0 && v.read >= v.size) || err == io.EOF {
+		v.done = true
+		if actual := hex.EncodeToString(v.hash.Sum(nil)); actual != v.expected {
+			if v.onMismatch != nil {
+				v.onMismatch(actual)
+			}
+			return 0, ErrChecksumMismatch
+		}
+	}
+	return n, err
+}
+
+func (v *verifyingReader) Close() error {
+	return v.r.Close()
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when a key does not exist in the backend
+var ErrNotFound = errors.New("storage: object not found")
+
+// ErrSignedURLUnsupported is returned by backends that cannot hand out direct URLs
+var ErrSignedURLUnsupported = errors.New("storage: signed URLs are not supported by this backend")
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+// Storage is the interface every file backend implements.
+// Keys are slash separated paths relative to the backend root, e.g. "3f2a...e1.pdf".
+type Storage interface {
+	// Put stores size bytes read from r under key, replacing any existing object
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the object for reading; the caller must close it
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object; deleting a missing key is not an error
+	Delete(ctx context.Context, key string) error
+	// Stat returns metadata of the object or ErrNotFound
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// SignedURL returns a time-limited URL to fetch the object directly from the backend
+	SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error)
+	// List calls fn for every object whose key starts with prefix ("" lists everything).
+	// Returning an error from fn stops the listing and is returned by List.
+	List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error
+}
+
+// New creates the backend of the given kind ("local" or "s3") configured from environment variables
+func New(kind string) (Storage, error) {
+	switch kind {
+	case "", "local":
+		return NewLocalStorage(getEnv("STORAGE_LOCAL_ROOT", "./uploads"))
+	case "s3":
+		return NewS3Storage(S3Config{
+			Endpoint:     os.Getenv("S3_ENDPOINT"),
+			Region:       getEnv("S3_REGION", "us-east-1"),
+			Bucket:       os.Getenv("S3_BUCKET"),
+			AccessKey:    os.Getenv("S3_ACCESS_KEY"),
+			SecretKey:    os.Getenv("S3_SECRET_KEY"),
+			UsePathStyle: getEnv("S3_USE_PATH_STYLE", "true") == "true",
+		})
+	default:
+		return nil, fmt.Errorf("storage: unknown backend %q", kind)
+	}
+}
+
+// NewFromEnv creates the backend selected by STORAGE_BACKEND (default "local")
+func NewFromEnv() (Storage, error) {
+	return New(os.Getenv("STORAGE_BACKEND"))
+}
+
+// Copy copies one object from src to dst
+func Copy(ctx context.Context, src, dst Storage, key string) error {
+	info, err := src.Stat(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	r, err := src.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return dst.Put(ctx, key, r, info.Size, info.ContentType)
+}
+
+// cleanKey rejects keys that could escape the backend root
+func cleanKey(key string) (string, error) {
+	key = strings.TrimPrefix(key, "/")
+	if key == "" {
+		return "", errors.New("storage: empty key")
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", fmt.Errorf("storage: invalid key %q", key)
+		}
+	}
+	return key, nil
+}
+
+func getEnv(k, d string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return d
+}
@@ -0,0 +1,310 @@
+package storagegc
+
+import (
+	"backend/models"
+	"backend/storage"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Options selects what Run cleans up; the zero value is a dry run that only reports
+type Options struct {
+	DeleteOrphans  bool // delete stored objects no database row refers to
+	DeleteDangling bool // delete file rows whose object is gone and re-queue missing previews
+}
+
+// Object is a stored object without a database row
+type Object struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// DanglingFile is a project_files row whose stored object is missing
+type DanglingFile struct {
+	ID        string    `json:"id"`
+	ProjectID string    `json:"project_id"`
+	FileName  string    `json:"file_name"`
+	Key       string    `json:"key"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Report is the outcome of one reconciliation
+type Report struct {
+	DryRun           bool           `json:"dry_run"`
+	StartedAt        time.Time      `json:"started_at"`
+	FinishedAt       time.Time      `json:"finished_at"`
+	ScannedObjects   int            `json:"scanned_objects"`
+	ScannedFiles     int            `json:"scanned_files"`
+	StoredBytes      int64          `json:"stored_bytes"`
+	RecentSkipped    int            `json:"recent_skipped"` // unreferenced objects younger than the grace period
+	OrphanCount      int            `json:"orphan_count"`
+	OrphanBytes      int64          `json:"orphan_bytes"`
+	Orphans          []Object       `json:"orphans"`
+	DanglingCount    int            `json:"dangling_count"`
+	Dangling         []DanglingFile `json:"dangling"`
+	MissingPreviews  int            `json:"missing_previews"`
+	Truncated        bool           `json:"truncated"` // Orphans or Dangling hold only the first MaxListed entries
+	DeletedObjects   int            `json:"deleted_objects"`
+	DeletedBytes     int64          `json:"deleted_bytes"`
+	DeletedRows      int            `json:"deleted_rows"`
+	RequeuedPreviews int            `json:"requeued_previews"`
+	Failed           int            `json:"failed"`
+}
+
+// Reconciler compares the storage backend with project_files and upload_sessions
+type Reconciler struct {
+	DB      *gorm.DB
+	Storage storage.Storage
+
+	// GracePeriod protects objects and rows of uploads that are still in progress:
+	// bytes are stored before their row is committed, and a row may be read before its bytes are listed.
+	GracePeriod time.Duration
+	MaxListed   int // entries of each kind included in the report
+}
+
+func NewReconciler(db *gorm.DB, store storage.Storage) *Reconciler {
+	return &Reconciler{
+		DB:          db,
+		Storage:     store,
+		GracePeriod: 24 * time.Hour,
+		MaxListed:   500,
+	}
+}
+
+// Run lists every stored object, matches it with the database and cleans up what opts selects.
+// Nothing is deleted when the listing fails part way.
+func (r *Reconciler) Run(ctx context.Context, opts Options) (*Report, error) {
+	report := &Report{
+		DryRun:    !opts.DeleteOrphans && !opts.DeleteDangling,
+		StartedAt: time.Now(),
+		Orphans:   []Object{},
+		Dangling:  []DanglingFile{},
+	}
+	cutoff := report.StartedAt.Add(-r.GracePeriod)
+
+	// Every key referenced by a file row
+	owned := map[string]bool{}
+	var rows []*models.ProjectFile
+	var batch []models.ProjectFile
+	err := r.DB.Model(&models.ProjectFile{}).
+		Select("id, project_id, file_name, file_path, preview_status, preview_key, thumbnail_key, created_at").
+		FindInBatches(&batch, 1000, func(tx *gorm.DB, n int) error {
+			for i := range batch {
+				row := batch[i]
+				rows = append(rows, &row)
+				for _, key := range row.StoredKeys() {
+					owned[key] = true
+				}
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return nil, err
+	}
+	report.ScannedFiles = len(rows)
+
+	activeSessions, err := r.activeSessions()
+	if err != nil {
+		return nil, err
+	}
+
+	present := map[string]bool{}
+	var orphans []Object
+	err = r.Storage.List(ctx, "", func(obj storage.ObjectInfo) error {
+		report.ScannedObjects++
+		report.StoredBytes += obj.Size
+		present[obj.Key] = true
+
+		if owned[obj.Key] {
+			return nil
+		}
+		if id, ok := chunkSession(obj.Key); ok && activeSessions[id] {
+			return nil
+		}
+		if obj.LastModified.After(cutoff) {
+			report.RecentSkipped++
+			return nil
+		}
+		orphans = append(orphans, Object{Key: obj.Key, Size: obj.Size, LastModified: obj.LastModified})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report.OrphanCount = len(orphans)
+	for _, o := range orphans {
+		report.OrphanBytes += o.Size
+		if len(report.Orphans) < r.MaxListed {
+			report.Orphans = append(report.Orphans, o)
+		}
+	}
+
+	var dangling []*models.ProjectFile
+	var missingPreviews []*models.ProjectFile
+	for _, row := range rows {
+		if row.CreatedAt.After(cutoff) {
+			continue
+		}
+		if !present[row.StorageKey()] {
+			dangling = append(dangling, row)
+			continue
+		}
+		if row.PreviewStatus == models.PreviewReady &&
+			(row.PreviewKey != "" && !present[row.PreviewKey] || row.ThumbnailKey != "" && !present[row.ThumbnailKey]) {
+			missingPreviews = append(missingPreviews, row)
+		}
+	}
+
+	report.DanglingCount = len(dangling)
+	report.MissingPreviews = len(missingPreviews)
+	for _, row := range dangling {
+		if len(report.Dangling) < r.MaxListed {
+			report.Dangling = append(report.Dangling, DanglingFile{
+				ID:        row.ID,
+				ProjectID: row.ProjectID,
+				FileName:  row.FileName,
+				Key:       row.StorageKey(),
+				CreatedAt: row.CreatedAt,
+			})
+		}
+	}
+	report.Truncated = report.OrphanCount > len(report.Orphans) || report.DanglingCount > len(report.Dangling)
+
+	if opts.DeleteDangling && len(dangling) > 0 && report.ScannedObjects == 0 {
+		// Pointing STORAGE_BACKEND at an empty bucket would otherwise wipe every file row
+		return report, errors.New("storagegc: storage is empty, refusing to delete file rows (check STORAGE_BACKEND)")
+	}
+
+	if opts.DeleteOrphans {
+		for _, o := range orphans {
+			if ctx.Err() != nil {
+				break
+			}
+			r.deleteOrphan(ctx, o, report)
+		}
+	}
+	if opts.DeleteDangling {
+		for _, row := range dangling {
+			if ctx.Err() != nil {
+				break
+			}
+			r.deleteDangling(ctx, row, report)
+		}
+		for _, row := range missingPreviews {
+			r.requeuePreview(row, report)
+		}
+	}
+
+	report.FinishedAt = time.Now()
+	return report, ctx.Err()
+}
+
+// activeSessions returns the IDs of upload sessions whose chunks are still needed
+func (r *Reconciler) activeSessions() (map[string]bool, error) {
+	var ids []string
+	if err := r.DB.Model(&models.UploadSession{}).
+		Where("status IN ?", []string{"active", "assembling"}).
+		Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+	active := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		active[id] = true
+	}
+	return active, nil
+}
+
+// chunkSession returns the upload session a chunk key ("chunks/<session>/<index>") belongs to
+func chunkSession(key string) (string, bool) {
+	if !strings.HasPrefix(key, models.UploadChunkPrefix) {
+		return "", false
+	}
+	id, _, ok := strings.Cut(strings.TrimPrefix(key, models.UploadChunkPrefix), "/")
+	return id, ok
+}
+
+// deleteOrphan removes an object after checking again that nothing started referring to it
+func (r *Reconciler) deleteOrphan(ctx context.Context, o Object, report *Report) {
+	referenced, err := r.referenced(o.Key)
+	if err != nil {
+		log.Printf("Failed to check references of %s: %v", o.Key, err)
+		report.Failed++
+		return
+	}
+	if referenced {
+		return
+	}
+
+	if err := r.Storage.Delete(ctx, o.Key); err != nil {
+		log.Printf("Failed to delete orphaned object %s: %v", o.Key, err)
+		report.Failed++
+		return
+	}
+	report.DeletedObjects++
+	report.DeletedBytes += o.Size
+}
+
+// referenced reports whether a file row or an unfinished upload refers to key
+func (r *Reconciler) referenced(key string) (bool, error) {
+	var count int64
+	if id, ok := chunkSession(key); ok {
+		err := r.DB.Model(&models.UploadSession{}).
+			Where("id::text = ? AND status IN ?", id, []string{"active", "assembling"}).
+			Count(&count).Error
+		return count > 0, err
+	}
+	err := r.DB.Model(&models.ProjectFile{}).
+		Where("file_path IN ? OR preview_key = ? OR thumbnail_key = ?", []string{key, "uploads/" + key}, key, key).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// deleteDangling removes a file row after checking again that its object is really gone
+func (r *Reconciler) deleteDangling(ctx context.Context, row *models.ProjectFile, report *Report) {
+	if _, err := r.Storage.Stat(ctx, row.StorageKey()); !errors.Is(err, storage.ErrNotFound) {
+		if err != nil {
+			log.Printf("Failed to check %s: %v", row.StorageKey(), err)
+			report.Failed++
+		}
+		return
+	}
+
+	if err := r.DB.Delete(&models.ProjectFile{}, "id = ?", row.ID).Error; err != nil {
+		log.Printf("Failed to delete dangling file row %s: %v", row.ID, err)
+		report.Failed++
+		return
+	}
+	log.Printf("Deleted file row %s (%s): %s is missing from storage", row.ID, row.FileName, row.StorageKey())
+	report.DeletedRows++
+
+	// The generated preview is useless without its file
+	for _, key := range row.StoredKeys()[1:] {
+		if err := r.Storage.Delete(ctx, key); err != nil {
+			log.Printf("Failed to delete preview %s: %v", key, err)
+		}
+	}
+}
+
+// requeuePreview sends a file whose preview or thumbnail disappeared back to the preview worker
+func (r *Reconciler) requeuePreview(row *models.ProjectFile, report *Report) {
+	err := r.DB.Model(&models.ProjectFile{}).
+		Where("id = ? AND preview_status = ?", row.ID, models.PreviewReady).
+		Updates(map[string]interface{}{
+			"preview_status":   models.PreviewPending,
+			"preview_attempts": 0,
+			"preview_error":    "",
+		}).Error
+	if err != nil {
+		log.Printf("Failed to re-queue preview of file %s: %v", row.ID, err)
+		report.Failed++
+		return
+	}
+	report.RequeuedPreviews++
+}
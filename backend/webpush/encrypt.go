@@ -0,0 +1,147 @@
+package webpush
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	recordSize = 4096
+	headerSize = 16 + 4 + 1 + 65 // salt, record size, key id length, sender public key
+	// MaxPayload fits the single record push services accept (4096 bytes) after the header,
+	// the padding delimiter and the authentication tag
+	MaxPayload = recordSize - headerSize - 1 - 16
+)
+
+// ErrPayloadTooLarge is returned for payloads over MaxPayload
+var ErrPayloadTooLarge = fmt.Errorf("webpush: payload is larger than %d bytes", MaxPayload)
+
+// Encrypt encrypts payload for the subscriber as a single aes128gcm record (RFC 8291, RFC 8188)
+func Encrypt(keys Keys, payload []byte) ([]byte, error) {
+	if len(payload) > MaxPayload {
+		return nil, ErrPayloadTooLarge
+	}
+	uaRaw, err := decodeBase64(keys.P256dh)
+	if err != nil {
+		return nil, errors.New("webpush: p256dh is not base64url")
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(uaRaw)
+	if err != nil {
+		return nil, fmt.Errorf("webpush: invalid p256dh: %w", err)
+	}
+	authSecret, err := decodeBase64(keys.Auth)
+	if err != nil || len(authSecret) != 16 {
+		return nil, errors.New("webpush: auth must be 16 bytes of base64url")
+	}
+
+	// A new key pair and salt for every message
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	aead, nonce, err := contentKey(asPrivate, uaPublic, uaPublic.Bytes(), asPrivate.PublicKey().Bytes(), authSecret, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, headerSize)
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, recordSize)
+	header = append(header, 65)
+	header = append(header, asPrivate.PublicKey().Bytes()...)
+
+	// 0x02 marks the last record; no further padding
+	plaintext := append(append([]byte(nil), payload...), 2)
+	return aead.Seal(header, nonce, plaintext, nil), nil
+}
+
+// Decrypt reverses Encrypt with the subscriber's private key and auth secret, e.g. in FakeService
+func Decrypt(body []byte, uaPrivate *ecdh.PrivateKey, authSecret []byte) ([]byte, error) {
+	if len(body) < 21 || len(body) < 21+int(body[20]) {
+		return nil, errors.New("webpush: truncated aes128gcm header")
+	}
+	salt := body[:16]
+	idLen := int(body[20])
+	asPublic, err := ecdh.P256().NewPublicKey(body[21 : 21+idLen])
+	if err != nil {
+		return nil, fmt.Errorf("webpush: invalid sender key: %w", err)
+	}
+
+	aead, nonce, err := contentKey(uaPrivate, asPublic, uaPrivate.PublicKey().Bytes(), asPublic.Bytes(), authSecret, salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, nonce, body[21+idLen:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("webpush: %w", err)
+	}
+
+	// Strip the padding after the last non-zero byte, which must be the 0x02 delimiter
+	for i := len(plaintext) - 1; i >= 0; i-- {
+		switch plaintext[i] {
+		case 0:
+			continue
+		case 2:
+			return plaintext[:i], nil
+		}
+		break
+	}
+	return nil, errors.New("webpush: missing padding delimiter")
+}
+
+// contentKey derives the AES-128-GCM key and nonce from the ECDH secret between own and peer
+func contentKey(own *ecdh.PrivateKey, peer *ecdh.PublicKey, uaPublic, asPublic, authSecret, salt []byte) (cipher.AEAD, []byte, error) {
+	shared, err := own.ECDH(peer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("webpush: %w", err)
+	}
+
+	info := append([]byte("WebPush: info\x00"), uaPublic...)
+	info = append(info, asPublic...)
+	ikm, err := expand(hkdf.Extract(sha256.New, shared, authSecret), info, 32)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	prk := hkdf.Extract(sha256.New, ikm, salt)
+	cek, err := expand(prk, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce, err := expand(prk, []byte("Content-Encoding: nonce\x00"), 12)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead, nonce, nil
+}
+
+func expand(prk, info []byte, length int) ([]byte, error) {
+	out := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, info), out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
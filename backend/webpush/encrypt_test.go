@@ -0,0 +1,111 @@
+package webpush
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// Keys and message of RFC 8291 appendix A
+const (
+	rfcPlaintext     = "When I grow up, I want to be a watermelon"
+	rfcServerPrivate = "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"
+	rfcServerPublic  = "BP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A8"
+	rfcAgentPrivate  = "q1dXpw3UpT5VOmu_cf_v6ih07Aems3njxI-JWgLcM94"
+	rfcAgentPublic   = "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"
+	rfcAuthSecret    = "BTBZMqHH6r4Tts7J_aSIgg"
+	rfcEncrypted     = "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+)
+
+func mustDecode(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := decodeBase64(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDecryptRFC8291Vector(t *testing.T) {
+	agent, err := ecdh.P256().NewPrivateKey(mustDecode(t, rfcAgentPrivate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := Decrypt(mustDecode(t, rfcEncrypted), agent, mustDecode(t, rfcAuthSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != rfcPlaintext {
+		t.Fatalf("got %q", plaintext)
+	}
+}
+
+func newAgent(t *testing.T) (*ecdh.PrivateKey, []byte, Keys) {
+	t.Helper()
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	rand.Read(auth)
+	return key, auth, Keys{P256dh: b64.EncodeToString(key.PublicKey().Bytes()), Auth: b64.EncodeToString(auth)}
+}
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	agent, auth, keys := newAgent(t)
+
+	for _, size := range []int{0, 1, 100, MaxPayload} {
+		payload := make([]byte, size)
+		rand.Read(payload)
+
+		body, err := Encrypt(keys, payload)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if len(body) > recordSize {
+			t.Fatalf("%d bytes encrypt to %d, more than one record", size, len(body))
+		}
+		got, err := Decrypt(body, agent, auth)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Fatalf("%d bytes: round trip changed the payload", size)
+		}
+	}
+
+	// Every message gets its own salt and key pair
+	a, _ := Encrypt(keys, []byte("same"))
+	b, _ := Encrypt(keys, []byte("same"))
+	if bytes.Equal(a, b) {
+		t.Fatal("two encryptions of one payload are identical")
+	}
+}
+
+func TestEncryptRejects(t *testing.T) {
+	agent, auth, keys := newAgent(t)
+
+	if _, err := Encrypt(keys, make([]byte, MaxPayload+1)); !errors.Is(err, ErrPayloadTooLarge) || !IsPermanent(err) {
+		t.Fatalf("oversized payload: got %v", err)
+	}
+	if _, err := Encrypt(Keys{P256dh: "not a key", Auth: keys.Auth}, nil); err == nil {
+		t.Fatal("invalid p256dh accepted")
+	}
+	if _, err := Encrypt(Keys{P256dh: keys.P256dh, Auth: b64.EncodeToString([]byte("short"))}, nil); err == nil {
+		t.Fatal("short auth secret accepted")
+	}
+
+	body, _ := Encrypt(keys, []byte("secret"))
+	wrongAuth := append([]byte{}, auth...)
+	wrongAuth[0] ^= 1
+	if _, err := Decrypt(body, agent, wrongAuth); err == nil {
+		t.Fatal("decrypted with the wrong auth secret")
+	}
+	tampered := append([]byte{}, body...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := Decrypt(tampered, agent, auth); err == nil {
+		t.Fatal("decrypted a tampered message")
+	}
+}
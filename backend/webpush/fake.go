@@ -0,0 +1,225 @@
+package webpush
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Received is a push accepted by FakeService
+type Received struct {
+	Endpoint   string    `json:"endpoint"`
+	Payload    string    `json:"payload"` // decrypted
+	TTL        string    `json:"ttl"`
+	Urgency    string    `json:"urgency,omitempty"`
+	Topic      string    `json:"topic,omitempty"`
+	Subject    string    `json:"subject"` // sub claim of the VAPID token
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+type fakeSubscription struct {
+	key     *ecdh.PrivateKey
+	auth    []byte
+	expired bool
+}
+
+// FakeService is a push service for local development and tests. It hands out subscriptions
+// with keys it keeps, so it can check the VAPID signature of every push and decrypt it:
+//
+//	POST   /subscriptions  creates a subscription to register with the backend
+//	GET    /messages       lists the pushes received
+//	POST   /push/{id}      the endpoint of a subscription; 410 Gone once it expired
+//	DELETE /push/{id}      expires the subscription, as when a user revokes permission
+type FakeService struct {
+	BaseURL string // where the service is reachable; the prefix of every endpoint
+
+	mux      *http.ServeMux
+	mu       sync.Mutex
+	subs     map[string]*fakeSubscription
+	received []Received
+}
+
+func NewFakeService(baseURL string) *FakeService {
+	f := &FakeService{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		mux:     http.NewServeMux(),
+		subs:    map[string]*fakeSubscription{},
+	}
+	f.mux.HandleFunc("POST /subscriptions", func(w http.ResponseWriter, r *http.Request) {
+		sub, err := f.NewSubscription()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, sub)
+	})
+	f.mux.HandleFunc("GET /messages", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, f.Received())
+	})
+	f.mux.HandleFunc("DELETE /push/{id}", func(w http.ResponseWriter, r *http.Request) {
+		f.Expire(f.BaseURL + "/push/" + r.PathValue("id"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	f.mux.HandleFunc("POST /push/{id}", f.push)
+	return f
+}
+
+// NewSubscription creates a subscription whose pushes the service accepts
+func (f *FakeService) NewSubscription() (Subscription, error) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return Subscription{}, err
+	}
+	auth := make([]byte, 16)
+	if _, err := rand.Read(auth); err != nil {
+		return Subscription{}, err
+	}
+	id := randomID()
+
+	f.mu.Lock()
+	f.subs[id] = &fakeSubscription{key: key, auth: auth}
+	f.mu.Unlock()
+
+	return Subscription{
+		Endpoint: f.BaseURL + "/push/" + id,
+		Keys: Keys{
+			P256dh: b64.EncodeToString(key.PublicKey().Bytes()),
+			Auth:   b64.EncodeToString(auth),
+		},
+	}, nil
+}
+
+// Expire makes further pushes to the endpoint fail with 410 Gone
+func (f *FakeService) Expire(endpoint string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if sub, ok := f.subs[strings.TrimPrefix(endpoint, f.BaseURL+"/push/")]; ok {
+		sub.expired = true
+	}
+}
+
+// Received returns the pushes accepted so far
+func (f *FakeService) Received() []Received {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Received(nil), f.received...)
+}
+
+func (f *FakeService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mux.ServeHTTP(w, r)
+}
+
+// push checks a push like a real push service would and records it
+func (f *FakeService) push(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	sub, ok := f.subs[r.PathValue("id")]
+	f.mu.Unlock()
+	if !ok || sub.expired {
+		http.Error(w, "subscription expired", http.StatusGone)
+		return
+	}
+
+	subject, err := f.verify(r.Header.Get("Authorization"))
+	if err != nil {
+		http.Error(w, "invalid VAPID authorization: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if r.Header.Get("Content-Encoding") != "aes128gcm" {
+		http.Error(w, "Content-Encoding must be aes128gcm", http.StatusUnsupportedMediaType)
+		return
+	}
+	if r.Header.Get("TTL") == "" {
+		http.Error(w, "missing TTL", http.StatusBadRequest)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, recordSize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body) > recordSize {
+		http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	payload, err := Decrypt(body, sub.key, sub.auth)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	f.received = append(f.received, Received{
+		Endpoint:   f.BaseURL + r.URL.Path,
+		Payload:    string(payload),
+		TTL:        r.Header.Get("TTL"),
+		Urgency:    r.Header.Get("Urgency"),
+		Topic:      r.Header.Get("Topic"),
+		Subject:    subject,
+		ReceivedAt: time.Now(),
+	})
+	f.mu.Unlock()
+	w.WriteHeader(http.StatusCreated)
+}
+
+// verify checks a "vapid t=<jwt>, k=<public key>" header and returns the token's subject
+func (f *FakeService) verify(header string) (string, error) {
+	var token, key string
+	for _, part := range strings.Split(strings.TrimPrefix(header, "vapid "), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch name {
+		case "t":
+			token = value
+		case "k":
+			key = value
+		}
+	}
+	raw, err := decodeBase64(key)
+	if err != nil || len(raw) != 65 || !strings.HasPrefix(header, "vapid ") {
+		return "", jwt.ErrTokenMalformed
+	}
+	public := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(raw[1:33]),
+		Y:     new(big.Int).SetBytes(raw[33:]),
+	}
+
+	base, err := url.Parse(f.BaseURL)
+	if err != nil {
+		return "", err
+	}
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return public, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodES256.Alg()}),
+		jwt.WithAudience(base.Scheme+"://"+base.Host),
+		jwt.WithExpirationRequired(),
+	); err != nil {
+		return "", err
+	}
+	subject, _ := claims.GetSubject()
+	return subject, nil
+}
+
+func randomID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return b64.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
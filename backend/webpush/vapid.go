@@ -0,0 +1,88 @@
+package webpush
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// VAPID identifies the application to push services (RFC 8292). Browsers only accept pushes
+// signed by the key the subscription was created with, so the keys must not change.
+type VAPID struct {
+	PrivateKey *ecdsa.PrivateKey
+	PublicKey  string // uncompressed P-256 point, base64url; the applicationServerKey of subscribe()
+	Subject    string
+}
+
+// GenerateVAPIDKeys returns a new key pair in the base64url form ParseVAPID reads
+func GenerateVAPIDKeys() (publicKey, privateKey string, err error) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return b64.EncodeToString(key.PublicKey().Bytes()), b64.EncodeToString(key.Bytes()), nil
+}
+
+// ParseVAPID reads base64url keys as printed by GenerateVAPIDKeys
+func ParseVAPID(publicKey, privateKey, subject string) (*VAPID, error) {
+	raw, err := decodeBase64(privateKey)
+	if err != nil {
+		return nil, errors.New("webpush: VAPID private key is not base64url")
+	}
+	key, err := ecdh.P256().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("webpush: invalid VAPID private key: %w", err)
+	}
+	public := key.PublicKey().Bytes()
+	if b64.EncodeToString(public) != publicKey {
+		return nil, errors.New("webpush: VAPID public key does not belong to the private key")
+	}
+
+	return &VAPID{
+		PrivateKey: &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(public[1:33]),
+				Y:     new(big.Int).SetBytes(public[33:]),
+			},
+			D: new(big.Int).SetBytes(raw),
+		},
+		PublicKey: publicKey,
+		Subject:   subject,
+	}, nil
+}
+
+// authorization returns the Authorization header of a push to endpoint: an ES256 JWT for the
+// push service's origin and the public key to verify it with
+func (v *VAPID) authorization(endpoint string, now time.Time) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("webpush: invalid endpoint %q", endpoint)
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": now.Add(12 * time.Hour).Unix(),
+		"sub": v.Subject,
+	}).SignedString(v.PrivateKey)
+	if err != nil {
+		return "", fmt.Errorf("webpush: %w", err)
+	}
+	return "vapid t=" + token + ", k=" + v.PublicKey, nil
+}
+
+var b64 = base64.RawURLEncoding
+
+// decodeBase64 accepts base64url with or without padding, as libraries differ
+func decodeBase64(s string) ([]byte, error) {
+	return b64.DecodeString(strings.TrimRight(s, "="))
+}
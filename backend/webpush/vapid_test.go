@@ -0,0 +1,94 @@
+package webpush
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseVAPIDKnownKey(t *testing.T) {
+	vapid, err := ParseVAPID(rfcServerPublic, rfcServerPrivate, "mailto:admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	public := mustDecode(t, rfcServerPublic)
+	if vapid.PrivateKey.X.Cmp(new(big.Int).SetBytes(public[1:33])) != 0 || vapid.PrivateKey.Y.Cmp(new(big.Int).SetBytes(public[33:])) != 0 {
+		t.Fatal("public point does not match the known key")
+	}
+	if !vapid.PrivateKey.Curve.IsOnCurve(vapid.PrivateKey.X, vapid.PrivateKey.Y) {
+		t.Fatal("public point is not on P-256")
+	}
+
+	if _, err := ParseVAPID(rfcAgentPublic, rfcServerPrivate, ""); err == nil {
+		t.Fatal("accepted a public key of another private key")
+	}
+	if _, err := ParseVAPID(rfcServerPublic, "not base64!", ""); err == nil {
+		t.Fatal("accepted a malformed private key")
+	}
+}
+
+func TestVAPIDAuthorizationSignature(t *testing.T) {
+	vapid, err := ParseVAPID(rfcServerPublic, rfcServerPrivate, "mailto:admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	header, err := vapid.authorization("https://push.example.net/wpush/v2/abc?x=1", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, key, ok := strings.Cut(strings.TrimPrefix(header, "vapid t="), ", k=")
+	if !ok || !strings.HasPrefix(header, "vapid t=") || key != rfcServerPublic {
+		t.Fatalf("malformed header %q", header)
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("token has %d parts", len(parts))
+	}
+
+	// Verify ES256 independently of the JWT library: SHA-256 of header.claims, r || s signature
+	public := mustDecode(t, rfcServerPublic)
+	verifier := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(public[1:33]),
+		Y:     new(big.Int).SetBytes(public[33:]),
+	}
+	signature := mustDecode(t, parts[2])
+	if len(signature) != 64 {
+		t.Fatalf("signature is %d bytes, want 64", len(signature))
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+	if !ecdsa.Verify(verifier, digest[:], r, s) {
+		t.Fatal("signature does not verify with the known public key")
+	}
+	digest[0] ^= 1
+	if ecdsa.Verify(verifier, digest[:], r, s) {
+		t.Fatal("signature verifies a different message")
+	}
+
+	var head struct{ Alg, Typ string }
+	json.Unmarshal(mustDecode(t, parts[0]), &head)
+	if head.Alg != "ES256" || head.Typ != "JWT" {
+		t.Fatalf("header %+v", head)
+	}
+	var claims struct {
+		Aud string `json:"aud"`
+		Exp int64  `json:"exp"`
+		Sub string `json:"sub"`
+	}
+	json.Unmarshal(mustDecode(t, parts[1]), &claims)
+	if claims.Aud != "https://push.example.net" || claims.Exp != now.Add(12*time.Hour).Unix() || claims.Sub != "mailto:admin@example.com" {
+		t.Fatalf("claims %+v", claims)
+	}
+
+	if _, err := vapid.authorization("not a url", now); err == nil {
+		t.Fatal("authorization for an invalid endpoint")
+	}
+}
@@ -0,0 +1,164 @@
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Keys are the subscriber's public key (p256dh) and authentication secret (auth), base64url encoded
+type Keys struct {
+	P256dh string `json:"p256dh"`
+	Auth   string `json:"auth"`
+}
+
+// Subscription is what the browser's PushSubscription.toJSON() returns
+type Subscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     Keys   `json:"keys"`
+}
+
+// Validate checks that the endpoint is an absolute URL, https unless allowHTTP, and that the
+// keys can be used for encryption
+func (s Subscription) Validate(allowHTTP bool) error {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && !(allowHTTP && u.Scheme == "http")) {
+		return errors.New("endpoint must be an https URL")
+	}
+	raw, err := decodeBase64(s.Keys.P256dh)
+	if err != nil {
+		return errors.New("keys.p256dh is not base64url")
+	}
+	if _, err := ecdh.P256().NewPublicKey(raw); err != nil {
+		return errors.New("keys.p256dh is not a P-256 public key")
+	}
+	if auth, err := decodeBase64(s.Keys.Auth); err != nil || len(auth) != 16 {
+		return errors.New("keys.auth must be 16 bytes of base64url")
+	}
+	return nil
+}
+
+// Urgency values of RFC 8030; push services may hold back less urgent messages to save battery
+const (
+	UrgencyVeryLow = "very-low"
+	UrgencyLow     = "low"
+	UrgencyNormal  = "normal"
+	UrgencyHigh    = "high"
+)
+
+// Message is one push; Payload is encrypted for the subscriber before it is sent
+type Message struct {
+	Payload []byte
+	TTL     time.Duration // how long the push service keeps it while the device is offline
+	Urgency string
+	Topic   string // a newer message with the same topic replaces an undelivered one
+}
+
+// Error is a push the push service refused
+type Error struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("webpush: push service responded %d: %s", e.StatusCode, e.Body)
+}
+
+// IsGone reports whether the subscription no longer exists (404 or 410) and should be deleted
+func IsGone(err error) bool {
+	var pushErr *Error
+	return errors.As(err, &pushErr) && (pushErr.StatusCode == http.StatusNotFound || pushErr.StatusCode == http.StatusGone)
+}
+
+// IsPermanent reports whether retrying the push will not help, e.g. it is too large or the
+// VAPID keys were rejected; rate limiting and server errors are temporary
+func IsPermanent(err error) bool {
+	var pushErr *Error
+	if !errors.As(err, &pushErr) {
+		return errors.Is(err, ErrPayloadTooLarge)
+	}
+	return pushErr.StatusCode < 500 && pushErr.StatusCode != http.StatusTooManyRequests
+}
+
+// Sender encrypts messages (RFC 8291) and posts them to the subscriptions' push services
+// (RFC 8030), identifying the application with VAPID (RFC 8292)
+type Sender struct {
+	VAPID  *VAPID
+	Client *http.Client
+}
+
+func NewSender(vapid *VAPID) *Sender {
+	return &Sender{
+		VAPID:  vapid,
+		Client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// NewFromEnv returns a sender for VAPID_PUBLIC_KEY and VAPID_PRIVATE_KEY (see GenerateVAPIDKeys),
+// or nil when they are not set, which disables push. VAPID_SUBJECT is the contact push services
+// use when there is a problem, a mailto: or https: URL.
+func NewFromEnv() (*Sender, error) {
+	public, private := os.Getenv("VAPID_PUBLIC_KEY"), os.Getenv("VAPID_PRIVATE_KEY")
+	if public == "" && private == "" {
+		return nil, nil
+	}
+	subject := os.Getenv("VAPID_SUBJECT")
+	if subject == "" {
+		subject = "mailto:no-reply@rumail.ru.ac.th"
+	}
+	vapid, err := ParseVAPID(public, private, subject)
+	if err != nil {
+		return nil, err
+	}
+	return NewSender(vapid), nil
+}
+
+// Send delivers msg to the subscription
+func (s *Sender) Send(ctx context.Context, sub Subscription, msg Message) error {
+	body, err := Encrypt(sub.Keys, msg.Payload)
+	if err != nil {
+		return err
+	}
+	auth, err := s.VAPID.authorization(sub.Endpoint, time.Now())
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webpush: %w", err)
+	}
+	ttl := msg.TTL
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.Itoa(int(ttl.Seconds())))
+	if msg.Urgency != "" {
+		req.Header.Set("Urgency", msg.Urgency)
+	}
+	if msg.Topic != "" {
+		req.Header.Set("Topic", msg.Topic)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webpush: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	text, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return &Error{StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(text))}
+}
@@ -0,0 +1,78 @@
+package webpush
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newFakePush(t *testing.T) (*FakeService, *Sender) {
+	t.Helper()
+	server := httptest.NewUnstartedServer(nil)
+	fake := NewFakeService("http://" + server.Listener.Addr().String())
+	server.Config.Handler = fake
+	server.Start()
+	t.Cleanup(server.Close)
+
+	public, private, err := GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vapid, err := ParseVAPID(public, private, "mailto:test@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, NewSender(vapid)
+}
+
+func TestSendToFakeService(t *testing.T) {
+	fake, sender := newFakePush(t)
+	sub, err := fake.NewSubscription()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.Validate(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.Validate(false); err == nil {
+		t.Fatal("http endpoint accepted without allowHTTP")
+	}
+
+	msg := Message{Payload: []byte(`{"title":"hi"}`), TTL: time.Hour, Urgency: UrgencyHigh, Topic: "chat1"}
+	if err := sender.Send(context.Background(), sub, msg); err != nil {
+		t.Fatal(err)
+	}
+	received := fake.Received()
+	if len(received) != 1 {
+		t.Fatalf("fake service received %d pushes", len(received))
+	}
+	got := received[0]
+	if got.Endpoint != sub.Endpoint || got.Payload != `{"title":"hi"}` || got.TTL != "3600" ||
+		got.Urgency != UrgencyHigh || got.Topic != "chat1" || got.Subject != "mailto:test@example.com" {
+		t.Fatalf("received %+v", got)
+	}
+
+	fake.Expire(sub.Endpoint)
+	err = sender.Send(context.Background(), sub, msg)
+	if !IsGone(err) || !IsPermanent(err) {
+		t.Fatalf("push to an expired subscription: got %v", err)
+	}
+}
+
+func TestFakeServiceRejectsForeignVAPIDKey(t *testing.T) {
+	fake, sender := newFakePush(t)
+	sub, _ := fake.NewSubscription()
+
+	// A token signed by another key than the one it names
+	_, other := newFakePush(t)
+	sender.VAPID.PrivateKey = other.VAPID.PrivateKey
+
+	err := sender.Send(context.Background(), sub, Message{Payload: []byte("x")})
+	if err == nil || IsGone(err) || !IsPermanent(err) {
+		t.Fatalf("got %v, want a permanent authorization error", err)
+	}
+	if len(fake.Received()) != 0 {
+		t.Fatal("push with an invalid signature was accepted")
+	}
+}
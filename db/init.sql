@@ -1,5 +1,6 @@
--- Enable UUID extension
+-- Enable UUID and trigram (chat search) extensions
 CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
 
 -- Users table
 CREATE TABLE users (
@@ -17,6 +18,8 @@ CREATE TABLE users (
     password_reset_token VARCHAR(255),
     password_reset_expires TIMESTAMP,
     profile_image TEXT,
+    -- Language of emails sent to the user
+    language VARCHAR(5) DEFAULT 'th' CHECK (language IN ('th', 'en')),
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
@@ -71,6 +74,15 @@ CREATE TABLE projects (
     expected_end_date DATE,
     actual_end_date DATE,
     grade VARCHAR(5),
+    -- Term the project was registered in (Buddhist Era year, semester 1, 2 or 3 = summer)
+    academic_year INTEGER,
+    semester SMALLINT CHECK (semester IN (1, 2, 3)),
+    -- Public showcase: the student consents (pending), then the advisor publishes
+    abstract TEXT,
+    publish_status VARCHAR(20) DEFAULT 'private' CHECK (publish_status IN ('private', 'pending', 'published')),
+    publish_consent_at TIMESTAMP,
+    published_at TIMESTAMP,
+    published_by UUID REFERENCES users(id),
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
@@ -79,20 +91,137 @@ CREATE TABLE projects (
 CREATE TABLE project_files (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
     project_id UUID REFERENCES projects(id) ON DELETE CASCADE,
+    document_id UUID NOT NULL,
     uploaded_by UUID REFERENCES users(id),
     file_name VARCHAR(255) NOT NULL,
     file_path TEXT NOT NULL,
     file_size BIGINT,
     file_type VARCHAR(100),
     file_category VARCHAR(50) CHECK (file_category IN ('proposal', 'progress_report', 'final_report', 'presentation', 'source_code', 'other')),
-    file_status VARCHAR(20) DEFAULT 'pending' CHECK (file_status IN ('pending', 'approved', 'rejected')),
+    file_status VARCHAR(20) DEFAULT 'pending' CHECK (file_status IN ('pending', 'approved', 'rejected', 'revision_requested')),
     version INTEGER DEFAULT 1,
     description TEXT,
     is_public BOOLEAN DEFAULT FALSE,
+    scan_status VARCHAR(20) DEFAULT 'not_scanned' CHECK (scan_status IN ('not_scanned', 'clean', 'infected')),
+    scan_result TEXT,
+    scanned_at TIMESTAMP,
+    preview_status VARCHAR(20) DEFAULT 'none' CHECK (preview_status IN ('none', 'pending', 'processing', 'ready', 'failed')),
+    preview_key TEXT,
+    thumbnail_key TEXT,
+    preview_error TEXT,
+    preview_attempts INTEGER DEFAULT 0,
+    previewed_at TIMESTAMP,
+    similarity_status VARCHAR(20) DEFAULT 'none' CHECK (similarity_status IN ('none', 'pending', 'processing', 'ready', 'failed')),
+    similarity_score REAL,
+    similarity_flagged BOOLEAN DEFAULT FALSE,
+    similarity_error TEXT,
+    similarity_attempts INTEGER DEFAULT 0,
+    similarity_checked_at TIMESTAMP,
+    sha256 VARCHAR(64),
+    integrity_status VARCHAR(20) DEFAULT 'unverified' CHECK (integrity_status IN ('unverified', 'ok', 'corrupt', 'missing')),
+    verified_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    -- Every version of a document shares document_id (the id of its first version)
+    CONSTRAINT uq_project_files_document_version UNIQUE (document_id, version)
+);
+
+-- Content-addressed blobs shared by identical uploads (file_path = 'blobs/<sha256 prefix>/<sha256>').
+-- ref_count is maintained by triggers on project_files; a blob without references is left for storage-gc.
+CREATE TABLE file_blobs (
+    sha256 VARCHAR(64) PRIMARY KEY,
+    storage_key TEXT NOT NULL,
+    file_size BIGINT,
+    content_type VARCHAR(100),
+    ref_count INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- File reviews (one row per review round; the latest decision is copied to project_files.file_status)
+CREATE TABLE file_reviews (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    file_id UUID REFERENCES project_files(id) ON DELETE CASCADE,
+    document_id UUID NOT NULL,
+    reviewer_id UUID REFERENCES users(id),
+    round INTEGER NOT NULL,
+    decision VARCHAR(20) NOT NULL CHECK (decision IN ('approved', 'rejected', 'revision_requested')),
+    comments TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT uq_file_reviews_document_round UNIQUE (document_id, round)
+);
+
+-- Review comments: threads (parent_id) and page-anchored PDF annotations (page + normalized rectangle)
+CREATE TABLE file_review_comments (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    file_id UUID REFERENCES project_files(id) ON DELETE CASCADE,
+    review_id UUID REFERENCES file_reviews(id) ON DELETE SET NULL,
+    parent_id UUID REFERENCES file_review_comments(id) ON DELETE CASCADE,
+    author_id UUID REFERENCES users(id),
+    body TEXT NOT NULL,
+    page INTEGER CHECK (page >= 1),
+    anchor_x REAL CHECK (anchor_x BETWEEN 0 AND 1),
+    anchor_y REAL CHECK (anchor_y BETWEEN 0 AND 1),
+    anchor_width REAL CHECK (anchor_width BETWEEN 0 AND 1),
+    anchor_height REAL CHECK (anchor_height BETWEEN 0 AND 1),
+    resolved BOOLEAN DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Similarity (plagiarism) index: MinHash signature per file, LSH buckets and the closest matches found
+CREATE TABLE similarity_fingerprints (
+    file_id UUID PRIMARY KEY REFERENCES project_files(id) ON DELETE CASCADE,
+    signature BYTEA NOT NULL,
+    shingle_count INTEGER NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE similarity_bands (
+    file_id UUID REFERENCES project_files(id) ON DELETE CASCADE,
+    band SMALLINT NOT NULL,
+    bucket BIGINT NOT NULL,
+    PRIMARY KEY (file_id, band)
+);
+
+CREATE TABLE similarity_matches (
+    file_id UUID REFERENCES project_files(id) ON DELETE CASCADE,
+    matched_file_id UUID REFERENCES project_files(id) ON DELETE CASCADE,
+    score REAL NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (file_id, matched_file_id)
+);
+
+-- Resumable upload sessions (chunked uploads)
+CREATE TABLE upload_sessions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    project_id UUID REFERENCES projects(id) ON DELETE CASCADE,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    document_id UUID,
+    file_name VARCHAR(255) NOT NULL,
+    file_category VARCHAR(50),
+    description TEXT,
+    file_size BIGINT NOT NULL,
+    chunk_size BIGINT NOT NULL,
+    total_chunks INTEGER NOT NULL,
+    checksum VARCHAR(64),
+    status VARCHAR(20) DEFAULT 'active' CHECK (status IN ('active', 'assembling', 'completed', 'aborted', 'expired', 'failed')),
+    file_id UUID REFERENCES project_files(id) ON DELETE SET NULL,
+    expires_at TIMESTAMP NOT NULL,
+    completed_at TIMESTAMP,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+CREATE TABLE upload_chunks (
+    session_id UUID REFERENCES upload_sessions(id) ON DELETE CASCADE,
+    chunk_index INTEGER NOT NULL,
+    size BIGINT NOT NULL,
+    sha256 VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (session_id, chunk_index)
+);
+
 -- Notifications table
 CREATE TABLE notifications (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
@@ -103,8 +232,110 @@ CREATE TABLE notifications (
     priority VARCHAR(20) DEFAULT 'medium' CHECK (priority IN ('low', 'medium', 'high')),
     is_read BOOLEAN DEFAULT FALSE,
     related_project_id UUID REFERENCES projects(id),
+    -- Event that created the notification, e.g. file.reviewed; preferences are chosen per event type
+    event_type VARCHAR(50) DEFAULT 'general',
+    -- The user turned in-app notifications of this type off; the row is kept for email and push
+    hidden BOOLEAN DEFAULT FALSE,
     sent_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     read_at TIMESTAMP,
+    -- Archived notifications are left out of the list and the unread count
+    archived_at TIMESTAMP,
+    -- Set on the copies of an announcement (foreign key added with the announcements table);
+    -- their read state gives its read statistics
+    announcement_id UUID,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Announcements table
+-- Messages admins broadcast to a role, a term, an advisor's students or a set of projects.
+-- Each is fanned out into a notification per recipient once scheduled_at has passed.
+CREATE TABLE announcements (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    title VARCHAR(255) NOT NULL,
+    message TEXT NOT NULL,
+    type VARCHAR(50) DEFAULT 'info' CHECK (type IN ('info', 'warning', 'success', 'error')),
+    priority VARCHAR(20) DEFAULT 'medium' CHECK (priority IN ('low', 'medium', 'high')),
+    target_type VARCHAR(20) NOT NULL CHECK (target_type IN ('all', 'role', 'term', 'advisor', 'projects')),
+    target_role VARCHAR(20) CHECK (target_role IN ('student', 'advisor', 'admin')),
+    target_academic_year INTEGER,
+    target_semester SMALLINT CHECK (target_semester IN (1, 2, 3)),
+    target_advisor_id UUID REFERENCES advisors(id) ON DELETE CASCADE,
+    target_project_ids UUID[],
+    -- Pinned announcements stay on top of the recipients' dashboards until they expire
+    pinned BOOLEAN DEFAULT FALSE,
+    expires_at TIMESTAMP,
+    status VARCHAR(20) DEFAULT 'scheduled' CHECK (status IN ('scheduled', 'sending', 'sent', 'cancelled')),
+    scheduled_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMP,
+    recipient_count INTEGER DEFAULT 0,
+    created_by UUID REFERENCES users(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE notifications ADD CONSTRAINT notifications_announcement_id_fkey
+    FOREIGN KEY (announcement_id) REFERENCES announcements(id) ON DELETE CASCADE;
+
+-- How each user wants to be notified; users without a row get the defaults
+CREATE TABLE notification_preferences (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    -- {"<event type>": {"in_app": true, "email": false, "push": true}}; missing types use every channel
+    channels JSONB NOT NULL DEFAULT '{}',
+    -- Email and push wait until the quiet hours (HH:MM, Thailand time) end
+    quiet_hours_start VARCHAR(5) CHECK (quiet_hours_start ~ '^(([01][0-9]|2[0-3]):[0-5][0-9])?$'),
+    quiet_hours_end VARCHAR(5) CHECK (quiet_hours_end ~ '^(([01][0-9]|2[0-3]):[0-5][0-9])?$'),
+    digest_frequency VARCHAR(10) DEFAULT 'daily' CHECK (digest_frequency IN ('immediate', 'daily', 'weekly')),
+    muted_project_ids UUID[] DEFAULT '{}',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Delivery of notifications outside the app (email, web push), one row per notification and channel
+CREATE TABLE notification_deliveries (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    notification_id UUID REFERENCES notifications(id) ON DELETE CASCADE,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    channel VARCHAR(20) NOT NULL CHECK (channel IN ('email', 'webpush')),
+    status VARCHAR(20) DEFAULT 'pending' CHECK (status IN ('pending', 'processing', 'sent', 'failed', 'skipped')),
+    -- Low-priority notifications are batched into a daily digest sent at next_attempt_at
+    digest BOOLEAN DEFAULT FALSE,
+    attempts INTEGER DEFAULT 0,
+    next_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_error TEXT,
+    sent_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (notification_id, channel)
+);
+
+-- Web Push subscriptions of the users' browsers (PushSubscription.toJSON()); removed once the push service reports them gone
+CREATE TABLE push_subscriptions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    endpoint TEXT UNIQUE NOT NULL,
+    p256dh VARCHAR(100) NOT NULL,
+    auth VARCHAR(50) NOT NULL,
+    user_agent TEXT,
+    expires_at TIMESTAMP,
+    last_success_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Deadline reminders already sent, so each reminder window notifies once per due date
+CREATE TABLE deadline_reminders (
+    project_id UUID REFERENCES projects(id) ON DELETE CASCADE,
+    due_date DATE NOT NULL,
+    days_before INTEGER NOT NULL,
+    sent_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (project_id, due_date, days_before)
+);
+
+-- Realtime broadcasts too large for a NOTIFY payload; other replicas read them by id, old rows are deleted
+CREATE TABLE hub_broadcasts (
+    id BIGSERIAL PRIMARY KEY,
+    room VARCHAR(255) NOT NULL,
+    message TEXT NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
@@ -133,11 +364,33 @@ CREATE INDEX idx_users_role ON users(role);
 CREATE INDEX idx_projects_student_id ON projects(student_id);
 CREATE INDEX idx_projects_advisor_id ON projects(advisor_id);
 CREATE INDEX idx_projects_status ON projects(status);
+CREATE INDEX idx_projects_term ON projects(academic_year, semester);
+CREATE INDEX idx_projects_published ON projects(published_at DESC) WHERE publish_status = 'published';
+CREATE INDEX idx_projects_keywords ON projects USING GIN (keywords);
+CREATE INDEX idx_projects_expected_end_date ON projects(expected_end_date) WHERE status IN ('approved', 'in_progress');
 CREATE INDEX idx_notifications_user_id ON notifications(user_id);
 CREATE INDEX idx_notifications_is_read ON notifications(is_read);
+CREATE INDEX idx_notifications_user_created ON notifications(user_id, created_at DESC, id DESC);
+CREATE UNIQUE INDEX idx_notifications_announcement_user ON notifications(announcement_id, user_id) WHERE announcement_id IS NOT NULL;
+CREATE INDEX idx_announcements_queue ON announcements(scheduled_at) WHERE status IN ('scheduled', 'sending');
+CREATE INDEX idx_hub_broadcasts_created_at ON hub_broadcasts(created_at);
+CREATE INDEX idx_push_subscriptions_user_id ON push_subscriptions(user_id);
+CREATE INDEX idx_notification_deliveries_queue ON notification_deliveries(next_attempt_at) WHERE status IN ('pending', 'processing');
 CREATE INDEX idx_project_files_project_id ON project_files(project_id);
 CREATE INDEX idx_project_files_file_status ON project_files(file_status);
+CREATE INDEX idx_project_files_document_id ON project_files(document_id);
+CREATE INDEX idx_project_files_preview_queue ON project_files(created_at) WHERE preview_status IN ('pending', 'processing');
+CREATE INDEX idx_project_files_similarity_queue ON project_files(created_at) WHERE similarity_status IN ('pending', 'processing');
+CREATE INDEX idx_project_files_similarity_flagged ON project_files(similarity_flagged) WHERE similarity_flagged;
+CREATE INDEX idx_project_files_sha256 ON project_files(sha256);
+CREATE INDEX idx_project_files_file_path ON project_files(file_path);
+CREATE INDEX idx_similarity_bands_bucket ON similarity_bands(band, bucket);
+CREATE INDEX idx_similarity_matches_matched_file_id ON similarity_matches(matched_file_id);
+CREATE INDEX idx_file_reviews_file_id ON file_reviews(file_id);
+CREATE INDEX idx_file_review_comments_file_id ON file_review_comments(file_id);
+CREATE INDEX idx_file_review_comments_parent_id ON file_review_comments(parent_id);
 CREATE INDEX idx_logs_user_id ON logs(user_id);
+CREATE INDEX idx_upload_sessions_status_expires ON upload_sessions(status, expires_at);
 
 -- Create Trigger for updated_at
 CREATE OR REPLACE FUNCTION update_updated_at_column()
@@ -153,7 +406,42 @@ CREATE TRIGGER update_advisors_updated_at BEFORE UPDATE ON advisors FOR EACH ROW
 CREATE TRIGGER update_students_updated_at BEFORE UPDATE ON students FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
 CREATE TRIGGER update_projects_updated_at BEFORE UPDATE ON projects FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
 CREATE TRIGGER update_project_files_updated_at BEFORE UPDATE ON project_files FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE TRIGGER update_file_review_comments_updated_at BEFORE UPDATE ON file_review_comments FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE TRIGGER update_upload_sessions_updated_at BEFORE UPDATE ON upload_sessions FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
 CREATE TRIGGER update_system_settings_updated_at BEFORE UPDATE ON system_settings FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE TRIGGER update_file_blobs_updated_at BEFORE UPDATE ON file_blobs FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE TRIGGER update_notification_preferences_updated_at BEFORE UPDATE ON notification_preferences FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE TRIGGER update_notification_deliveries_updated_at BEFORE UPDATE ON notification_deliveries FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE TRIGGER update_push_subscriptions_updated_at BEFORE UPDATE ON push_subscriptions FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE TRIGGER update_announcements_updated_at BEFORE UPDATE ON announcements FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Create Trigger for blob reference counts (also covers rows removed by ON DELETE CASCADE)
+CREATE OR REPLACE FUNCTION maintain_file_blob_refs()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO file_blobs (sha256, storage_key, file_size, content_type, ref_count)
+        VALUES (NEW.sha256, NEW.file_path, NEW.file_size, NEW.file_type, 1)
+        ON CONFLICT (sha256) DO UPDATE SET ref_count = file_blobs.ref_count + 1;
+        RETURN NEW;
+    END IF;
+    UPDATE file_blobs SET ref_count = ref_count - 1 WHERE sha256 = OLD.sha256;
+    DELETE FROM file_blobs WHERE sha256 = OLD.sha256 AND ref_count <= 0;
+    RETURN OLD;
+END;
+  $$ LANGUAGE 'plpgsql';
+
+CREATE TRIGGER project_files_blob_insert
+AFTER INSERT ON project_files
+FOR EACH ROW
+WHEN (NEW.sha256 IS NOT NULL AND NEW.file_path LIKE 'blobs/%')
+EXECUTE FUNCTION maintain_file_blob_refs();
+
+CREATE TRIGGER project_files_blob_delete
+AFTER DELETE ON project_files
+FOR EACH ROW
+WHEN (OLD.sha256 IS NOT NULL AND OLD.file_path LIKE 'blobs/%')
+EXECUTE FUNCTION maintain_file_blob_refs();
 
 -- Create Trigger for advisor capacity
 CREATE OR REPLACE FUNCTION check_advisor_capacity()
@@ -177,10 +465,19 @@ EXECUTE FUNCTION check_advisor_capacity();
 INSERT INTO system_settings (setting_key, setting_value, description) VALUES
 ('site_name', 'ระบบจัดการโครงงานพิเศษ', 'ชื่อของเว็บไซต์'),
 ('academic_year', '2568', 'ปีการศึกษาปัจจุบัน'),
+('current_semester', '1', 'ภาคการศึกษาปัจจุบัน (1, 2 หรือ 3 = ภาคฤดูร้อน)'),
 ('registration_open', 'true', 'เปิดให้สมัครสมาชิกหรือไม่'),
 ('max_file_size_mb', '50', 'ขนาดไฟล์สูงสุดที่อัปโหลดได้ (MB)'),
-('allowed_file_types', 'pdf,doc,docx,ppt,pptx,zip,rar', 'ประเภทไฟล์ที่อนุญาต'),
-('notification_email_enabled', 'true', 'เปิดใช้งานการแจ้งเตือนผ่าน Email');
+('allowed_file_types', 'pdf,doc,docx,ppt,pptx,zip,rar,txt,jpg,jpeg,png', 'ประเภทไฟล์ที่อนุญาต'),
+('max_file_size_mb.source_code', '200', 'ขนาดไฟล์สูงสุดสำหรับซอร์สโค้ด (MB)'),
+('allowed_file_types.source_code', 'zip,rar', 'ประเภทไฟล์ที่อนุญาตสำหรับซอร์สโค้ด'),
+('max_file_size_mb.presentation', '200', 'ขนาดไฟล์สูงสุดสำหรับงานนำเสนอ (MB)'),
+('allowed_file_types.presentation', 'pdf,ppt,pptx,mp4', 'ประเภทไฟล์ที่อนุญาตสำหรับงานนำเสนอ'),
+('similarity_threshold_percent', '40', 'เปอร์เซ็นต์ความคล้ายที่ถือว่าต้องให้อาจารย์ตรวจสอบ'),
+('project_quota_mb', '1024', 'พื้นที่เก็บไฟล์สูงสุดต่อโครงงาน (MB, 0 = ไม่จำกัด)'),
+('user_quota_mb', '2048', 'พื้นที่เก็บไฟล์สูงสุดต่อผู้อัปโหลด (MB, 0 = ไม่จำกัด)'),
+('notification_email_enabled', 'true', 'เปิดใช้งานการแจ้งเตือนผ่าน Email'),
+('notification_digest_hour', '8', 'เวลาส่งอีเมลสรุปการแจ้งเตือนที่ไม่เร่งด่วนประจำวัน (ชั่วโมง 0-23 เวลาประเทศไทย)');
 
 -- Chat messages table
 CREATE TABLE chat_messages (
@@ -198,6 +495,29 @@ CREATE TABLE chat_messages (
 CREATE INDEX idx_chat_messages_project ON chat_messages(project_id);
 CREATE INDEX idx_chat_messages_created ON chat_messages(created_at DESC);
 
+-- Which user has read which chat message; is_read above only says whether anyone has
+CREATE TABLE message_reads (
+    message_id UUID NOT NULL REFERENCES chat_messages(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    read_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (message_id, user_id)
+);
+
+-- The last message each user has read in a project's chat
+CREATE TABLE chat_read_cursors (
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    message_id UUID NOT NULL REFERENCES chat_messages(id) ON DELETE CASCADE,
+    message_created_at TIMESTAMP NOT NULL,
+    read_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (project_id, user_id)
+);
+
+CREATE INDEX idx_chat_messages_project_created ON chat_messages(project_id, created_at, id);
+CREATE INDEX idx_message_reads_user ON message_reads(user_id);
+-- Chat search matches words anywhere in a message (Thai has no spaces between words)
+CREATE INDEX idx_chat_messages_message_trgm ON chat_messages USING GIN (message gin_trgm_ops);
+
 INSERT INTO users (email, password_hash, full_name, role, is_verified, student_id) VALUES
 ('admin@rumail.ru.ac.th', '$2a$10$ZMug6Ajy03J14alMl9/SFO6azhvL5fMLTfXQjYHl0tgUY1IAKP4GK', 'ผู้ดูแลระบบ', 'admin', TRUE, NULL),
 ('advisor1@rumail.ru.ac.th', '$2a$10$aNAqEY0fUm3mlovQ2SNaxuu8L.VNFc8fXPxkn18kOzWZMh1jRQBku', 'ผศ.ดร.สมชาย วิทยาคอม', 'advisor', TRUE, NULL),
@@ -45,6 +45,8 @@ export default function ProjectChat({ params }: { params: Promise<{ id: string }
   const [sending, setSending] = useState(false);
   const [isConnected, setIsConnected] = useState(false);
   const [isTyping, setIsTyping] = useState(false);
+  const [hasMore, setHasMore] = useState(false);
+  const [loadingOlder, setLoadingOlder] = useState(false);
   const router = useRouter();
 
   const wsRef = useRef<WebSocket | null>(null);
@@ -53,6 +55,7 @@ export default function ProjectChat({ params }: { params: Promise<{ id: string }
   const reconnectTimeoutRef = useRef<NodeJS.Timeout | undefined>(undefined);
   const sendingMessageRef = useRef(false); // Flag to prevent cleanup during send
   const cleanupBlockedRef = useRef(false); // Block cleanup during message send
+  const keepScrollRef = useRef(false); // Older messages were prepended, don't jump to the bottom
 
   const baseUrl = process.env.NEXT_PUBLIC_API || "http://localhost:8081";
 
@@ -63,6 +66,10 @@ export default function ProjectChat({ params }: { params: Promise<{ id: string }
 
   useEffect(() => {
     console.log('Messages updated:', messages.length, messages);
+    if (keepScrollRef.current) {
+      keepScrollRef.current = false;
+      return;
+    }
     scrollToBottom();
   }, [messages]);
 
@@ -111,6 +118,7 @@ export default function ProjectChat({ params }: { params: Promise<{ id: string }
         const messagesData = await response.json();
         console.log('Messages loaded:', messagesData);
         setMessages(messagesData || []);
+        setHasMore(response.headers.get('X-Has-More') === 'true');
       } else {
         console.error('Failed to load messages:', response.statusText);
         setMessages([]);
@@ -121,6 +129,44 @@ export default function ProjectChat({ params }: { params: Promise<{ id: string }
     }
   };
 
+  // The history API returns the newest 50 messages; older pages are fetched before the oldest one shown
+  const loadOlderMessages = async () => {
+    if (loadingOlder || messages.length === 0) return;
+    setLoadingOlder(true);
+    try {
+      const token = localStorage.getItem("token");
+      const response = await fetch(`${baseUrl}/api/chats/${id}/messages?before=${messages[0].id}`, {
+        headers: token ? { 'Authorization': `Bearer ${token}` } : {}
+      });
+
+      if (response.ok) {
+        const olderMessages: Message[] = (await response.json()) || [];
+        const container = document.getElementById('chat-container');
+        const previousHeight = container?.scrollHeight ?? 0;
+
+        keepScrollRef.current = true;
+        setMessages((prev) => {
+          const known = new Set(prev.map((m) => m.id));
+          return [...olderMessages.filter((m) => !known.has(m.id)), ...prev];
+        });
+        setHasMore(response.headers.get('X-Has-More') === 'true');
+
+        // Keep the messages that were on screen in place
+        requestAnimationFrame(() => {
+          if (container) {
+            container.scrollTop += container.scrollHeight - previousHeight;
+          }
+        });
+      } else {
+        console.error('Failed to load older messages:', response.statusText);
+      }
+    } catch (error) {
+      console.error("Error loading older messages:", error);
+    } finally {
+      setLoadingOlder(false);
+    }
+  };
+
   // WebSocket connection
   useEffect(() => {
     const token = localStorage.getItem('token');
@@ -467,6 +513,17 @@ export default function ProjectChat({ params }: { params: Promise<{ id: string }
             id="chat-container"
             className="flex-1 overflow-y-auto p-6 space-y-4"
           >
+            {hasMore && (
+              <div className="text-center">
+                <button
+                  onClick={loadOlderMessages}
+                  disabled={loadingOlder}
+                  className="text-sm text-blue-600 hover:text-blue-800 disabled:text-gray-400"
+                >
+                  {loadingOlder ? 'กำลังโหลด...' : 'โหลดข้อความก่อนหน้า'}
+                </button>
+              </div>
+            )}
             {messages.length === 0 ? (
               <div className="text-center py-12">
                 <MessageCircle className="w-16 h-16 text-gray-400 mx-auto mb-4" />